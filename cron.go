@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/executor"
+	"github.com/mmacdo54/go-redis-clone/internal/latency"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// Background tasks run this often
+const CRON_INTERVAL = 100 * time.Millisecond
+
+// The active expire cycle removes expired keys in batches of this many, and
+// stops once a batch isn't full or it has run for its time budget
+const (
+	ACTIVE_EXPIRE_KEYS_PER_LOOP = 20
+	ACTIVE_EXPIRE_CYCLE_BUDGET  = 25 * time.Millisecond
+)
+
+// serverCron runs the periodic background tasks, like Redis' serverCron
+func serverCron(store storage.Store, config *configuration.Config) {
+	ticker := time.NewTicker(CRON_INTERVAL)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		closeIdleClients(config.Get().Timeout)
+		activeExpireCycle(store)
+		stats.Sample()
+	}
+}
+
+// activeExpireCycle removes expired keys that nobody reads, which would
+// otherwise only be removed when they are next accessed. Batches run on the
+// executor like commands, so keys never expire in the middle of one.
+func activeExpireCycle(store storage.Store) {
+	start := time.Now()
+	for time.Since(start) < ACTIVE_EXPIRE_CYCLE_BUDGET {
+		var removed int
+		var err error
+		executor.RunExclusive(func() {
+			removed, err = store.DeleteExpired(ACTIVE_EXPIRE_KEYS_PER_LOOP)
+		})
+		if err != nil {
+			logger.Warning("Active expire cycle: %v", err)
+			break
+		}
+		if removed < ACTIVE_EXPIRE_KEYS_PER_LOOP {
+			break
+		}
+	}
+	latency.AddSample(latency.EVENT_EXPIRE_CYCLE, time.Since(start))
+}
+
+// closeIdleClients disconnects clients that haven't sent a command for the
+// timeout in seconds. Subscribers only receive, so they are never idle.
+func closeIdleClients(timeout int) {
+	if timeout == 0 {
+		return
+	}
+
+	for _, c := range connection.All() {
+		if c.IsSubscribed() || c.Idle() < time.Duration(timeout)*time.Second {
+			continue
+		}
+		logger.Verbose("Closing idle client id=%d addr=%s", c.ID, c.Addr())
+		c.Close()
+	}
+}
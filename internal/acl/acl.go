@@ -0,0 +1,253 @@
+package acl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const DEFAULT_USER = "default"
+
+// Categories are the ACL command categories, as in Redis
+var Categories = []string{
+	"all", "keyspace", "read", "write", "set", "sortedset", "list", "hash",
+	"string", "bitmap", "hyperloglog", "geo", "stream", "pubsub", "admin",
+	"fast", "slow", "blocking", "dangerous", "connection", "transaction",
+	"scripting",
+}
+
+var (
+	users    = map[string]*User{}
+	commands = map[string]bool{}
+	aclFile  string
+	mutex    sync.RWMutex
+)
+
+// RegisterCommand makes a command known so that it can be used in rules
+func RegisterCommand(name string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	commands[strings.ToLower(name)] = true
+}
+
+// commandExists is only called while the registry is locked
+func commandExists(name string) bool {
+	return commands[name]
+}
+
+// Init creates the default user, protected by requirepass if it is set, and
+// loads the users in the aclfile if one is configured.
+func Init(requirepass string, file string) error {
+	mutex.Lock()
+	users = map[string]*User{DEFAULT_USER: newDefaultUser(requirepass)}
+	aclFile = file
+	mutex.Unlock()
+
+	if file != "" {
+		return Load()
+	}
+
+	return nil
+}
+
+func newDefaultUser(password string) *User {
+	u := newUser(DEFAULT_USER)
+	for _, r := range []string{"on", "allkeys", "allchannels", "allcommands"} {
+		u.applyRule(r)
+	}
+	if password == "" {
+		u.applyRule("nopass")
+	} else {
+		u.applyRule(">" + password)
+	}
+
+	return u
+}
+
+// SetDefaultPassword changes the default user's password when requirepass
+// changes. An empty password lets anyone authenticate as the default user.
+func SetDefaultPassword(password string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	u := users[DEFAULT_USER].clone()
+	u.applyRule("resetpass")
+	if password == "" {
+		u.applyRule("nopass")
+	} else {
+		u.applyRule(">" + password)
+	}
+	users[DEFAULT_USER] = u
+}
+
+// GetUser returns the named user, or nil if it doesn't exist
+func GetUser(name string) *User {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return users[name]
+}
+
+// Users returns every user sorted by name
+func Users() []*User {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	all := []*User{}
+	for _, u := range users {
+		all = append(all, u)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+
+	return all
+}
+
+// SetUser creates or updates a user. Either every rule is applied or, if one
+// is invalid, the user is left unchanged.
+func SetUser(name string, rules []string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	u, err := buildUser(users[name], name, rules)
+	if err != nil {
+		return err
+	}
+	users[name] = u
+
+	return nil
+}
+
+func buildUser(existing *User, name string, rules []string) (*User, error) {
+	if strings.ContainsAny(name, " \t\r\n") {
+		return nil, fmt.Errorf("Usernames can't contain spaces or null characters")
+	}
+
+	u := newUser(name)
+	if existing != nil {
+		u = existing.clone()
+	}
+	for _, r := range rules {
+		if err := u.applyRule(r); err != nil {
+			return nil, fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", r, err)
+		}
+	}
+
+	return u, nil
+}
+
+// DeleteUsers removes users and returns how many existed
+func DeleteUsers(names []string) (int, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	for _, name := range names {
+		if name == DEFAULT_USER {
+			return 0, fmt.Errorf("The 'default' user cannot be removed")
+		}
+	}
+
+	deleted := 0
+	for _, name := range names {
+		if _, ok := users[name]; ok {
+			delete(users, name)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Authenticate checks a username and password pair, failing for disabled
+// users
+func Authenticate(name string, password string) (*User, error) {
+	u := GetUser(name)
+	if u == nil || !u.Enabled || !u.checkPassword(password) {
+		return nil, fmt.Errorf("invalid username-password pair or user is disabled.")
+	}
+
+	return u, nil
+}
+
+// AuthenticatedByDefault reports whether new connections are logged in as
+// the default user without calling AUTH
+func AuthenticatedByDefault() bool {
+	u := GetUser(DEFAULT_USER)
+	return u != nil && u.Enabled && u.NoPass
+}
+
+var ErrNoACLFile = fmt.Errorf("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// Load replaces every user with those defined in the aclfile. Nothing is
+// changed if any line of the file is invalid.
+func Load() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if aclFile == "" {
+		return ErrNoACLFile
+	}
+
+	f, err := os.Open(aclFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	loaded := map[string]*User{}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: line should start with user keyword", aclFile, lineNumber)
+		}
+		if _, ok := loaded[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", aclFile, lineNumber, fields[1])
+		}
+		u, err := buildUser(nil, fields[1], fields[2:])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", aclFile, lineNumber, err)
+		}
+		loaded[u.Name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if _, ok := loaded[DEFAULT_USER]; !ok {
+		loaded[DEFAULT_USER] = users[DEFAULT_USER]
+	}
+	users = loaded
+
+	return nil
+}
+
+// Save writes every user to the aclfile
+func Save() error {
+	if aclFile == "" {
+		return ErrNoACLFile
+	}
+
+	var content strings.Builder
+	for _, u := range Users() {
+		content.WriteString(u.String())
+		content.WriteString("\n")
+	}
+
+	tmp := aclFile + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, aclFile)
+}
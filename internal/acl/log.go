@@ -0,0 +1,102 @@
+package acl
+
+import (
+	"sync"
+	"time"
+)
+
+// Reasons a denial is recorded in the ACL log
+const (
+	LOG_REASON_COMMAND = "command"
+	LOG_REASON_KEY     = "key"
+	LOG_REASON_CHANNEL = "channel"
+	LOG_REASON_AUTH    = "auth"
+)
+
+// Identical denials within this window are grouped into one entry
+const LOG_GROUPING_WINDOW = 60 * time.Second
+
+// LogEntry is a denied command or failed authentication shown by ACL LOG
+type LogEntry struct {
+	ID         int64
+	Count      int
+	Reason     string
+	Context    string
+	Object     string
+	Username   string
+	ClientInfo string
+	Created    time.Time
+	Updated    time.Time
+}
+
+var (
+	logEntries []LogEntry
+	logMaxLen  = 128
+	logLastID  int64
+	logMutex   sync.Mutex
+)
+
+// SetLogMaxLen changes how many entries the log keeps, dropping the oldest
+func SetLogMaxLen(n int) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logMaxLen = n
+	trimLog()
+}
+
+func trimLog() {
+	if len(logEntries) > logMaxLen {
+		logEntries = logEntries[:logMaxLen]
+	}
+}
+
+// AddLogEntry records a denial, newest first
+func AddLogEntry(reason string, context string, object string, username string, clientInfo string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	now := time.Now()
+	for i, e := range logEntries {
+		if e.Reason == reason && e.Context == context && e.Object == object && e.Username == username && now.Sub(e.Updated) < LOG_GROUPING_WINDOW {
+			e.Count++
+			e.Updated = now
+			e.ClientInfo = clientInfo
+			logEntries = append(logEntries[:i], logEntries[i+1:]...)
+			logEntries = append([]LogEntry{e}, logEntries...)
+			return
+		}
+	}
+
+	entry := LogEntry{
+		ID:         logLastID,
+		Count:      1,
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		Created:    now,
+		Updated:    now,
+	}
+	logLastID++
+	logEntries = append([]LogEntry{entry}, logEntries...)
+	trimLog()
+}
+
+// LogEntries returns up to count of the most recent entries
+func LogEntries(count int) []LogEntry {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+
+	if count > len(logEntries) {
+		count = len(logEntries)
+	}
+
+	return append([]LogEntry{}, logEntries[:count]...)
+}
+
+func ResetLog() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logEntries = nil
+}
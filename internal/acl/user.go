@@ -0,0 +1,376 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/glob"
+)
+
+// User is an ACL user. Users are never modified once they are registered,
+// SETUSER replaces them with an updated copy, so they can be read without
+// locking.
+type User struct {
+	Name      string
+	Enabled   bool
+	NoPass    bool
+	passwords []string
+	commands  []commandRule
+	keys      []keyPattern
+	channels  []string
+}
+
+// commandRule allows or denies a command, a subcommand or a whole category.
+// Rules are applied in order, so the last rule matching a command wins.
+type commandRule struct {
+	allow      bool
+	category   string
+	command    string
+	subcommand string
+}
+
+type keyPattern struct {
+	pattern string
+	read    bool
+	write   bool
+}
+
+func newUser(name string) *User {
+	return &User{Name: name}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commands = slices.Clone(u.commands)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRule changes the user according to a single ACL SETUSER rule such as
+// on, >password, ~keys:*, &channel or +@read
+func (u *User) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch {
+	case lower == "on":
+		u.Enabled = true
+	case lower == "off":
+		u.Enabled = false
+	case lower == "nopass":
+		u.NoPass = true
+		u.passwords = nil
+	case lower == "resetpass":
+		u.NoPass = false
+		u.passwords = nil
+	case lower == "allkeys":
+		u.keys = []keyPattern{{pattern: "*", read: true, write: true}}
+	case lower == "resetkeys":
+		u.keys = nil
+	case lower == "allchannels":
+		u.channels = []string{"*"}
+	case lower == "resetchannels":
+		u.channels = nil
+	case lower == "allcommands":
+		u.commands = []commandRule{{allow: true, category: "all"}}
+	case lower == "nocommands":
+		u.commands = nil
+	case lower == "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "nocommands"} {
+			u.applyRule(r)
+		}
+	case strings.HasPrefix(rule, ">"):
+		u.addPassword(hashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "#"):
+		if !isPasswordHash(rule[1:]) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.addPassword(rule[1:])
+	case strings.HasPrefix(rule, "<"):
+		return u.removePassword(hashPassword(rule[1:]))
+	case strings.HasPrefix(rule, "!"):
+		if !isPasswordHash(rule[1:]) {
+			return fmt.Errorf("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		return u.removePassword(rule[1:])
+	case strings.HasPrefix(rule, "~"):
+		u.addKeyPattern(keyPattern{pattern: rule[1:], read: true, write: true})
+	case strings.HasPrefix(rule, "%"):
+		return u.applyKeyPermissionRule(rule)
+	case strings.HasPrefix(rule, "&"):
+		if !slices.Contains(u.channels, "*") && !slices.Contains(u.channels, rule[1:]) {
+			u.channels = append(u.channels, rule[1:])
+		}
+	case strings.HasPrefix(rule, "+"), strings.HasPrefix(rule, "-"):
+		return u.applyCommandRule(rule)
+	default:
+		return fmt.Errorf("Syntax error")
+	}
+
+	return nil
+}
+
+// hasPassword compares the hash with every one of the user's, in constant
+// time, so that the time taken doesn't tell how much of a hash matched
+func (u *User) hasPassword(hash string) bool {
+	found := 0
+	for _, p := range u.passwords {
+		found |= subtle.ConstantTimeCompare([]byte(p), []byte(hash))
+	}
+	return found == 1
+}
+
+func (u *User) addPassword(hash string) {
+	u.NoPass = false
+	if !u.hasPassword(hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+}
+
+func (u *User) removePassword(hash string) error {
+	i := slices.Index(u.passwords, hash)
+	if i == -1 {
+		return fmt.Errorf("The password you are trying to remove from the user does not exist")
+	}
+	u.passwords = slices.Delete(u.passwords, i, i+1)
+	return nil
+}
+
+func (u *User) addKeyPattern(p keyPattern) {
+	for i, existing := range u.keys {
+		if existing.pattern == p.pattern {
+			u.keys[i].read = existing.read || p.read
+			u.keys[i].write = existing.write || p.write
+			return
+		}
+	}
+	u.keys = append(u.keys, p)
+}
+
+// applyKeyPermissionRule handles %R~pattern, %W~pattern and %RW~pattern
+func (u *User) applyKeyPermissionRule(rule string) error {
+	permissions, pattern, ok := strings.Cut(rule[1:], "~")
+	if !ok || permissions == "" {
+		return fmt.Errorf("Syntax error")
+	}
+
+	p := keyPattern{pattern: pattern}
+	for _, c := range strings.ToUpper(permissions) {
+		switch c {
+		case 'R':
+			p.read = true
+		case 'W':
+			p.write = true
+		default:
+			return fmt.Errorf("Syntax error")
+		}
+	}
+	u.addKeyPattern(p)
+
+	return nil
+}
+
+func (u *User) applyCommandRule(rule string) error {
+	r := commandRule{allow: rule[0] == '+'}
+	name := strings.ToLower(rule[1:])
+
+	if strings.HasPrefix(name, "@") {
+		r.category = name[1:]
+		if !slices.Contains(Categories, r.category) {
+			return fmt.Errorf("Unknown command or category name in ACL")
+		}
+		if r.category == "all" {
+			// Allowing or denying everything makes earlier rules irrelevant
+			u.commands = nil
+			if !r.allow {
+				return nil
+			}
+		}
+	} else {
+		command, subcommand, _ := strings.Cut(name, "|")
+		if !commandExists(command) {
+			return fmt.Errorf("Unknown command or category name in ACL")
+		}
+		if strings.Contains(subcommand, "|") {
+			return fmt.Errorf("Syntax error")
+		}
+		r.command = command
+		r.subcommand = subcommand
+	}
+
+	u.commands = append(u.commands, r)
+	return nil
+}
+
+func (r commandRule) matches(command string, subcommand string, categories []string) bool {
+	if r.category != "" {
+		return r.category == "all" || slices.Contains(categories, r.category)
+	}
+	if r.command != command {
+		return false
+	}
+	return r.subcommand == "" || r.subcommand == subcommand
+}
+
+func (r commandRule) String() string {
+	sign := "-"
+	if r.allow {
+		sign = "+"
+	}
+	if r.category != "" {
+		return sign + "@" + r.category
+	}
+	if r.subcommand != "" {
+		return sign + r.command + "|" + r.subcommand
+	}
+	return sign + r.command
+}
+
+// CanRunCommand reports whether the user may run a command. The categories
+// are those of the subcommand when the command has one.
+func (u *User) CanRunCommand(command string, subcommand string, categories []string) bool {
+	command = strings.ToLower(command)
+	subcommand = strings.ToLower(subcommand)
+
+	allowed := false
+	for _, r := range u.commands {
+		if r.matches(command, subcommand, categories) {
+			allowed = r.allow
+		}
+	}
+
+	return allowed
+}
+
+// CanAccessKey reports whether the user may read and/or write a key
+func (u *User) CanAccessKey(key string, read bool, write bool) bool {
+	for _, p := range u.keys {
+		if (!read || p.read) && (!write || p.write) && glob.Match(p.pattern, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanAccessChannel reports whether the user may publish or subscribe to a
+// channel
+func (u *User) CanAccessChannel(channel string) bool {
+	for _, p := range u.channels {
+		if glob.Match(p, channel) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (u *User) checkPassword(password string) bool {
+	if u.NoPass {
+		return true
+	}
+
+	return u.hasPassword(hashPassword(password))
+}
+
+// Flags returns the flags shown by ACL GETUSER
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.Enabled {
+		flags[0] = "on"
+	}
+	if u.NoPass {
+		flags = append(flags, "nopass")
+	}
+
+	return flags
+}
+
+// Passwords returns the hashes of the user's passwords
+func (u *User) Passwords() []string {
+	return slices.Clone(u.passwords)
+}
+
+// CommandRules describes the allowed commands, e.g. "+@all -@dangerous"
+func (u *User) CommandRules() string {
+	rules := []string{}
+	if len(u.commands) == 0 || u.commands[0].category != "all" {
+		rules = append(rules, "-@all")
+	}
+	for _, r := range u.commands {
+		rules = append(rules, r.String())
+	}
+
+	return strings.Join(rules, " ")
+}
+
+// KeyRules describes the accessible keys, e.g. "~cache:* %R~config:*"
+func (u *User) KeyRules() string {
+	rules := []string{}
+	for _, p := range u.keys {
+		switch {
+		case p.read && p.write:
+			rules = append(rules, "~"+p.pattern)
+		case p.read:
+			rules = append(rules, "%R~"+p.pattern)
+		default:
+			rules = append(rules, "%W~"+p.pattern)
+		}
+	}
+
+	return strings.Join(rules, " ")
+}
+
+// ChannelRules describes the accessible channels, e.g. "&events:*"
+func (u *User) ChannelRules() string {
+	rules := []string{}
+	for _, p := range u.channels {
+		rules = append(rules, "&"+p)
+	}
+
+	return strings.Join(rules, " ")
+}
+
+// String describes the user with the rules needed to recreate it, in the
+// format used by ACL LIST and the aclfile
+func (u *User) String() string {
+	parts := []string{"user", u.Name}
+	parts = append(parts, u.Flags()...)
+	for _, p := range u.passwords {
+		parts = append(parts, "#"+p)
+	}
+	if len(u.keys) == 0 {
+		parts = append(parts, "resetkeys")
+	} else {
+		parts = append(parts, u.KeyRules())
+	}
+	if len(u.channels) == 0 {
+		parts = append(parts, "resetchannels")
+	} else {
+		parts = append(parts, u.ChannelRules())
+	}
+	parts = append(parts, u.CommandRules())
+
+	return strings.Join(parts, " ")
+}
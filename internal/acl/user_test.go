@@ -0,0 +1,230 @@
+package acl
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	for _, name := range []string{"get", "set", "del", "config", "client"} {
+		RegisterCommand(name)
+	}
+	os.Exit(m.Run())
+}
+
+// user builds a user from the rules, failing the test if any is invalid
+func user(t *testing.T, rules ...string) *User {
+	t.Helper()
+
+	u, err := buildUser(nil, "test", rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestRuleFlags(t *testing.T) {
+	u := user(t, "on")
+	if !u.Enabled {
+		t.Error("on left the user disabled")
+	}
+	if u = user(t, "on", "OFF"); u.Enabled {
+		t.Error("off left the user enabled")
+	}
+	if u = user(t, ">pass", "nopass"); !u.NoPass || len(u.passwords) != 0 {
+		t.Errorf("nopass left NoPass %v and %d passwords", u.NoPass, len(u.passwords))
+	}
+	if u = user(t, "nopass", ">pass"); u.NoPass {
+		t.Error("adding a password left the user without one")
+	}
+}
+
+func TestRulePasswords(t *testing.T) {
+	u := user(t, ">first", ">second", ">first")
+	if len(u.passwords) != 2 {
+		t.Errorf("the user has %d passwords, want 2", len(u.passwords))
+	}
+	for password, want := range map[string]bool{"first": true, "second": true, "third": false, "": false} {
+		if got := u.checkPassword(password); got != want {
+			t.Errorf("checkPassword(%q) = %v, want %v", password, got, want)
+		}
+	}
+
+	u = user(t, ">first", ">second", "<first")
+	if u.checkPassword("first") || !u.checkPassword("second") {
+		t.Error("<first didn't remove only the first password")
+	}
+	if _, err := buildUser(nil, "test", []string{">first", "<other"}); err == nil {
+		t.Error("removing a password the user doesn't have succeeded")
+	}
+
+	u = user(t, "#"+hashPassword("hashed"))
+	if !u.checkPassword("hashed") {
+		t.Error("a password added by its hash isn't accepted")
+	}
+	if u = user(t, "#"+hashPassword("hashed"), "!"+hashPassword("hashed")); len(u.passwords) != 0 {
+		t.Error("a password removed by its hash is still there")
+	}
+	for _, rule := range []string{"#abc", "#" + hashPassword("x")[1:] + "G", "!abc"} {
+		if _, err := buildUser(nil, "test", []string{rule}); err == nil {
+			t.Errorf("%q was accepted", rule)
+		}
+	}
+
+	// A user without passwords or nopass can't log in at all
+	if user(t, "on").checkPassword("") {
+		t.Error("a user without passwords accepted an empty one")
+	}
+}
+
+func TestRuleKeys(t *testing.T) {
+	u := user(t, "~cache:*", "%R~config:*", "%W~log:*", "%RW~both:*")
+	tests := []struct {
+		key         string
+		read, write bool
+		want        bool
+	}{
+		{"cache:a", true, true, true},
+		{"config:a", true, false, true},
+		{"config:a", false, true, false},
+		{"config:a", true, true, false},
+		{"log:a", false, true, true},
+		{"log:a", true, false, false},
+		{"both:a", true, true, true},
+		{"other", true, false, false},
+		{"cache", true, false, false},
+	}
+	for _, test := range tests {
+		if got := u.CanAccessKey(test.key, test.read, test.write); got != test.want {
+			t.Errorf("CanAccessKey(%q, read %v, write %v) = %v, want %v", test.key, test.read, test.write, got, test.want)
+		}
+	}
+
+	// Patterns given twice combine their permissions
+	u = user(t, "%R~k", "%W~k")
+	if !u.CanAccessKey("k", true, true) || len(u.keys) != 1 {
+		t.Errorf("%%R~k %%W~k gave %s", u.KeyRules())
+	}
+	if u = user(t, "allkeys"); !u.CanAccessKey("anything", true, true) {
+		t.Error("allkeys doesn't allow every key")
+	}
+	if u = user(t, "allkeys", "resetkeys"); u.CanAccessKey("anything", true, false) {
+		t.Error("resetkeys left keys accessible")
+	}
+	for _, rule := range []string{"%~k", "%X~k", "%Rk"} {
+		if _, err := buildUser(nil, "test", []string{rule}); err == nil {
+			t.Errorf("%q was accepted", rule)
+		}
+	}
+}
+
+func TestRuleChannels(t *testing.T) {
+	u := user(t, "&news", "&events:*")
+	for channel, want := range map[string]bool{"news": true, "newsy": false, "events:a": true, "events": false} {
+		if got := u.CanAccessChannel(channel); got != want {
+			t.Errorf("CanAccessChannel(%q) = %v, want %v", channel, got, want)
+		}
+	}
+
+	if u = user(t, "allchannels", "&news"); len(u.channels) != 1 || !u.CanAccessChannel("anything") {
+		t.Errorf("allchannels then &news gave %s", u.ChannelRules())
+	}
+	if u = user(t, "allchannels", "resetchannels"); u.CanAccessChannel("anything") {
+		t.Error("resetchannels left channels accessible")
+	}
+}
+
+func TestRuleCommands(t *testing.T) {
+	u := user(t, "+@read", "-get", "+config|get", "+set")
+	tests := []struct {
+		command    string
+		subcommand string
+		categories []string
+		want       bool
+	}{
+		{"GET", "", []string{"read", "string"}, false},
+		{"strlen", "", []string{"read", "string"}, true},
+		{"set", "", []string{"write", "string"}, true},
+		{"del", "", []string{"write", "keyspace"}, false},
+		{"config", "get", []string{"admin"}, true},
+		{"config", "set", []string{"admin"}, false},
+	}
+	for _, test := range tests {
+		if got := u.CanRunCommand(test.command, test.subcommand, test.categories); got != test.want {
+			t.Errorf("CanRunCommand(%q, %q) = %v, want %v", test.command, test.subcommand, got, test.want)
+		}
+	}
+	if got, want := u.CommandRules(), "-@all +@read -get +config|get +set"; got != want {
+		t.Errorf("CommandRules() = %q, want %q", got, want)
+	}
+
+	// +@all and -@all replace every earlier rule
+	if u = user(t, "-get", "+@all"); !u.CanRunCommand("get", "", nil) || u.CommandRules() != "+@all" {
+		t.Errorf("-get +@all gave %s", u.CommandRules())
+	}
+	if u = user(t, "+@all", "-@all", "+get"); u.CanRunCommand("set", "", nil) || u.CommandRules() != "-@all +get" {
+		t.Errorf("+@all -@all +get gave %s", u.CommandRules())
+	}
+	if u = user(t, "allcommands", "nocommands"); u.CanRunCommand("get", "", nil) {
+		t.Error("nocommands left commands allowed")
+	}
+
+	for _, rule := range []string{"+nosuch", "+@nosuch", "-nosuch|sub", "+config|get|x"} {
+		if _, err := buildUser(nil, "test", []string{rule}); err == nil {
+			t.Errorf("%q was accepted", rule)
+		}
+	}
+}
+
+func TestRuleReset(t *testing.T) {
+	u := user(t, "on", ">pass", "allkeys", "allchannels", "allcommands", "reset")
+	if u.Enabled || u.NoPass || len(u.passwords) != 0 || len(u.keys) != 0 || len(u.channels) != 0 || len(u.commands) != 0 {
+		t.Errorf("reset left %s", u)
+	}
+}
+
+func TestRuleSyntax(t *testing.T) {
+	for _, rule := range []string{"nosuch", "", "=get"} {
+		if _, err := buildUser(nil, "test", []string{rule}); err == nil {
+			t.Errorf("%q was accepted", rule)
+		}
+	}
+	if _, err := buildUser(nil, "with space", nil); err == nil {
+		t.Error("a username with a space was accepted")
+	}
+}
+
+// A rule that fails leaves the user as it was
+func TestSetUserInvalid(t *testing.T) {
+	if err := Init("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetUser("alice", []string{"on", ">pass", "~a:*"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetUser("alice", []string{"off", "~b:*", "+nosuch"}); err == nil {
+		t.Fatal("SetUser with an unknown command succeeded")
+	}
+
+	u := GetUser("alice")
+	if !u.Enabled || u.CanAccessKey("b:1", true, false) {
+		t.Errorf("a failed SetUser changed the user to %s", u)
+	}
+	if _, err := Authenticate("alice", "pass"); err != nil {
+		t.Errorf("Authenticate returned %v", err)
+	}
+	if _, err := Authenticate("alice", "wrong"); err == nil {
+		t.Error("Authenticate with the wrong password succeeded")
+	}
+
+	if err := SetUser("alice", []string{"off"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate("alice", "pass"); err == nil {
+		t.Error("a disabled user authenticated")
+	}
+	if users := Users(); !slices.ContainsFunc(users, func(u *User) bool { return u.Name == "alice" }) {
+		t.Error("alice isn't listed")
+	}
+}
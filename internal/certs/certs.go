@@ -0,0 +1,84 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// Values of tls-auth-clients
+const (
+	AUTH_CLIENTS_YES      = "yes"
+	AUTH_CLIENTS_NO       = "no"
+	AUTH_CLIENTS_OPTIONAL = "optional"
+)
+
+var current atomic.Pointer[tls.Config]
+
+// Load reads the server certificate, key and CA certificate and makes them
+// the ones used by every new TLS connection. Connections that are already
+// established keep the certificates they were started with.
+func Load(certFile string, keyFile string, caCertFile string, authClients string) error {
+	if certFile == "" || keyFile == "" {
+		return fmt.Errorf("tls-cert-file and tls-key-file must be set to use TLS")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load certificate: %s: %v", certFile, err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch authClients {
+	case AUTH_CLIENTS_NO:
+		config.ClientAuth = tls.NoClientCert
+	case AUTH_CLIENTS_OPTIONAL:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	if config.ClientAuth != tls.NoClientCert {
+		if caCertFile == "" {
+			return fmt.Errorf("tls-ca-cert-file must be set to authenticate TLS clients")
+		}
+		pem, err := os.ReadFile(caCertFile)
+		if err != nil {
+			return fmt.Errorf("Failed to load CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("Failed to load CA certificate: no certificates found in %s", caCertFile)
+		}
+		config.ClientCAs = pool
+	}
+
+	current.Store(config)
+	return nil
+}
+
+// ServerConfig returns a config for tls.NewListener that always hands out
+// the most recently loaded certificates
+func ServerConfig() *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return current.Load(), nil
+		},
+	}
+}
+
+// ClientCommonName returns the CN of the verified certificate presented by
+// the client, or an empty string if there wasn't one
+func ClientCommonName(state tls.ConnectionState) string {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	return state.VerifiedChains[0][0].Subject.CommonName
+}
@@ -0,0 +1,35 @@
+package cluster
+
+import "strings"
+
+// SLOTS is the number of hash slots the keyspace is divided into, matching
+// Redis Cluster so that keys and shard channels route to the same slots.
+const SLOTS = 16384
+
+// KeySlot returns the hash slot for a key or shard channel. If the key
+// contains a non-empty hash tag ({...}) only the tag is hashed.
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start != -1 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key)) & (SLOTS - 1)
+}
+
+// crc16 implements the CRC16-CCITT (XMODEM) checksum used by Redis Cluster.
+func crc16(key string) uint16 {
+	var crc uint16
+	for i := 0; i < len(key); i++ {
+		crc ^= uint16(key[i]) << 8
+		for b := 0; b < 8; b++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,128 @@
+package configuration
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Client classes of client-output-buffer-limit
+const (
+	CLIENT_CLASS_NORMAL  = "normal"
+	CLIENT_CLASS_REPLICA = "replica"
+	CLIENT_CLASS_PUBSUB  = "pubsub"
+)
+
+// BufferLimit disconnects a client whose pending output reaches Hard bytes,
+// or stays at or above Soft bytes for SoftSeconds. A zero limit is disabled.
+type BufferLimit struct {
+	Hard        int64
+	Soft        int64
+	SoftSeconds int
+}
+
+type OutputBufferLimits struct {
+	Normal  BufferLimit
+	Replica BufferLimit
+	Pubsub  BufferLimit
+}
+
+func defaultOutputBufferLimits() OutputBufferLimits {
+	return OutputBufferLimits{
+		Replica: BufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftSeconds: 60},
+		Pubsub:  BufferLimit{Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60},
+	}
+}
+
+var memoryUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1000,
+	"kb": 1024,
+	"m":  1000 * 1000,
+	"mb": 1024 * 1024,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// parseMemory reads a size such as 100, 32mb or 1g using the units accepted
+// in redis.conf
+func parseMemory(value string) (int64, error) {
+	value = strings.ToLower(value)
+	i := strings.IndexFunc(value, func(r rune) bool { return r < '0' || r > '9' })
+	if i == -1 {
+		i = len(value)
+	}
+
+	unit, ok := memoryUnits[value[i:]]
+	if !ok || i == 0 {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	n, err := strconv.ParseInt(value[:i], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+
+	return n * unit, nil
+}
+
+// setOutputBufferLimits parses one or more <class> <hard> <soft> <seconds>
+// groups, leaving the classes that aren't mentioned unchanged
+func setOutputBufferLimits(v *Values, args []string) error {
+	if len(args)%4 != 0 {
+		return fmt.Errorf("Wrong number of arguments in buffer limit configuration.")
+	}
+
+	limits := v.ClientOutputBufferLimits
+	for i := 0; i < len(args); i += 4 {
+		var limit *BufferLimit
+		switch strings.ToLower(args[i]) {
+		case CLIENT_CLASS_NORMAL:
+			limit = &limits.Normal
+		case CLIENT_CLASS_REPLICA, "slave":
+			limit = &limits.Replica
+		case CLIENT_CLASS_PUBSUB:
+			limit = &limits.Pubsub
+		default:
+			return fmt.Errorf("Invalid client class specified in buffer limit configuration.")
+		}
+
+		hard, err := parseMemory(args[i+1])
+		if err != nil {
+			return fmt.Errorf("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		soft, err := parseMemory(args[i+2])
+		if err != nil {
+			return fmt.Errorf("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		seconds, err := strconv.Atoi(args[i+3])
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("Error in hard, soft or soft_seconds setting in buffer limit configuration.")
+		}
+		*limit = BufferLimit{Hard: hard, Soft: soft, SoftSeconds: seconds}
+	}
+	v.ClientOutputBufferLimits = limits
+
+	return nil
+}
+
+func formatOutputBufferLimits(v Values) []string {
+	args := []string{}
+	for _, c := range []struct {
+		name  string
+		limit BufferLimit
+	}{
+		{CLIENT_CLASS_NORMAL, v.ClientOutputBufferLimits.Normal},
+		{CLIENT_CLASS_REPLICA, v.ClientOutputBufferLimits.Replica},
+		{CLIENT_CLASS_PUBSUB, v.ClientOutputBufferLimits.Pubsub},
+	} {
+		args = append(args,
+			c.name,
+			strconv.FormatInt(c.limit.Hard, 10),
+			strconv.FormatInt(c.limit.Soft, 10),
+			strconv.Itoa(c.limit.SoftSeconds),
+		)
+	}
+
+	return args
+}
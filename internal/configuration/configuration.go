@@ -2,69 +2,345 @@ package configuration
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
-	"io"
 	"os"
-	"regexp"
+	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+const (
+	PUBSUB_FANOUT_LOCAL    = "local"
+	PUBSUB_FANOUT_POSTGRES = "postgres"
 )
 
+// Modes of command-executor
+const (
+	// Commands run on their client's own goroutine, concurrently with
+	// those of other clients
+	COMMAND_EXECUTOR_THREADED = "threaded"
+	// Commands run one at a time in the order they arrive, like Redis' main
+	// thread
+	COMMAND_EXECUTOR_SINGLE = "single"
+	// Commands run one at a time per shard of key slots, so commands on
+	// keys in different shards run in parallel
+	COMMAND_EXECUTOR_SHARDED = "sharded"
+)
+
+// Includes deeper than this are assumed to be a cycle
+const MAX_INCLUDE_DEPTH = 16
+
+// Values holds the value of every config parameter at a point in time
+type Values struct {
+	Requirepass              string
+	NotifyKeyspaceEvents     int
+	PubsubFanout             string
+	KeyspaceChangeFeed       bool
+	Port                     int
+	Bind                     []string
+	UnixSocket               string
+	UnixSocketPerm           os.FileMode
+	Dir                      string
+	Timeout                  int
+	TCPKeepalive             int
+	MaxClients               int
+	ClientOutputBufferLimits OutputBufferLimits
+	LogLevel                 string
+	LogFile                  string
+	Databases                int
+	StorageBackend           string
+	StorageDSN               string
+	StorageFile              string
+	Appendfsync              string
+	MongoDBURI               string
+	MongoDBDatabase          string
+	MongoDBCollection        string
+	ACLFile                  string
+	ACLLogMaxLen             int
+	TLSPort                  int
+	TLSCertFile              string
+	TLSKeyFile               string
+	TLSCACertFile            string
+	TLSAuthClients           string
+	TLSAuthClientsUser       string
+	ShutdownTimeout          int
+	MetricsPort              int
+	SlowlogLogSlowerThan     int
+	SlowlogMaxLen            int
+	LatencyMonitorThreshold  int
+	CommandExecutor          string
+	CommandExecutorShards    int
+}
+
+// Config is the server wide registry of config parameters. It is safe for
+// concurrent use, and mutable parameters can be changed at runtime with Set.
 type Config struct {
-	Requirepass bool
-	password    string
+	mutex      sync.RWMutex
+	values     Values
+	configFile string
+	hooks      map[string][]ChangeHook
 }
 
-func InitConfig() (Config, error) {
-	config := Config{}
-	file, err := os.OpenFile("./redis.conf", os.O_RDWR|os.O_CREATE, 0644)
+// ChangeHook is run with the new values when a parameter changes at runtime,
+// and can reject the change by returning an error. Hooks run while the
+// registry is locked so they must not call back into it.
+type ChangeHook func(Values) error
 
+// ConfigError points at the line of the config file that could not be loaded
+type ConfigError struct {
+	File string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e ConfigError) Error() string {
+	return fmt.Sprintf("*** FATAL CONFIG FILE ERROR ***\nReading the configuration file %s, at line %d\n>>> '%s'\n%s", e.File, e.Line, e.Text, e.Err)
+}
+
+var errBadDirective = errors.New("Bad directive or wrong number of arguments")
+
+func defaultValues() Values {
+	return Values{
+		PubsubFanout:             PUBSUB_FANOUT_LOCAL,
+		Port:                     6379,
+		Dir:                      ".",
+		TCPKeepalive:             300,
+		MaxClients:               10000,
+		ClientOutputBufferLimits: defaultOutputBufferLimits(),
+		LogLevel:                 "notice",
+		Databases:                16,
+		StorageBackend:           storage.BACKEND_POSTGRES,
+		StorageDSN:               "host=localhost user=redis password=redis dbname=redis port=5432",
+		StorageFile:              "store.log",
+		Appendfsync:              storage.FSYNC_EVERYSEC,
+		MongoDBURI:               "mongodb://localhost:27017/?directConnection=true",
+		MongoDBDatabase:          "redis",
+		MongoDBCollection:        "kvs",
+		ACLLogMaxLen:             128,
+		ShutdownTimeout:          10,
+		SlowlogLogSlowerThan:     10000,
+		SlowlogMaxLen:            128,
+		CommandExecutor:          COMMAND_EXECUTOR_THREADED,
+		CommandExecutorShards:    16,
+
+		TLSAuthClients:     "yes",
+		TLSAuthClientsUser: "off",
+	}
+}
+
+// InitConfig loads the config file named by the first argument, or
+// ./redis.conf if no file is given and it exists, and then applies any
+// --directive value overrides from the remaining arguments.
+func InitConfig(args []string) (*Config, error) {
+	config := &Config{values: defaultValues(), hooks: map[string][]ChangeHook{}}
+
+	path := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+		path = args[0]
+		args = args[1:]
+	} else if _, err := os.Stat("./redis.conf"); err == nil {
+		path = "./redis.conf"
+	}
+
+	if path != "" {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return config, err
+		}
+		config.configFile = abs
+		if err := config.loadFile(path, 0); err != nil {
+			return config, err
+		}
+	}
+
+	overrides, err := parseCommandLine(args)
 	if err != nil {
 		return config, err
 	}
+	for _, o := range overrides {
+		if err := applyDirective(&config.values, o[0], o[1:]); err != nil {
+			return config, fmt.Errorf("Invalid command line option '--%s': %w", o[0], err)
+		}
+	}
+
+	return config, nil
+}
+
+// parseCommandLine groups arguments such as --port 7000 --bind 127.0.0.1 ::1
+// into directives.
+func parseCommandLine(args []string) ([][]string, error) {
+	directives := [][]string{}
+	for _, a := range args {
+		if strings.HasPrefix(a, "--") {
+			directives = append(directives, []string{strings.ToLower(a[2:])})
+			continue
+		}
+		if len(directives) == 0 {
+			return nil, fmt.Errorf("Unexpected command line argument '%s'", a)
+		}
+		directives[len(directives)-1] = append(directives[len(directives)-1], a)
+	}
+
+	return directives, nil
+}
+
+func (config *Config) loadFile(path string, depth int) error {
+	if depth > MAX_INCLUDE_DEPTH {
+		return fmt.Errorf("Too many nested includes loading %s", path)
+	}
 
-	reader := bufio.NewReader(file)
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	for {
-		line, _, err := reader.ReadLine()
-		if err == io.EOF {
-			break
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
 		}
+
+		args, err := splitArgs(line)
 		if err != nil {
-			return config, err
+			return ConfigError{File: path, Line: lineNumber, Text: line, Err: err}
 		}
-		if strings.HasPrefix(string(line), "#") {
+		if len(args) == 0 {
 			continue
 		}
-		key, value, _ := strings.Cut(string(line), " ")
-		switch key {
-		case "requirepass":
-			if err := config.parsePasswordConfig(value); err != nil {
-				return config, err
+
+		directive := strings.ToLower(args[0])
+		if directive == "include" {
+			if len(args) != 2 {
+				return ConfigError{File: path, Line: lineNumber, Text: line, Err: errBadDirective}
+			}
+			if err := config.loadFile(args[1], depth+1); err != nil {
+				return err
 			}
-		default:
 			continue
 		}
+
+		if err := applyDirective(&config.values, directive, args[1:]); err != nil {
+			return ConfigError{File: path, Line: lineNumber, Text: line, Err: err}
+		}
 	}
 
-	return config, nil
+	return scanner.Err()
 }
 
-func (config *Config) parsePasswordConfig(password string) error {
-	config.Requirepass = true
-	match, err := regexp.MatchString(`^[a-zA-Z0-9!&#$^<>-]{16,128}$`, password)
-	if err != nil {
-		return err
+func applyDirective(values *Values, directive string, args []string) error {
+	p, ok := params[directive]
+	if !ok {
+		return errBadDirective
 	}
-	if !match {
-		return fmt.Errorf("Invalid password supplied")
+	if len(args) == 0 || (!p.multi && len(args) != 1) {
+		return errBadDirective
 	}
-	config.password = password
-	return nil
+
+	return p.set(values, args)
 }
 
-func (config *Config) ValidatePassword(input string) error {
-	if input != config.password {
-		return fmt.Errorf("Wrong password")
+// Get returns a snapshot of the current parameter values
+func (config *Config) Get() Values {
+	config.mutex.RLock()
+	defer config.mutex.RUnlock()
+	return config.values
+}
+
+// ConfigFile returns the absolute path of the loaded config file, or an
+// empty string if the server was started without one
+func (config *Config) ConfigFile() string {
+	return config.configFile
+}
+
+// OnChange registers a hook that is run whenever the named parameter is
+// changed with Set
+func (config *Config) OnChange(name string, hook ChangeHook) {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+	config.hooks[name] = append(config.hooks[name], hook)
+}
+
+// Param is the name and current value of a config parameter
+type Param struct {
+	Name  string
+	Value string
+}
+
+// All returns every parameter and its current value, sorted by name
+func (config *Config) All() []Param {
+	values := config.Get()
+
+	all := []Param{}
+	for name, p := range params {
+		all = append(all, Param{Name: name, Value: strings.Join(p.get(values), " ")})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Name < all[j].Name
+	})
+
+	return all
+}
+
+// Set changes several parameters at once. Either every parameter is changed
+// or, if any value is invalid or rejected by a hook, none of them are.
+func (config *Config) Set(changes []Param) error {
+	config.mutex.Lock()
+	defer config.mutex.Unlock()
+
+	updated := config.values
+	seen := map[string]bool{}
+	for _, c := range changes {
+		name := strings.ToLower(c.Name)
+		p, ok := params[name]
+		if !ok {
+			return fmt.Errorf("Unknown option or number of arguments for CONFIG SET - '%s'", c.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", c.Name)
+		}
+		seen[name] = true
+		if !p.mutable {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - can't set immutable config", c.Name)
+		}
+
+		args := []string{c.Value}
+		if p.multi {
+			a, err := splitArgs(c.Value)
+			if err != nil {
+				return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", c.Name, err)
+			}
+			args = a
+		}
+		if err := p.set(&updated, args); err != nil {
+			return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", c.Name, err)
+		}
+	}
+
+	previous := config.values
+	config.values = updated
+	for name := range seen {
+		for _, hook := range config.hooks[name] {
+			if err := hook(updated); err != nil {
+				config.values = previous
+				for name := range seen {
+					for _, hook := range config.hooks[name] {
+						hook(previous)
+					}
+				}
+				return fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", name, err)
+			}
+		}
 	}
 
 	return nil
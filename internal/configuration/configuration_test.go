@@ -0,0 +1,162 @@
+package configuration
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a config file in dir and returns its path
+func writeConfig(t *testing.T, dir string, name string, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// configError loads the file and returns the ConfigError it fails with
+func configError(t *testing.T, path string) ConfigError {
+	t.Helper()
+
+	_, err := InitConfig([]string{path})
+	configErr := ConfigError{}
+	if !errors.As(err, &configErr) {
+		t.Fatalf("loading %s returned %v, want a ConfigError", path, err)
+	}
+	return configErr
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	included := writeConfig(t, dir, "included.conf", "maxclients 50", "loglevel warning")
+	path := writeConfig(t, dir, "redis.conf",
+		"# a comment",
+		"",
+		"   port 7000",
+		`BIND 127.0.0.1 "::1"`,
+		"include "+included,
+		"loglevel debug",
+	)
+
+	config, err := InitConfig([]string{path, "--port", "7001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := config.Get()
+	if v.Port != 7001 {
+		t.Errorf("port is %d, want the command line's 7001", v.Port)
+	}
+	if !slices.Equal(v.Bind, []string{"127.0.0.1", "::1"}) {
+		t.Errorf("bind is %q", v.Bind)
+	}
+	if v.MaxClients != 50 {
+		t.Errorf("maxclients is %d, want the included 50", v.MaxClients)
+	}
+	if v.LogLevel != "debug" {
+		t.Errorf("loglevel is %q, want the debug that follows the include", v.LogLevel)
+	}
+	if abs, _ := filepath.Abs(path); config.ConfigFile() != abs {
+		t.Errorf("ConfigFile is %q, want %q", config.ConfigFile(), abs)
+	}
+}
+
+// Errors point at the file and line they are on, counting comments and
+// blank lines
+func TestLoadFileErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		line  int
+		text  string
+	}{
+		{"unknown directive", []string{"# comment", "", "port 7000", "nosuch yes"}, 4, "nosuch yes"},
+		{"too many arguments", []string{"port 7000 7001"}, 1, "port 7000 7001"},
+		{"no arguments", []string{"", "port"}, 2, "port"},
+		{"bad value", []string{"maxclients lots"}, 1, "maxclients lots"},
+		{"unbalanced quotes", []string{"port 7000", `requirepass "secret`}, 2, `requirepass "secret`},
+		{"include arguments", []string{"include a b"}, 1, "include a b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writeConfig(t, t.TempDir(), "redis.conf", test.lines...)
+
+			err := configError(t, path)
+			if err.File != path || err.Line != test.line || err.Text != test.text {
+				t.Errorf("the error is at %s:%d %q, want %s:%d %q", err.File, err.Line, err.Text, path, test.line, test.text)
+			}
+		})
+	}
+}
+
+// An error in an included file points at that file rather than the line of
+// the include
+func TestLoadFileIncludedError(t *testing.T) {
+	dir := t.TempDir()
+	included := writeConfig(t, dir, "included.conf", "port 7000", "", "nosuch yes")
+	path := writeConfig(t, dir, "redis.conf", "loglevel debug", "include "+included)
+
+	err := configError(t, path)
+	if err.File != included || err.Line != 3 {
+		t.Errorf("the error is at %s:%d, want %s:3", err.File, err.Line, included)
+	}
+}
+
+func TestLoadFileIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.conf")
+	b := writeConfig(t, dir, "b.conf", "include "+a)
+	writeConfig(t, dir, "a.conf", "include "+b)
+
+	_, err := InitConfig([]string{a})
+	if err == nil || !strings.Contains(err.Error(), "Too many nested includes") {
+		t.Errorf("loading files that include each other returned %v", err)
+	}
+}
+
+func TestLoadFileIncludeDepth(t *testing.T) {
+	dir := t.TempDir()
+
+	// Every file includes the next one, and the last sets the port
+	chain := func(files int) string {
+		next := writeConfig(t, dir, fmt.Sprintf("chain-%d-%d.conf", files, files), "port 7000")
+		for i := files - 1; i > 0; i-- {
+			next = writeConfig(t, dir, fmt.Sprintf("chain-%d-%d.conf", files, i), "include "+next)
+		}
+		return next
+	}
+
+	config, err := InitConfig([]string{chain(MAX_INCLUDE_DEPTH + 1)})
+	if err != nil {
+		t.Fatalf("loading %d nested includes returned %v", MAX_INCLUDE_DEPTH, err)
+	}
+	if config.Get().Port != 7000 {
+		t.Errorf("port is %d, want 7000 from the last included file", config.Get().Port)
+	}
+
+	if _, err := InitConfig([]string{chain(MAX_INCLUDE_DEPTH + 2)}); err == nil {
+		t.Errorf("loading %d nested includes succeeded", MAX_INCLUDE_DEPTH+1)
+	}
+}
+
+func TestCommandLineErrors(t *testing.T) {
+	args := [][]string{
+		{"--nosuch", "yes"},
+		{"--port"},
+		{"--port", "lots"},
+		{"--port", "7000", "7001"},
+	}
+
+	for _, a := range args {
+		if _, err := InitConfig(a); err == nil {
+			t.Errorf("InitConfig(%q) succeeded", a)
+		}
+	}
+}
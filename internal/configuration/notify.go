@@ -0,0 +1,73 @@
+package configuration
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace notification classes, see notify-keyspace-events in redis.conf
+const (
+	NOTIFY_KEYSPACE = 1 << iota
+	NOTIFY_KEYEVENT
+	NOTIFY_GENERIC
+	NOTIFY_STRING
+	NOTIFY_LIST
+	NOTIFY_SET
+	NOTIFY_HASH
+	NOTIFY_ZSET
+	NOTIFY_EXPIRED
+	NOTIFY_EVICTED
+	NOTIFY_STREAM
+	NOTIFY_KEY_MISS
+	NOTIFY_MODULE
+	NOTIFY_NEW
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH | NOTIFY_ZSET | NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM | NOTIFY_MODULE
+)
+
+var notifyClasses = map[rune]int{
+	'K': NOTIFY_KEYSPACE,
+	'E': NOTIFY_KEYEVENT,
+	'g': NOTIFY_GENERIC,
+	'$': NOTIFY_STRING,
+	'l': NOTIFY_LIST,
+	's': NOTIFY_SET,
+	'h': NOTIFY_HASH,
+	'z': NOTIFY_ZSET,
+	'x': NOTIFY_EXPIRED,
+	'e': NOTIFY_EVICTED,
+	't': NOTIFY_STREAM,
+	'm': NOTIFY_KEY_MISS,
+	'd': NOTIFY_MODULE,
+	'n': NOTIFY_NEW,
+	'A': NOTIFY_ALL,
+}
+
+func parseNotifyKeyspaceEvents(value string) (int, error) {
+	flags := 0
+	for _, c := range value {
+		class, ok := notifyClasses[c]
+		if !ok {
+			return 0, fmt.Errorf("Invalid event class character '%c' in notify-keyspace-events", c)
+		}
+		flags |= class
+	}
+
+	return flags, nil
+}
+
+// formatNotifyKeyspaceEvents turns flags back into event class characters,
+// using the A alias where possible
+func formatNotifyKeyspaceEvents(flags int) string {
+	var res strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		res.WriteRune('A')
+	}
+	for _, c := range "g$lshzxetdKEmn" {
+		class := notifyClasses[c]
+		if flags&class != 0 && (class&NOTIFY_ALL == 0 || flags&NOTIFY_ALL != NOTIFY_ALL) {
+			res.WriteRune(c)
+		}
+	}
+
+	return res.String()
+}
@@ -0,0 +1,224 @@
+package configuration
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// param describes how a config parameter is parsed from and written back to
+// its arguments. Only mutable parameters can be changed with CONFIG SET.
+type param struct {
+	mutable bool
+	multi   bool
+	set     func(v *Values, args []string) error
+	get     func(v Values) []string
+}
+
+var params = map[string]param{
+	"requirepass": {
+		mutable: true,
+		set:     setPassword,
+		get: func(v Values) []string {
+			return []string{v.Requirepass}
+		},
+	},
+	"notify-keyspace-events": {
+		mutable: true,
+		set: func(v *Values, args []string) error {
+			flags, err := parseNotifyKeyspaceEvents(args[0])
+			if err != nil {
+				return err
+			}
+			v.NotifyKeyspaceEvents = flags
+			return nil
+		},
+		get: func(v Values) []string {
+			return []string{formatNotifyKeyspaceEvents(v.NotifyKeyspaceEvents)}
+		},
+	},
+	"pubsub-fanout":        enumParam(false, []string{PUBSUB_FANOUT_LOCAL, PUBSUB_FANOUT_POSTGRES}, func(v *Values) *string { return &v.PubsubFanout }),
+	"keyspace-change-feed": boolParam(false, func(v *Values) *bool { return &v.KeyspaceChangeFeed }),
+	"port":                 intParam(false, 0, 65535, func(v *Values) *int { return &v.Port }),
+	"bind": {
+		multi: true,
+		set:   setBind,
+		get: func(v Values) []string {
+			return v.Bind
+		},
+	},
+	"unixsocket": stringParam(false, func(v *Values) *string { return &v.UnixSocket }),
+	"unixsocketperm": {
+		set: func(v *Values, args []string) error {
+			perm, err := strconv.ParseUint(args[0], 8, 32)
+			if err != nil || perm > 0777 {
+				return fmt.Errorf("argument must be an octal permission between 0 and 777")
+			}
+			v.UnixSocketPerm = os.FileMode(perm)
+			return nil
+		},
+		get: func(v Values) []string {
+			return []string{strconv.FormatUint(uint64(v.UnixSocketPerm), 8)}
+		},
+	},
+	"dir": {
+		mutable: true,
+		set: func(v *Values, args []string) error {
+			if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+				return fmt.Errorf("Can't chdir to '%s': not a directory", args[0])
+			}
+			v.Dir = args[0]
+			return nil
+		},
+		get: func(v Values) []string {
+			return []string{v.Dir}
+		},
+	},
+	"timeout":       intParam(true, 0, 1<<31-1, func(v *Values) *int { return &v.Timeout }),
+	"tcp-keepalive": intParam(true, 0, 1<<31-1, func(v *Values) *int { return &v.TCPKeepalive }),
+	"maxclients":    intParam(true, 1, 1<<31-1, func(v *Values) *int { return &v.MaxClients }),
+	"client-output-buffer-limit": {
+		mutable: true,
+		multi:   true,
+		set:     setOutputBufferLimits,
+		get:     formatOutputBufferLimits,
+	},
+	"loglevel":        enumParam(true, []string{"debug", "verbose", "notice", "warning", "nothing"}, func(v *Values) *string { return &v.LogLevel }),
+	"logfile":         stringParam(false, func(v *Values) *string { return &v.LogFile }),
+	"databases":       intParam(false, 1, 1<<31-1, func(v *Values) *int { return &v.Databases }),
+	"storage-backend": enumParam(false, []string{storage.BACKEND_POSTGRES, storage.BACKEND_FILE, storage.BACKEND_MONGODB}, func(v *Values) *string { return &v.StorageBackend }),
+	"storage-dsn":     stringParam(false, func(v *Values) *string { return &v.StorageDSN }),
+	// The log of the file backend, relative to dir
+	"storage-file":       stringParam(false, func(v *Values) *string { return &v.StorageFile }),
+	"appendfsync":        enumParam(false, []string{storage.FSYNC_ALWAYS, storage.FSYNC_EVERYSEC, storage.FSYNC_NO}, func(v *Values) *string { return &v.Appendfsync }),
+	"mongodb-uri":        stringParam(false, func(v *Values) *string { return &v.MongoDBURI }),
+	"mongodb-database":   stringParam(false, func(v *Values) *string { return &v.MongoDBDatabase }),
+	"mongodb-collection": stringParam(false, func(v *Values) *string { return &v.MongoDBCollection }),
+	"aclfile":            stringParam(false, func(v *Values) *string { return &v.ACLFile }),
+	"acllog-max-len":     intParam(true, 0, 1<<31-1, func(v *Values) *int { return &v.ACLLogMaxLen }),
+	"tls-port":           intParam(false, 0, 65535, func(v *Values) *int { return &v.TLSPort }),
+	"tls-cert-file":      stringParam(true, func(v *Values) *string { return &v.TLSCertFile }),
+	"tls-key-file":       stringParam(true, func(v *Values) *string { return &v.TLSKeyFile }),
+	"tls-ca-cert-file":   stringParam(true, func(v *Values) *string { return &v.TLSCACertFile }),
+	"tls-auth-clients":   enumParam(true, []string{"yes", "no", "optional"}, func(v *Values) *string { return &v.TLSAuthClients }),
+	// Log in clients presenting a certificate as the user named by its CN
+	"tls-auth-clients-user": enumParam(true, []string{"off", "cn"}, func(v *Values) *string { return &v.TLSAuthClientsUser }),
+	// Seconds a shutdown waits for running commands to finish
+	"shutdown-timeout": intParam(true, 0, 1<<31-1, func(v *Values) *int { return &v.ShutdownTimeout }),
+	"metrics-port":     intParam(false, 0, 65535, func(v *Values) *int { return &v.MetricsPort }),
+	// In microseconds, a negative value disables the slow log
+	"slowlog-log-slower-than":   intParam(true, -1, 1<<31-1, func(v *Values) *int { return &v.SlowlogLogSlowerThan }),
+	"slowlog-max-len":           intParam(true, 0, 1<<31-1, func(v *Values) *int { return &v.SlowlogMaxLen }),
+	"latency-monitor-threshold": intParam(true, 0, 1<<31-1, func(v *Values) *int { return &v.LatencyMonitorThreshold }),
+	"command-executor":          enumParam(false, []string{COMMAND_EXECUTOR_THREADED, COMMAND_EXECUTOR_SINGLE, COMMAND_EXECUTOR_SHARDED}, func(v *Values) *string { return &v.CommandExecutor }),
+	"command-executor-shards":   intParam(false, 1, 1024, func(v *Values) *int { return &v.CommandExecutorShards }),
+}
+
+func boolParam(mutable bool, field func(v *Values) *bool) param {
+	return param{
+		mutable: mutable,
+		set: func(v *Values, args []string) error {
+			switch strings.ToLower(args[0]) {
+			case "yes":
+				*field(v) = true
+			case "no":
+				*field(v) = false
+			default:
+				return fmt.Errorf("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+		get: func(v Values) []string {
+			if *field(&v) {
+				return []string{"yes"}
+			}
+			return []string{"no"}
+		},
+	}
+}
+
+func intParam(mutable bool, min int, max int, field func(v *Values) *int) param {
+	return param{
+		mutable: mutable,
+		set: func(v *Values, args []string) error {
+			i, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("argument couldn't be parsed into an integer")
+			}
+			if i < min || i > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field(v) = i
+			return nil
+		},
+		get: func(v Values) []string {
+			return []string{strconv.Itoa(*field(&v))}
+		},
+	}
+}
+
+func stringParam(mutable bool, field func(v *Values) *string) param {
+	return param{
+		mutable: mutable,
+		set: func(v *Values, args []string) error {
+			*field(v) = args[0]
+			return nil
+		},
+		get: func(v Values) []string {
+			return []string{*field(&v)}
+		},
+	}
+}
+
+func enumParam(mutable bool, allowed []string, field func(v *Values) *string) param {
+	return param{
+		mutable: mutable,
+		set: func(v *Values, args []string) error {
+			value := strings.ToLower(args[0])
+			if !slices.Contains(allowed, value) {
+				return fmt.Errorf("argument(s) must be one of the following: %s", strings.Join(allowed, ", "))
+			}
+			*field(v) = value
+			return nil
+		},
+		get: func(v Values) []string {
+			return []string{*field(&v)}
+		},
+	}
+}
+
+func setPassword(v *Values, args []string) error {
+	password := args[0]
+	if password == "" {
+		v.Requirepass = ""
+		return nil
+	}
+
+	match, err := regexp.MatchString(`^[a-zA-Z0-9!&#$^<>-]{16,128}$`, password)
+	if err != nil {
+		return err
+	}
+	if !match {
+		return fmt.Errorf("Invalid password supplied")
+	}
+	v.Requirepass = password
+	return nil
+}
+
+func setBind(v *Values, addresses []string) error {
+	for _, a := range addresses {
+		// A leading - marks the address as optional, as in Redis
+		host := strings.TrimPrefix(a, "-")
+		if host != "*" && host != "localhost" && net.ParseIP(host) == nil {
+			return fmt.Errorf("Invalid bind address '%s'", a)
+		}
+	}
+	v.Bind = addresses
+	return nil
+}
@@ -0,0 +1,179 @@
+package configuration
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const REWRITE_SIGNATURE = "# Generated by CONFIG REWRITE"
+
+// Rewrite updates the config file the server was started with so that it
+// matches the current values. Comments, includes and the order of existing
+// directives are kept, repeated directives are collapsed into the first one,
+// and parameters that differ from their default are appended to the end.
+func (config *Config) Rewrite() error {
+	if config.configFile == "" {
+		return fmt.Errorf("The server is running without a config file")
+	}
+
+	values := config.Get()
+	defaults := defaultValues()
+
+	lines, err := readLines(config.configFile)
+	if err != nil {
+		return err
+	}
+
+	written := map[string]bool{}
+	rewritten := []string{}
+	signed := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == REWRITE_SIGNATURE {
+			// The directives appended by an earlier rewrite follow the
+			// signature, and are rewritten in place after it
+			if !signed {
+				rewritten = append(rewritten, REWRITE_SIGNATURE)
+				signed = true
+			}
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			rewritten = append(rewritten, line)
+			continue
+		}
+
+		args, err := splitArgs(trimmed)
+		if err != nil || len(args) == 0 {
+			rewritten = append(rewritten, line)
+			continue
+		}
+		directive := strings.ToLower(args[0])
+		p, ok := params[directive]
+		if !ok {
+			rewritten = append(rewritten, line)
+			continue
+		}
+		if written[directive] {
+			continue
+		}
+		written[directive] = true
+		rewritten = append(rewritten, formatDirective(directive, p.get(values)))
+	}
+
+	names := []string{}
+	for name := range params {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		p := params[name]
+		if written[name] || slices.Equal(p.get(values), p.get(defaults)) {
+			continue
+		}
+		if !signed {
+			rewritten = append(rewritten, REWRITE_SIGNATURE)
+			signed = true
+		}
+		rewritten = append(rewritten, formatDirective(name, p.get(values)))
+	}
+
+	return writeFileAtomic(config.configFile, strings.Join(rewritten, "\n")+"\n")
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	return lines, scanner.Err()
+}
+
+func formatDirective(name string, args []string) string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+
+	quoted := []string{name}
+	for _, a := range args {
+		quoted = append(quoted, quoteArg(a))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// quoteArg quotes an argument so that splitArgs reads it back unchanged
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n\"'\\") {
+		return arg
+	}
+
+	var res strings.Builder
+	res.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case c == '"' || c == '\\':
+			res.WriteByte('\\')
+			res.WriteByte(c)
+		case c == '\n':
+			res.WriteString(`\n`)
+		case c == '\r':
+			res.WriteString(`\r`)
+		case c == '\t':
+			res.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&res, `\x%02x`, c)
+		default:
+			res.WriteByte(c)
+		}
+	}
+	res.WriteByte('"')
+
+	return res.String()
+}
+
+// writeFileAtomic replaces the file through a rename so that a crash can't
+// leave a half written config behind
+func writeFileAtomic(path string, content string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".redis.conf.rewrite-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,131 @@
+package configuration
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	original := strings.Join([]string{
+		"# Network",
+		"port 7000",
+		"",
+		"   # Limits",
+		"   maxclients 100",
+		"timeout 10",
+		"include /etc/redis/other.conf",
+		"rename-command FLUSHALL \"\"",
+		"maxclients 200",
+	}, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := newTestConfig()
+	config.configFile = path
+	config.values.Port = 7000
+	err := config.Set([]Param{
+		{Name: "maxclients", Value: "500"},
+		{Name: "timeout", Value: "0"},
+		{Name: "tls-cert-file", Value: "with space.pem"},
+		{Name: "loglevel", Value: "warning"},
+		// Default values that aren't in the file aren't appended
+		{Name: "tcp-keepalive", Value: "300"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"# Network",
+		"port 7000",
+		"",
+		"   # Limits",
+		"maxclients 500",
+		"timeout 0",
+		"include /etc/redis/other.conf",
+		"rename-command FLUSHALL \"\"",
+		REWRITE_SIGNATURE,
+		"loglevel warning",
+		`tls-cert-file "with space.pem"`,
+	}, "\n") + "\n"
+
+	// Rewriting again gives the same file rather than a second signature
+	for i := 0; i < 2; i++ {
+		if err := config.Rewrite(); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != want {
+			t.Errorf("rewrite %d wrote\n%s\nwant\n%s", i+1, b, want)
+		}
+	}
+
+	// Parameters changed later go under the same signature
+	if err := config.Set([]Param{{Name: "slowlog-max-len", Value: "10"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want += "slowlog-max-len 10\n"; string(b) != want {
+		t.Errorf("the last rewrite wrote\n%s\nwant\n%s", b, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("the rewritten file has mode %v, want the original's", info.Mode().Perm())
+	}
+}
+
+// What CONFIG REWRITE writes is read back to the same values, however the
+// values are quoted
+func TestRewriteReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte("port 7000\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := InitConfig([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := "it's \"quoted\" \\ \t\n\x01.pem"
+	dir := filepath.Join(t.TempDir(), "with space")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Set([]Param{{Name: "tls-cert-file", Value: file}, {Name: "dir", Value: dir}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := InitConfig([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := reloaded.Get(); v.TLSCertFile != file || v.Dir != dir || v.Port != 7000 {
+		t.Errorf("reloaded tls-cert-file %q, dir %q and port %d", v.TLSCertFile, v.Dir, v.Port)
+	}
+}
+
+func TestRewriteWithoutFile(t *testing.T) {
+	if err := newTestConfig().Rewrite(); err == nil {
+		t.Error("Rewrite without a config file succeeded")
+	}
+}
@@ -0,0 +1,91 @@
+package configuration
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestConfig() *Config {
+	return &Config{values: defaultValues(), hooks: map[string][]ChangeHook{}}
+}
+
+func TestSet(t *testing.T) {
+	config := newTestConfig()
+	err := config.Set([]Param{
+		{Name: "MaxClients", Value: "50"},
+		{Name: "client-output-buffer-limit", Value: `normal 1mb 0 0 "pubsub" 32mb 8mb 60`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := config.Get()
+	if v.MaxClients != 50 {
+		t.Errorf("maxclients is %d, want 50", v.MaxClients)
+	}
+	want := BufferLimit{Hard: 32 << 20, Soft: 8 << 20, SoftSeconds: 60}
+	if v.ClientOutputBufferLimits.Pubsub != want || v.ClientOutputBufferLimits.Normal.Hard != 1<<20 {
+		t.Errorf("client-output-buffer-limit is %+v", v.ClientOutputBufferLimits)
+	}
+}
+
+// A SET that fails on any parameter changes none of them
+func TestSetInvalid(t *testing.T) {
+	changes := [][]Param{
+		{{Name: "maxclients", Value: "50"}, {Name: "timeout", Value: "soon"}},
+		{{Name: "maxclients", Value: "50"}, {Name: "port", Value: "7000"}},
+		{{Name: "maxclients", Value: "50"}, {Name: "nosuch", Value: "1"}},
+		{{Name: "maxclients", Value: "50"}, {Name: "MAXCLIENTS", Value: "60"}},
+		{{Name: "maxclients", Value: "50"}, {Name: "client-output-buffer-limit", Value: `pubsub "32mb 8mb 60`}},
+	}
+
+	for _, c := range changes {
+		config := newTestConfig()
+		if err := config.Set(c); err == nil {
+			t.Errorf("Set(%v) succeeded", c)
+		}
+		if v := config.Get(); v.MaxClients != defaultValues().MaxClients {
+			t.Errorf("Set(%v) failed but left maxclients at %d", c, v.MaxClients)
+		}
+	}
+}
+
+// A hook rejecting its parameter rolls back every parameter of the SET, and
+// the hooks that accepted the new values are run again with the old ones
+func TestSetHookRollback(t *testing.T) {
+	config := newTestConfig()
+
+	seen := []int{}
+	config.OnChange("maxclients", func(v Values) error {
+		seen = append(seen, v.MaxClients)
+		return nil
+	})
+	config.OnChange("timeout", func(v Values) error {
+		if v.Timeout > 60 {
+			return errors.New("too long")
+		}
+		return nil
+	})
+
+	err := config.Set([]Param{{Name: "maxclients", Value: "50"}, {Name: "timeout", Value: "120"}})
+	if err == nil || err.Error() != "CONFIG SET failed (possibly related to argument 'timeout') - too long" {
+		t.Errorf("Set returned %v", err)
+	}
+
+	v := config.Get()
+	if v.MaxClients != defaultValues().MaxClients || v.Timeout != 0 {
+		t.Errorf("maxclients is %d and timeout %d after a rejected SET", v.MaxClients, v.Timeout)
+	}
+	// Hooks run in no particular order, so the maxclients hook may not have
+	// seen the new value, but it last saw the old one
+	if len(seen) == 0 || seen[len(seen)-1] != defaultValues().MaxClients {
+		t.Errorf("the maxclients hook saw %v, want it to end on %d", seen, defaultValues().MaxClients)
+	}
+
+	if err := config.Set([]Param{{Name: "maxclients", Value: "50"}, {Name: "timeout", Value: "30"}}); err != nil {
+		t.Fatal(err)
+	}
+	if v := config.Get(); v.MaxClients != 50 || v.Timeout != 30 {
+		t.Errorf("maxclients is %d and timeout %d after an accepted SET", v.MaxClients, v.Timeout)
+	}
+}
@@ -0,0 +1,111 @@
+package configuration
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// splitArgs splits a config line into arguments the same way Redis does:
+// arguments are separated by spaces and may be wrapped in double quotes,
+// which support escape sequences, or single quotes, which only support \'.
+func splitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i >= len(line) {
+			return args, nil
+		}
+
+		var current strings.Builder
+		inDoubleQuotes, inSingleQuotes := false, false
+		done := false
+
+		for !done {
+			switch {
+			case inDoubleQuotes:
+				if i >= len(line) {
+					return nil, fmt.Errorf("unbalanced quotes in configuration line")
+				}
+				switch {
+				case line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current.WriteByte(byte(b))
+					i += 3
+				case line[i] == '\\' && i+1 < len(line):
+					i++
+					switch line[i] {
+					case 'n':
+						current.WriteByte('\n')
+					case 'r':
+						current.WriteByte('\r')
+					case 't':
+						current.WriteByte('\t')
+					case 'b':
+						current.WriteByte('\b')
+					case 'a':
+						current.WriteByte('\a')
+					default:
+						current.WriteByte(line[i])
+					}
+				case line[i] == '"':
+					// Closing quote must be followed by a space or nothing at all
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("unbalanced quotes in configuration line")
+					}
+					done = true
+				default:
+					current.WriteByte(line[i])
+				}
+			case inSingleQuotes:
+				if i >= len(line) {
+					return nil, fmt.Errorf("unbalanced quotes in configuration line")
+				}
+				switch {
+				case line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'':
+					i++
+					current.WriteByte('\'')
+				case line[i] == '\'':
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, fmt.Errorf("unbalanced quotes in configuration line")
+					}
+					done = true
+				default:
+					current.WriteByte(line[i])
+				}
+			default:
+				if i >= len(line) {
+					done = true
+					break
+				}
+				switch line[i] {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					current.WriteByte(line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+
+		args = append(args, current.String())
+	}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == 0
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
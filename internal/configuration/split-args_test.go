@@ -0,0 +1,57 @@
+package configuration
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", []string{}},
+		{"   \t ", []string{}},
+		{"port 6379", []string{"port", "6379"}},
+		{"  bind   127.0.0.1\t::1  ", []string{"bind", "127.0.0.1", "::1"}},
+		{`requirepass "with space"`, []string{"requirepass", "with space"}},
+		{`requirepass ""`, []string{"requirepass", ""}},
+		{`a "\n\r\t\b\a"`, []string{"a", "\n\r\t\b\a"}},
+		{`a "\"quoted\" \\ \q"`, []string{"a", `"quoted" \ q`}},
+		{`a "\x41\x7a\xff"`, []string{"a", "Az\xff"}},
+		// Not a complete hex escape, so only the backslash is dropped
+		{`a "\x4" "\xzz"`, []string{"a", "x4", "xzz"}},
+		{`a 'single \n "quotes"'`, []string{"a", `single \n "quotes"`}},
+		{`a 'it\'s'`, []string{"a", "it's"}},
+		{`a 'x\y'`, []string{"a", `x\y`}},
+		{`a b"c"`, []string{"a", "bc"}},
+	}
+
+	for _, test := range tests {
+		got, err := splitArgs(test.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q) returned %v", test.line, err)
+			continue
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestSplitArgsUnbalancedQuotes(t *testing.T) {
+	lines := []string{
+		`a "open`,
+		`a 'open`,
+		`a "closed"after`,
+		`a 'closed'after`,
+		`a "escaped quote\"`,
+		`a 'escaped quote\'`,
+	}
+
+	for _, line := range lines {
+		if args, err := splitArgs(line); err == nil {
+			t.Errorf("splitArgs(%q) = %q, want an unbalanced quotes error", line, args)
+		}
+	}
+}
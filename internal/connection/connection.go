@@ -1,12 +1,359 @@
 package connection
 
-import "net"
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
 
 type Connection struct {
-	Conn      *net.Conn
-	Validated bool
+	ID        int64
+	Conn      net.Conn
+	CreatedAt time.Time
+	reader    *resp.RespReader
+	// Bytes read from the client but not parsed yet, as of the last command
+	// read. It is written by the client's goroutine and read by CLIENT LIST
+	// and INFO on others.
+	queryBuffer     atomic.Int64
+	validated       bool
+	user            string
+	closeAfterReply bool
+	channels        map[string]struct{}
+	shardChannels   map[string]struct{}
+	protocol        int
+	tracking        Tracking
+	name            string
+	lastInteraction time.Time
+	lastCommand     string
+	noEvict         bool
+	monitor         bool
+	replyOff        bool
+	skipNextReply   bool
+	skipReply       bool
+	multi           *transaction
+	stateMutex      sync.RWMutex
+	output          output
+}
+
+// Modes of CLIENT REPLY
+const (
+	REPLY_ON   = "ON"
+	REPLY_OFF  = "OFF"
+	REPLY_SKIP = "SKIP"
+)
+
+// Tracking holds the client side caching options set with CLIENT TRACKING
+type Tracking struct {
+	Enabled    bool
+	Redirect   int64
+	BCast      bool
+	Prefixes   []string
+	OptIn      bool
+	OptOut     bool
+	NoLoop     bool
+	CachingYes bool
+	CachingNo  bool
+}
+
+var lastID atomic.Int64
+
+func NewConnection(conn net.Conn) *Connection {
+	now := time.Now()
+	c := &Connection{
+		ID:              lastID.Add(1),
+		Conn:            conn,
+		reader:          resp.NewRespReader(conn),
+		CreatedAt:       now,
+		lastInteraction: now,
+		user:            acl.DEFAULT_USER,
+		channels:        map[string]struct{}{},
+		shardChannels:   map[string]struct{}{},
+		protocol:        2,
+	}
+	c.output.init()
+	go c.writeOutput()
+
+	return c
+}
+
+// ReadCommand reads the client's next command. It must only be called by the
+// client's own goroutine.
+func (c *Connection) ReadCommand() (resp.RespValue, error) {
+	value, err := c.reader.ReadResp()
+	c.queryBuffer.Store(int64(c.reader.Buffered()))
+	return value, err
+}
+
+// QueryBuffer is the number of bytes read from the client but not parsed yet
+func (c *Connection) QueryBuffer() int {
+	return int(c.queryBuffer.Load())
+}
+
+// ReadBufferSize is the size of the client's read buffer, which doesn't
+// change after the connection is created
+func (c *Connection) ReadBufferSize() int {
+	return c.reader.Size()
+}
+
+func (c *Connection) User() string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.user
+}
+
+func (c *Connection) SetUser(user string) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.user = user
+}
+
+// Validated reports whether the client has authenticated, or didn't need to
+func (c *Connection) Validated() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.validated
+}
+
+func (c *Connection) SetValidated(validated bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.validated = validated
+}
+
+// CloseAfterReply reports whether the connection is closed once the reply
+// of the current command has been sent, as after QUIT or CLIENT KILL
+func (c *Connection) CloseAfterReply() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.closeAfterReply
+}
+
+func (c *Connection) SetCloseAfterReply() {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.closeAfterReply = true
+}
+
+// Protocol returns the RESP version negotiated with HELLO
+func (c *Connection) Protocol() int {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.protocol
+}
+
+func (c *Connection) SetProtocol(protocol int) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.protocol = protocol
+}
+
+func (c *Connection) Tracking() Tracking {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.tracking
+}
+
+func (c *Connection) SetTracking(t Tracking) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.tracking = t
+}
+
+func (c *Connection) Name() string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.name
+}
+
+func (c *Connection) SetName(name string) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.name = name
+}
+
+// StartCommand records the command the client is running, as shown by
+// CLIENT LIST, and resets its idle time
+func (c *Connection) StartCommand(command string) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.lastCommand = command
+	c.lastInteraction = time.Now()
+}
+
+// LastCommand is the most recent command run by the client, or NULL if it
+// hasn't run one yet
+func (c *Connection) LastCommand() string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	if c.lastCommand == "" {
+		return "NULL"
+	}
+	return c.lastCommand
+}
+
+// Idle is the time since the client last sent a command
+func (c *Connection) Idle() time.Duration {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return time.Since(c.lastInteraction)
+}
+
+func (c *Connection) NoEvict() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.noEvict
+}
+
+func (c *Connection) SetNoEvict(noEvict bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.noEvict = noEvict
+}
+
+// IsMonitor reports whether the client ran MONITOR
+func (c *Connection) IsMonitor() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.monitor
+}
+
+func (c *Connection) SetMonitor(monitor bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.monitor = monitor
+}
+
+// SetReplyMode implements CLIENT REPLY. OFF suppresses every reply until it
+// is turned back ON, and SKIP suppresses the reply of the next command only.
+func (c *Connection) SetReplyMode(mode string) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	switch mode {
+	case REPLY_ON:
+		c.replyOff = false
+	case REPLY_OFF:
+		c.replyOff = true
+	case REPLY_SKIP:
+		c.skipNextReply = true
+	}
+}
+
+// ShouldReply is called once per command and reports whether its reply
+// should be sent
+func (c *Connection) ShouldReply() bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	reply := !c.replyOff && !c.skipReply
+	c.skipReply = c.skipNextReply
+	c.skipNextReply = false
+	return reply
+}
+
+// IsUnixSocket reports whether the client connected through the unixsocket
+func (c *Connection) IsUnixSocket() bool {
+	_, ok := c.Conn.(*net.UnixConn)
+	return ok
+}
+
+// Addr is the client's address. Unix socket clients have no address of their
+// own, so like Redis they are shown as the socket path with port 0.
+func (c *Connection) Addr() string {
+	if c.IsUnixSocket() {
+		return c.LocalAddr()
+	}
+	return c.Conn.RemoteAddr().String()
+}
+
+// LocalAddr is the address of the listener the client connected to
+func (c *Connection) LocalAddr() string {
+	if c.IsUnixSocket() {
+		return c.Conn.LocalAddr().String() + ":0"
+	}
+	return c.Conn.LocalAddr().String()
+}
+
+// The subscriptions are changed by the client's own goroutine but read by
+// publishers, the cron and shutdown, so they are guarded by stateMutex
+
+func (c *Connection) Subscribe(channel string) bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return addChannel(c.channels, channel)
+}
+
+func (c *Connection) Unsubscribe(channel string) bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return removeChannel(c.channels, channel)
+}
+
+func (c *Connection) Channels() []string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return sortedChannels(c.channels)
+}
+
+func (c *Connection) SubscriptionCount() int {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return len(c.channels)
+}
+
+func (c *Connection) ShardSubscribe(channel string) bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return addChannel(c.shardChannels, channel)
+}
+
+func (c *Connection) ShardUnsubscribe(channel string) bool {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return removeChannel(c.shardChannels, channel)
+}
+
+func (c *Connection) ShardChannels() []string {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return sortedChannels(c.shardChannels)
+}
+
+func (c *Connection) ShardSubscriptionCount() int {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return len(c.shardChannels)
+}
+
+func (c *Connection) IsSubscribed() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return len(c.channels) > 0 || len(c.shardChannels) > 0
+}
+func addChannel(channels map[string]struct{}, channel string) bool {
+	if _, ok := channels[channel]; ok {
+		return false
+	}
+	channels[channel] = struct{}{}
+	return true
+}
+
+func removeChannel(channels map[string]struct{}, channel string) bool {
+	if _, ok := channels[channel]; !ok {
+		return false
+	}
+	delete(channels, channel)
+	return true
 }
 
-func NewConnection(conn *net.Conn) Connection {
-	return Connection{Conn: conn}
+func sortedChannels(channels map[string]struct{}) []string {
+	sorted := make([]string, 0, len(channels))
+	for ch := range channels {
+		sorted = append(sorted, ch)
+	}
+	sort.Strings(sorted)
+	return sorted
 }
@@ -0,0 +1,165 @@
+package connection
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Writes to a client that doesn't read for this long fail and disconnect it
+const WRITE_TIMEOUT = 10 * time.Second
+
+var ErrClosed = errors.New("connection closed")
+var ErrOutputBufferLimit = errors.New("output buffer limit reached")
+
+var limits = configuration.OutputBufferLimits{}
+var limitsMutex = sync.RWMutex{}
+
+// SetOutputBufferLimits changes the client-output-buffer-limit of every client
+func SetOutputBufferLimits(l configuration.OutputBufferLimits) {
+	limitsMutex.Lock()
+	defer limitsMutex.Unlock()
+	limits = l
+}
+
+// output queues replies and messages for a client. They are written to the
+// socket by the client's own writer goroutine, so publishing to a slow
+// subscriber never blocks the publisher.
+type output struct {
+	mutex          sync.Mutex
+	ready          *sync.Cond
+	pending        [][]byte
+	pendingBytes   int64
+	softLimitSince time.Time
+	closed         bool
+	done           chan struct{}
+}
+
+func (o *output) init() {
+	o.ready = sync.NewCond(&o.mutex)
+	o.done = make(chan struct{})
+}
+
+// Write queues v to be sent to the client. The client is disconnected if
+// this takes its pending output over the limits of its class.
+func (c *Connection) Write(v resp.RespValue) error {
+	data := v.Marshall()
+
+	c.output.mutex.Lock()
+	defer c.output.mutex.Unlock()
+
+	if c.output.closed {
+		return ErrClosed
+	}
+
+	c.output.pending = append(c.output.pending, data)
+	c.output.pendingBytes += int64(len(data))
+	if c.overOutputBufferLimit() {
+		logger.Warning("Client id=%d addr=%s closed for overcoming of output buffer limits.", c.ID, c.Addr())
+		c.output.closed = true
+		c.output.ready.Signal()
+		c.Conn.Close()
+		return ErrOutputBufferLimit
+	}
+	c.output.ready.Signal()
+
+	return nil
+}
+
+// overOutputBufferLimit is called with the output locked
+func (c *Connection) overOutputBufferLimit() bool {
+	limitsMutex.RLock()
+	limit := limits.Normal
+	if c.IsSubscribed() {
+		limit = limits.Pubsub
+	}
+	limitsMutex.RUnlock()
+
+	used := c.output.pendingBytes
+	if limit.Hard > 0 && used >= limit.Hard {
+		return true
+	}
+
+	if limit.Soft == 0 || used < limit.Soft {
+		c.output.softLimitSince = time.Time{}
+		return false
+	}
+	if c.output.softLimitSince.IsZero() {
+		c.output.softLimitSince = time.Now()
+		return false
+	}
+
+	return time.Since(c.output.softLimitSince) >= time.Duration(limit.SoftSeconds)*time.Second
+}
+
+func (c *Connection) writeOutput() {
+	defer close(c.output.done)
+
+	for {
+		c.output.mutex.Lock()
+		for len(c.output.pending) == 0 && !c.output.closed {
+			c.output.ready.Wait()
+		}
+		batch := c.output.pending
+		c.output.pending = nil
+		closed := c.output.closed
+		c.output.mutex.Unlock()
+
+		if len(batch) == 0 && closed {
+			return
+		}
+
+		written := int64(0)
+		for _, data := range batch {
+			c.Conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+			if _, err := c.Conn.Write(data); err != nil {
+				logger.Verbose("Error writing to client: %v", err)
+				c.Close()
+				return
+			}
+			written += int64(len(data))
+		}
+
+		c.output.mutex.Lock()
+		c.output.pendingBytes -= written
+		c.output.mutex.Unlock()
+	}
+}
+
+// OutputBuffer returns the number of replies waiting to be written to the
+// client and their size in bytes
+func (c *Connection) OutputBuffer() (int, int64) {
+	c.output.mutex.Lock()
+	defer c.output.mutex.Unlock()
+	return len(c.output.pending), c.output.pendingBytes
+}
+
+// Flush stops accepting output and waits until everything already queued
+// has been written, or the write times out
+func (c *Connection) Flush() {
+	c.output.mutex.Lock()
+	c.output.closed = true
+	c.output.ready.Signal()
+	c.output.mutex.Unlock()
+
+	select {
+	case <-c.output.done:
+	case <-time.After(WRITE_TIMEOUT):
+	}
+}
+
+// Close disconnects the client straight away, dropping pending output and
+// making its next read fail
+func (c *Connection) Close() error {
+	c.output.mutex.Lock()
+	c.output.closed = true
+	c.output.pending = nil
+	c.output.ready.Signal()
+	c.output.mutex.Unlock()
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,48 @@
+package connection
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrMaxClients = errors.New("max number of clients reached")
+
+var registry = map[int64]*Connection{}
+var registryMutex = sync.RWMutex{}
+
+// Register adds a connection to the server wide client registry so that it
+// can be looked up by ID from other connections, unless maxClients are
+// already connected.
+func Register(c *Connection, maxClients int) error {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if len(registry) >= maxClients {
+		return ErrMaxClients
+	}
+	registry[c.ID] = c
+	return nil
+}
+
+func Unregister(c *Connection) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	delete(registry, c.ID)
+}
+
+func Get(id int64) (*Connection, bool) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	c, ok := registry[id]
+	return c, ok
+}
+
+func All() []*Connection {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	conns := make([]*Connection, 0, len(registry))
+	for _, c := range registry {
+		conns = append(conns, c)
+	}
+	return conns
+}
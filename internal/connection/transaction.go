@@ -0,0 +1,75 @@
+package connection
+
+import "github.com/mmacdo54/go-redis-clone/internal/resp"
+
+// transaction holds the commands queued between MULTI and EXEC. A command
+// that is rejected while queueing marks it dirty, and EXEC then discards it.
+type transaction struct {
+	commands [][]resp.RespValue
+	dirty    bool
+}
+
+// StartMulti starts queueing commands for EXEC
+func (c *Connection) StartMulti() {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.multi = &transaction{}
+}
+
+func (c *Connection) InMulti() bool {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	return c.multi != nil
+}
+
+// QueueCommand adds a command, including its name, to the transaction
+func (c *Connection) QueueCommand(argv []resp.RespValue) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if c.multi != nil {
+		c.multi.commands = append(c.multi.commands, argv)
+	}
+}
+
+// FlagMulti marks the transaction as failed, if the client is in one
+func (c *Connection) FlagMulti() {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if c.multi != nil {
+		c.multi.dirty = true
+	}
+}
+
+// MultiLen is the number of commands in the transaction, or -1 if the
+// client isn't in one, as shown by CLIENT LIST
+func (c *Connection) MultiLen() int {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	if c.multi == nil {
+		return -1
+	}
+	return len(c.multi.commands)
+}
+
+// QueuedCommands returns the commands in the transaction so far
+func (c *Connection) QueuedCommands() [][]resp.RespValue {
+	c.stateMutex.RLock()
+	defer c.stateMutex.RUnlock()
+	if c.multi == nil {
+		return nil
+	}
+	return c.multi.commands
+}
+
+// EndMulti leaves the transaction, returning its commands and whether it was
+// marked as failed
+func (c *Connection) EndMulti() ([][]resp.RespValue, bool) {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	if c.multi == nil {
+		return nil, false
+	}
+	commands, dirty := c.multi.commands, c.multi.dirty
+	c.multi = nil
+	return commands, dirty
+}
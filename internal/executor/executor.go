@@ -0,0 +1,151 @@
+package executor
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/mmacdo54/go-redis-clone/internal/cluster"
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+)
+
+// Each shard queues up to this many commands before clients have to wait
+const QUEUE_SIZE = 1024
+
+type job struct {
+	run  func()
+	done chan struct{}
+}
+
+var (
+	mode   = configuration.COMMAND_EXECUTOR_THREADED
+	queues []chan job
+	// Jobs spanning several shards are queued under this lock, so that they
+	// reach every shard in the same order and can't wait on each other
+	dispatchMutex sync.Mutex
+	// In the threaded mode commands hold this for reading, so that
+	// exclusive work can wait for them to finish by holding it for writing
+	threadedMutex sync.RWMutex
+)
+
+// Start sets up the executor for command-executor and the number of shards.
+// It is called once before clients connect.
+func Start(executorMode string, shards int) {
+	mode = executorMode
+	switch mode {
+	case configuration.COMMAND_EXECUTOR_THREADED:
+		return
+	case configuration.COMMAND_EXECUTOR_SINGLE:
+		shards = 1
+	}
+
+	queues = make([]chan job, shards)
+	for i := range queues {
+		queues[i] = make(chan job, QUEUE_SIZE)
+		go work(queues[i])
+	}
+}
+
+func work(queue chan job) {
+	for j := range queue {
+		j.run()
+		close(j.done)
+	}
+}
+
+// Run calls fn once nothing else is running on the shards of its keys, and
+// returns when it has finished. Commands without keys run on the first
+// shard. In the threaded mode fn is called straight away, unless exclusive
+// work is running.
+func Run(keys []string, fn func()) {
+	if mode == configuration.COMMAND_EXECUTOR_THREADED {
+		threadedMutex.RLock()
+		defer threadedMutex.RUnlock()
+		fn()
+		return
+	}
+
+	runOnShards(shardsOf(keys), fn)
+}
+
+// RunExclusive calls fn once nothing else is running on any shard, for work
+// that can touch any key such as the active expire cycle
+func RunExclusive(fn func()) {
+	if mode == configuration.COMMAND_EXECUTOR_THREADED {
+		threadedMutex.Lock()
+		defer threadedMutex.Unlock()
+		fn()
+		return
+	}
+
+	shards := make([]int, len(queues))
+	for i := range shards {
+		shards[i] = i
+	}
+	runOnShards(shards, fn)
+}
+
+// RunAtomically calls fn like Run, but so that no other command runs until it
+// has finished even in the threaded mode, where it runs exclusively. It is
+// for commands such as EXEC that run several others.
+func RunAtomically(keys []string, fn func()) {
+	if mode == configuration.COMMAND_EXECUTOR_THREADED {
+		RunExclusive(fn)
+		return
+	}
+
+	Run(keys, fn)
+}
+
+// shardsOf returns the sorted shards that own the slots of the keys
+func shardsOf(keys []string) []int {
+	shards := []int{0}
+	if len(keys) > 0 {
+		shards = shards[:0]
+	}
+	for _, key := range keys {
+		shard := cluster.KeySlot(key) % len(queues)
+		if !slices.Contains(shards, shard) {
+			shards = append(shards, shard)
+		}
+	}
+	slices.Sort(shards)
+
+	return shards
+}
+
+func runOnShards(shards []int, fn func()) {
+	if len(shards) == 1 {
+		j := job{run: fn, done: make(chan struct{})}
+		queues[shards[0]] <- j
+		<-j.done
+		return
+	}
+
+	// Every shard is parked until they have all reached the job, then fn
+	// runs on this goroutine while they wait
+	ready := sync.WaitGroup{}
+	ready.Add(len(shards))
+	release := make(chan struct{})
+	parked := []job{}
+
+	dispatchMutex.Lock()
+	for _, shard := range shards {
+		j := job{
+			run: func() {
+				ready.Done()
+				<-release
+			},
+			done: make(chan struct{}),
+		}
+		queues[shard] <- j
+		parked = append(parked, j)
+	}
+	dispatchMutex.Unlock()
+
+	ready.Wait()
+	fn()
+	close(release)
+	for _, j := range parked {
+		<-j.done
+	}
+}
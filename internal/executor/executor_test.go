@@ -0,0 +1,147 @@
+package executor
+
+import (
+	"fmt"
+	"hash/crc32"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+)
+
+const SHARDS = 16
+
+// Clients in the benchmarks, far more than there are CPUs as on a server
+const PARALLELISM = 64
+
+var modes = []struct {
+	mode   string
+	shards int
+}{
+	{configuration.COMMAND_EXECUTOR_THREADED, 0},
+	{configuration.COMMAND_EXECUTOR_SINGLE, 1},
+	{configuration.COMMAND_EXECUTOR_SHARDED, SHARDS},
+}
+
+// start runs the executor in the mode for the rest of the test
+func start(tb testing.TB, executorMode string, shards int) {
+	Start(executorMode, shards)
+	tb.Cleanup(func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		queues = nil
+		mode = configuration.COMMAND_EXECUTOR_THREADED
+	})
+}
+
+// Exclusive work and EXEC never run alongside a command, whatever the mode
+func TestRunExclusive(t *testing.T) {
+	for _, m := range modes {
+		t.Run(m.mode, func(t *testing.T) {
+			start(t, m.mode, m.shards)
+
+			running := atomic.Int64{}
+			overlaps := atomic.Int64{}
+			wg := sync.WaitGroup{}
+			for i := 0; i < 16; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 200; j++ {
+						Run([]string{fmt.Sprintf("key:%d:%d", i, j)}, func() {
+							running.Add(1)
+							runtime.Gosched()
+							running.Add(-1)
+						})
+					}
+				}(i)
+			}
+			for i := 0; i < 50; i++ {
+				runtime.Gosched()
+				RunExclusive(func() {
+					if running.Load() != 0 {
+						overlaps.Add(1)
+					}
+				})
+				RunAtomically([]string{fmt.Sprintf("key:%d", i)}, func() {
+					if m.mode == configuration.COMMAND_EXECUTOR_THREADED && running.Load() != 0 {
+						overlaps.Add(1)
+					}
+				})
+			}
+			wg.Wait()
+
+			if n := overlaps.Load(); n != 0 {
+				t.Errorf("exclusive work ran alongside commands %d times", n)
+			}
+		})
+	}
+}
+
+// command stands in for the work of a small command
+func command(value []byte) func() {
+	return func() {
+		crc32.ChecksumIEEE(value)
+	}
+}
+
+func benchmarkRun(b *testing.B, executorMode string, shards int, keysPerCommand int) {
+	start(b, executorMode, shards)
+	value := make([]byte, 64)
+
+	b.SetParallelism(PARALLELISM)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		keys := make([]string, keysPerCommand)
+		i := 0
+		for pb.Next() {
+			for k := range keys {
+				keys[k] = fmt.Sprintf("key:%d", i+k)
+			}
+			Run(keys, command(value))
+			i++
+		}
+	})
+}
+
+func BenchmarkThreaded(b *testing.B) {
+	benchmarkRun(b, configuration.COMMAND_EXECUTOR_THREADED, 0, 1)
+}
+
+func BenchmarkSingle(b *testing.B) {
+	benchmarkRun(b, configuration.COMMAND_EXECUTOR_SINGLE, 1, 1)
+}
+
+func BenchmarkSharded(b *testing.B) {
+	benchmarkRun(b, configuration.COMMAND_EXECUTOR_SHARDED, SHARDS, 1)
+}
+
+// Commands whose keys are likely to span several shards
+func BenchmarkShardedMultiKey(b *testing.B) {
+	benchmarkRun(b, configuration.COMMAND_EXECUTOR_SHARDED, SHARDS, 3)
+}
+
+// EXEC runs exclusively in the threaded mode, so it is benchmarked against
+// single key commands running alongside it
+func BenchmarkThreadedExec(b *testing.B) {
+	start(b, configuration.COMMAND_EXECUTOR_THREADED, 0)
+	value := make([]byte, 64)
+
+	b.SetParallelism(PARALLELISM)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			keys := []string{fmt.Sprintf("key:%d", i)}
+			if i%10 == 0 {
+				RunAtomically(keys, command(value))
+			} else {
+				Run(keys, command(value))
+			}
+			i++
+		}
+	})
+}
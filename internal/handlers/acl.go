@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// permissionError describes why a user may not run a command, in the reason
+// and object form used by the ACL log
+type permissionError struct {
+	reason string
+	object string
+}
+
+func (e permissionError) Error() string {
+	switch e.reason {
+	case acl.LOG_REASON_KEY:
+		return "No permissions to access a key"
+	case acl.LOG_REASON_CHANNEL:
+		return "No permissions to access a channel"
+	default:
+		return fmt.Sprintf("no permissions to run the '%s' command", e.object)
+	}
+}
+
+// checkPermissions checks a command, its keys and its channels against the
+// user's ACL rules
+func checkPermissions(user *acl.User, command string, args []resp.RespValue) *permissionError {
+	spec, subcommand := lookupCommandSpec(command, args)
+
+	if user == nil || !user.CanRunCommand(command, subcommand, spec.categories) {
+		return &permissionError{reason: acl.LOG_REASON_COMMAND, object: commandName(command, args)}
+	}
+
+	read := slices.Contains(spec.categories, "read")
+	write := slices.Contains(spec.categories, "write")
+	for _, key := range spec.keys(args) {
+		if !user.CanAccessKey(key, read, write) {
+			return &permissionError{reason: acl.LOG_REASON_KEY, object: key}
+		}
+	}
+
+	for _, channel := range spec.channels(args) {
+		if !user.CanAccessChannel(channel) {
+			return &permissionError{reason: acl.LOG_REASON_CHANNEL, object: channel}
+		}
+	}
+
+	return nil
+}
+
+// permissionDenied logs a denied command and builds the NOPERM reply
+func permissionDenied(conn *connection.Connection, err *permissionError) resp.RespValue {
+	acl.AddLogEntry(err.reason, "toplevel", err.object, conn.User(), describeClient(conn))
+
+	message := err.Error()
+	if err.reason == acl.LOG_REASON_COMMAND {
+		message = fmt.Sprintf("User %s has %s", conn.User(), message)
+	}
+
+	return generateErrorResponse(newRedisError(CODE_NOPERM, "%s", message))
+}
+
+// authenticate logs the connection in as a user, recording failures in the
+// ACL log
+func authenticate(conn *connection.Connection, username string, password string) error {
+	if _, err := acl.Authenticate(username, password); err != nil {
+		acl.AddLogEntry(acl.LOG_REASON_AUTH, "toplevel", "AUTH", username, describeClient(conn))
+		return err
+	}
+
+	conn.SetUser(username)
+	conn.SetValidated(true)
+	return nil
+}
+
+func aclCommand(h handlerArgs) handlerResponse {
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "SETUSER":
+		return aclSetuser(args)
+	case "GETUSER":
+		return aclGetuser(h, args)
+	case "DELUSER":
+		return aclDeluser(args)
+	case "LIST":
+		return aclList()
+	case "USERS":
+		return aclUsers()
+	case "WHOAMI":
+		return aclWhoami(h)
+	case "CAT":
+		return aclCat(args)
+	case "DRYRUN":
+		return aclDryrun(args)
+	case "LOG":
+		return aclLog(h, args)
+	case "LOAD":
+		return aclLoad()
+	case "SAVE":
+		return aclSave()
+	default:
+		return handlerResponse{
+			err: unknownSubcommandError("acl", h.args[0].Bulk),
+		}
+	}
+}
+
+func aclSetuser(args []resp.RespValue) handlerResponse {
+	rules := []string{}
+	for _, a := range args[1:] {
+		rules = append(rules, a.Bulk)
+	}
+	if err := acl.SetUser(args[0].Bulk, rules); err != nil {
+		return handlerResponse{err: err}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func aclGetuser(h handlerArgs, args []resp.RespValue) handlerResponse {
+	u := acl.GetUser(args[0].Bulk)
+	if u == nil {
+		return handlerResponse{
+			resp: generateNullResponse(),
+		}
+	}
+
+	flags := []resp.RespValue{}
+	for _, f := range u.Flags() {
+		flags = append(flags, generateBulkResponse(f))
+	}
+	passwords := []resp.RespValue{}
+	for _, p := range u.Passwords() {
+		passwords = append(passwords, generateBulkResponse(p))
+	}
+
+	return handlerResponse{
+		resp: generateMapResponse(h, []resp.RespValue{
+			generateBulkResponse("flags"), generateArrayResponse(flags),
+			generateBulkResponse("passwords"), generateArrayResponse(passwords),
+			generateBulkResponse("commands"), generateBulkResponse(u.CommandRules()),
+			generateBulkResponse("keys"), generateBulkResponse(u.KeyRules()),
+			generateBulkResponse("channels"), generateBulkResponse(u.ChannelRules()),
+			generateBulkResponse("selectors"), generateArrayResponse([]resp.RespValue{}),
+		}),
+	}
+}
+
+func aclDeluser(args []resp.RespValue) handlerResponse {
+	names := []string{}
+	for _, a := range args {
+		names = append(names, a.Bulk)
+	}
+	deleted, err := acl.DeleteUsers(names)
+	if err != nil {
+		return handlerResponse{err: err}
+	}
+	disconnectRemovedUsers()
+
+	return handlerResponse{
+		resp: generateIntegerResponse(deleted),
+	}
+}
+
+// disconnectRemovedUsers closes the connections of users that no longer
+// exist
+func disconnectRemovedUsers() {
+	for _, c := range connection.All() {
+		if c.Validated() && acl.GetUser(c.User()) == nil {
+			c.Close()
+		}
+	}
+}
+
+func aclList() handlerResponse {
+	list := []resp.RespValue{}
+	for _, u := range acl.Users() {
+		list = append(list, generateBulkResponse(u.String()))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(list),
+	}
+}
+
+func aclUsers() handlerResponse {
+	names := []resp.RespValue{}
+	for _, u := range acl.Users() {
+		names = append(names, generateBulkResponse(u.Name))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(names),
+	}
+}
+
+func aclWhoami(h handlerArgs) handlerResponse {
+	return handlerResponse{
+		resp: generateBulkResponse(h.conn.User()),
+	}
+}
+
+func aclCat(args []resp.RespValue) handlerResponse {
+	if len(args) > 1 {
+		return handlerResponse{
+			err: fmt.Errorf("wrong number of arguments for 'acl|cat' command"),
+		}
+	}
+
+	names := acl.Categories[1:]
+	if len(args) == 1 {
+		category := strings.ToLower(args[0].Bulk)
+		if !slices.Contains(acl.Categories, category) {
+			return handlerResponse{
+				err: fmt.Errorf("Unknown category '%s'", args[0].Bulk),
+			}
+		}
+		names = commandsInCategory(category)
+	}
+
+	list := []resp.RespValue{}
+	for _, n := range names {
+		list = append(list, generateBulkResponse(n))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(list),
+	}
+}
+
+// aclDryrun checks whether a user could run a command without running it
+func aclDryrun(args []resp.RespValue) handlerResponse {
+	u := acl.GetUser(args[0].Bulk)
+	if u == nil {
+		return handlerResponse{
+			err: fmt.Errorf("User '%s' not found", args[0].Bulk),
+		}
+	}
+
+	command := strings.ToUpper(args[1].Bulk)
+	if _, ok := commandTable[command]; !ok {
+		return handlerResponse{
+			err: fmt.Errorf("Command '%s' not found", args[1].Bulk),
+		}
+	}
+
+	if err := checkPermissions(u, command, args[2:]); err != nil {
+		message := err.Error()
+		if err.reason == acl.LOG_REASON_COMMAND {
+			message = fmt.Sprintf("This user has %s", message)
+		}
+		return handlerResponse{
+			resp: generateBulkResponse(message),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func aclLog(h handlerArgs, args []resp.RespValue) handlerResponse {
+	if len(args) > 1 {
+		return handlerResponse{
+			err: fmt.Errorf("wrong number of arguments for 'acl|log' command"),
+		}
+	}
+
+	count := 10
+	if len(args) == 1 {
+		if strings.ToUpper(args[0].Bulk) == "RESET" {
+			acl.ResetLog()
+			return handlerResponse{
+				resp: generateStringResponse("OK"),
+			}
+		}
+		n, err := strconv.Atoi(args[0].Bulk)
+		if err != nil || n < 0 {
+			return handlerResponse{
+				err: fmt.Errorf("value is out of range, must be positive"),
+			}
+		}
+		count = n
+	}
+
+	now := time.Now()
+	entries := []resp.RespValue{}
+	for _, e := range acl.LogEntries(count) {
+		entries = append(entries, generateMapResponse(h, []resp.RespValue{
+			generateBulkResponse("count"), generateIntegerResponse(e.Count),
+			generateBulkResponse("reason"), generateBulkResponse(e.Reason),
+			generateBulkResponse("context"), generateBulkResponse(e.Context),
+			generateBulkResponse("object"), generateBulkResponse(e.Object),
+			generateBulkResponse("username"), generateBulkResponse(e.Username),
+			generateBulkResponse("age-seconds"), generateBulkResponse(strconv.FormatFloat(now.Sub(e.Created).Seconds(), 'f', 3, 64)),
+			generateBulkResponse("client-info"), generateBulkResponse(e.ClientInfo),
+			generateBulkResponse("entry-id"), generateIntegerResponse(int(e.ID)),
+			generateBulkResponse("timestamp-created"), generateIntegerResponse(int(e.Created.UnixMilli())),
+			generateBulkResponse("timestamp-last-updated"), generateIntegerResponse(int(e.Updated.UnixMilli())),
+		}))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(entries),
+	}
+}
+
+func aclLoad() handlerResponse {
+	if err := acl.Load(); err != nil {
+		return handlerResponse{err: err}
+	}
+	disconnectRemovedUsers()
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func aclSave() handlerResponse {
+	if err := acl.Save(); err == acl.ErrNoACLFile {
+		return handlerResponse{err: err}
+	} else if err != nil {
+		logger.Warning("Saving ACL file: %v", err)
+		return handlerResponse{
+			err: fmt.Errorf("There was an error trying to save the ACLs. Please check the server logs for more information"),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// checkPermissions matches every key of a command against the user's key
+// patterns for the access the command needs, and every channel against the
+// channel patterns
+func TestCheckPermissions(t *testing.T) {
+	rules := []string{"on", "nopass", "+@all", "-config|set", "~cache:*", "%R~src:*", "%W~dst:*", "&news:*"}
+	if err := acl.SetUser("checked", rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { acl.DeleteUsers([]string{"checked"}) })
+	user := acl.GetUser("checked")
+
+	tests := []struct {
+		args []string
+		want *permissionError
+	}{
+		{[]string{"GET", "cache:a"}, nil},
+		{[]string{"SET", "cache:a", "v"}, nil},
+		{[]string{"GET", "other"}, &permissionError{reason: acl.LOG_REASON_KEY, object: "other"}},
+		{[]string{"GET", "src:a"}, nil},
+		{[]string{"SET", "src:a", "v"}, &permissionError{reason: acl.LOG_REASON_KEY, object: "src:a"}},
+		{[]string{"GET", "dst:a"}, &permissionError{reason: acl.LOG_REASON_KEY, object: "dst:a"}},
+		{[]string{"DEL", "cache:a", "dst:a", "src:a"}, &permissionError{reason: acl.LOG_REASON_KEY, object: "src:a"}},
+		{[]string{"EXISTS", "cache:a", "src:a", "dst:a"}, &permissionError{reason: acl.LOG_REASON_KEY, object: "dst:a"}},
+		{[]string{"PUBLISH", "news:today", "m"}, nil},
+		{[]string{"PUBLISH", "sport", "m"}, &permissionError{reason: acl.LOG_REASON_CHANNEL, object: "sport"}},
+		{[]string{"SUBSCRIBE", "news:a", "news:b", "sport"}, &permissionError{reason: acl.LOG_REASON_CHANNEL, object: "sport"}},
+		{[]string{"SPUBLISH", "sport", "m"}, &permissionError{reason: acl.LOG_REASON_CHANNEL, object: "sport"}},
+		{[]string{"CONFIG", "GET", "port"}, nil},
+		{[]string{"CONFIG", "SET", "port", "1"}, &permissionError{reason: acl.LOG_REASON_COMMAND, object: "config|set"}},
+	}
+
+	for _, test := range tests {
+		args := []resp.RespValue{}
+		for _, a := range test.args[1:] {
+			args = append(args, resp.RespValue{Type: resp.TYPE_BULK, Bulk: a})
+		}
+
+		got := checkPermissions(user, test.args[0], args)
+		if (got == nil) != (test.want == nil) || (got != nil && *got != *test.want) {
+			t.Errorf("checkPermissions(%q) = %+v, want %+v", test.args, got, test.want)
+		}
+	}
+
+	if got := checkPermissions(nil, "GET", nil); got == nil || got.reason != acl.LOG_REASON_COMMAND {
+		t.Errorf("checkPermissions without a user returned %+v", got)
+	}
+}
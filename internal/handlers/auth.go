@@ -1,23 +1,39 @@
 package handlers
 
-import "fmt"
+import (
+	"fmt"
 
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+)
+
+// auth accepts either a password for the default user, or a username and
+// password
 func auth(h handlerArgs) handlerResponse {
-	if len(h.args) != 1 {
+	if len(h.args) > 2 {
 		return handlerResponse{
-			err: fmt.Errorf("Wrong number of args passed to 'auth command'"),
+			err: fmt.Errorf("syntax error"),
 		}
 	}
 
+	username := acl.DEFAULT_USER
 	password := h.args[0].Bulk
+	if len(h.args) == 1 {
+		if u := acl.GetUser(acl.DEFAULT_USER); u != nil && u.NoPass {
+			return handlerResponse{
+				err: fmt.Errorf("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"),
+			}
+		}
+	} else {
+		username = h.args[0].Bulk
+		password = h.args[1].Bulk
+	}
 
-	if err := h.config.ValidatePassword(password); err != nil {
+	if err := authenticate(h.conn, username, password); err != nil {
 		return handlerResponse{
-			err: err,
+			err: newRedisError(CODE_WRONGPASS, "%s", err),
 		}
 	}
 
-	h.conn.Validated = true
 	return handlerResponse{
 		resp: generateStringResponse("OK"),
 	}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// KEYSPACE_EVENTS_CHANNEL carries keyspace notifications between instances,
+// as the change feed only knows which keys changed and not the command.
+const KEYSPACE_EVENTS_CHANNEL = "redis_keyspace_events"
+
+type relayedKeyspaceEvent struct {
+	Instance string `json:"instance"`
+	Class    int    `json:"class"`
+	Event    string `json:"event"`
+	Key      string `json:"key"`
+}
+
+// relayKeyspaceEvent is set while the change feed is running
+var relayKeyspaceEvent func(class int, event string, key string)
+
+// StartChangeFeed makes writes from other instances sharing the store
+// invalidate client side caches and publish keyspace notifications here,
+// exactly like local writes, when keyspace-change-feed is enabled.
+func StartChangeFeed(store storage.Store, config *configuration.Config) error {
+	if !config.Get().KeyspaceChangeFeed {
+		return nil
+	}
+
+	feed, ok := store.(storage.ChangeFeed)
+	if !ok {
+		return fmt.Errorf("keyspace-change-feed is not supported by the configured store")
+	}
+	notifier, ok := store.(storage.Notifier)
+	if !ok {
+		return fmt.Errorf("keyspace-change-feed is not supported by the configured store")
+	}
+
+	changes, err := feed.Changes()
+	if err != nil {
+		return err
+	}
+	events, err := notifier.Listen(KEYSPACE_EVENTS_CHANNEL)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for c := range changes {
+			if c.Truncated {
+				invalidateAll(nil)
+				continue
+			}
+			invalidateKey(c.Key, nil)
+		}
+	}()
+
+	go func() {
+		for payload := range events {
+			e := relayedKeyspaceEvent{}
+			if err := json.Unmarshal([]byte(payload), &e); err != nil {
+				logger.Warning("Invalid relayed keyspace event: %v", err)
+				continue
+			}
+			if e.Instance == storage.InstanceID {
+				continue
+			}
+			publishKeyspaceEvent(config, e.Class, e.Event, e.Key)
+		}
+	}()
+
+	relayKeyspaceEvent = func(class int, event string, key string) {
+		payload, err := json.Marshal(relayedKeyspaceEvent{
+			Instance: storage.InstanceID,
+			Class:    class,
+			Event:    event,
+			Key:      key,
+		})
+		if err != nil {
+			logger.Warning("Relaying keyspace event: %v", err)
+			return
+		}
+		if err := notifier.Notify(KEYSPACE_EVENTS_CHANNEL, string(payload)); err != nil {
+			logger.Warning("Relaying keyspace event: %v", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,320 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Client types accepted by CLIENT LIST TYPE and CLIENT KILL TYPE. There is no
+// replication, so no client is ever a master or replica.
+var clientTypes = []string{"normal", "master", "replica", "slave", "pubsub"}
+
+func clientFlags(c *connection.Connection) string {
+	flags := ""
+	if c.IsSubscribed() {
+		flags += "P"
+	}
+	if c.CloseAfterReply() {
+		flags += "c"
+	}
+	if c.IsUnixSocket() {
+		flags += "U"
+	}
+	if t := c.Tracking(); t.Enabled {
+		flags += "t"
+		if _, ok := connection.Get(t.Redirect); t.Redirect != 0 && !ok {
+			flags += "R"
+		}
+	}
+	if c.NoEvict() {
+		flags += "e"
+	}
+	if c.IsMonitor() {
+		flags += "O"
+	}
+	if c.InMulti() {
+		flags += "x"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+
+	return flags
+}
+
+func clientType(c *connection.Connection) string {
+	if c.IsSubscribed() {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// describeClient formats a client the way CLIENT LIST and CLIENT INFO do
+func describeClient(c *connection.Connection) string {
+	redirect := int64(-1)
+	if t := c.Tracking(); t.Enabled {
+		redirect = t.Redirect
+	}
+	pending, pendingBytes := c.OutputBuffer()
+
+	fields := []string{
+		fmt.Sprintf("id=%d", c.ID),
+		fmt.Sprintf("addr=%s", c.Addr()),
+		fmt.Sprintf("laddr=%s", c.LocalAddr()),
+		fmt.Sprintf("name=%s", c.Name()),
+		fmt.Sprintf("age=%d", int(time.Since(c.CreatedAt).Seconds())),
+		fmt.Sprintf("idle=%d", int(c.Idle().Seconds())),
+		fmt.Sprintf("flags=%s", clientFlags(c)),
+		"db=0",
+		fmt.Sprintf("sub=%d", c.SubscriptionCount()),
+		"psub=0",
+		fmt.Sprintf("ssub=%d", c.ShardSubscriptionCount()),
+		fmt.Sprintf("multi=%d", c.MultiLen()),
+		"watch=0",
+		fmt.Sprintf("qbuf=%d", c.QueryBuffer()),
+		fmt.Sprintf("qbuf-free=%d", c.ReadBufferSize()-c.QueryBuffer()),
+		fmt.Sprintf("rbs=%d", c.ReadBufferSize()),
+		"obl=0",
+		fmt.Sprintf("oll=%d", pending),
+		fmt.Sprintf("omem=%d", pendingBytes),
+		"events=r",
+		fmt.Sprintf("cmd=%s", c.LastCommand()),
+		fmt.Sprintf("user=%s", c.User()),
+		fmt.Sprintf("redir=%d", redirect),
+		fmt.Sprintf("resp=%d", c.Protocol()),
+	}
+
+	return strings.Join(fields, " ")
+}
+
+func sortedClients() []*connection.Connection {
+	clients := connection.All()
+	slices.SortFunc(clients, func(a, b *connection.Connection) int {
+		return int(a.ID - b.ID)
+	})
+	return clients
+}
+
+func clientList(args []resp.RespValue) handlerResponse {
+	filterType := ""
+	ids := []int64{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "TYPE":
+			if i+1 >= len(args) {
+				return handlerResponse{err: fmt.Errorf("syntax error")}
+			}
+			i++
+			filterType = strings.ToLower(args[i].Bulk)
+			if !slices.Contains(clientTypes, filterType) {
+				return handlerResponse{err: fmt.Errorf("Unknown client type '%s'", args[i].Bulk)}
+			}
+		case "ID":
+			if i+1 >= len(args) {
+				return handlerResponse{err: fmt.Errorf("syntax error")}
+			}
+			for i+1 < len(args) {
+				i++
+				id, err := strconv.ParseInt(args[i].Bulk, 10, 64)
+				if err != nil || id <= 0 {
+					return handlerResponse{err: fmt.Errorf("Invalid client ID")}
+				}
+				ids = append(ids, id)
+			}
+		default:
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+	}
+
+	var list strings.Builder
+	for _, c := range sortedClients() {
+		if filterType != "" && clientType(c) != filterType {
+			continue
+		}
+		if len(ids) > 0 && !slices.Contains(ids, c.ID) {
+			continue
+		}
+		list.WriteString(describeClient(c))
+		list.WriteString("\n")
+	}
+
+	return handlerResponse{
+		resp: generateBulkResponse(list.String()),
+	}
+}
+
+func clientInfo(h handlerArgs) handlerResponse {
+	return handlerResponse{
+		resp: generateBulkResponse(describeClient(h.conn) + "\n"),
+	}
+}
+
+// clientKill supports both the old CLIENT KILL addr:port form and filters,
+// which can be combined and are all required to match
+func clientKill(h handlerArgs, args []resp.RespValue) handlerResponse {
+	if len(args) == 1 {
+		for _, c := range sortedClients() {
+			if c.Addr() == args[0].Bulk {
+				killClient(h, c)
+				return handlerResponse{
+					resp: generateStringResponse("OK"),
+				}
+			}
+		}
+		return handlerResponse{err: fmt.Errorf("No such client")}
+	}
+
+	if len(args)%2 != 0 {
+		return handlerResponse{err: fmt.Errorf("syntax error")}
+	}
+
+	filters := []func(c *connection.Connection) bool{}
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := args[i+1].Bulk
+		switch strings.ToUpper(args[i].Bulk) {
+		case "ID":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return handlerResponse{err: fmt.Errorf("client-id should be greater than 0")}
+			}
+			filters = append(filters, func(c *connection.Connection) bool { return c.ID == id })
+		case "TYPE":
+			t := strings.ToLower(value)
+			if !slices.Contains(clientTypes, t) {
+				return handlerResponse{err: fmt.Errorf("Unknown client type '%s'", value)}
+			}
+			filters = append(filters, func(c *connection.Connection) bool { return clientType(c) == t })
+		case "USER":
+			if acl.GetUser(value) == nil {
+				return handlerResponse{err: fmt.Errorf("No such user '%s'", value)}
+			}
+			filters = append(filters, func(c *connection.Connection) bool { return c.User() == value })
+		case "ADDR":
+			filters = append(filters, func(c *connection.Connection) bool { return c.Addr() == value })
+		case "LADDR":
+			filters = append(filters, func(c *connection.Connection) bool { return c.LocalAddr() == value })
+		case "MAXAGE":
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return handlerResponse{err: fmt.Errorf("syntax error")}
+			}
+			filters = append(filters, func(c *connection.Connection) bool {
+				return time.Since(c.CreatedAt) >= time.Duration(maxAge)*time.Second
+			})
+		case "SKIPME":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return handlerResponse{err: fmt.Errorf("syntax error")}
+			}
+		default:
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+	}
+
+	killed := 0
+	for _, c := range sortedClients() {
+		if skipMe && c == h.conn {
+			continue
+		}
+		matches := true
+		for _, f := range filters {
+			if !f(c) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			killClient(h, c)
+			killed++
+		}
+	}
+
+	return handlerResponse{
+		resp: generateIntegerResponse(killed),
+	}
+}
+
+// killClient disconnects a client. A client killing itself still gets the
+// reply to CLIENT KILL first.
+func killClient(h handlerArgs, c *connection.Connection) {
+	if c == h.conn {
+		c.SetCloseAfterReply()
+		return
+	}
+	c.Close()
+}
+
+func clientSetname(h handlerArgs, args []resp.RespValue) handlerResponse {
+	name := args[0].Bulk
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return handlerResponse{
+				err: fmt.Errorf("Client names cannot contain spaces, newlines or special characters."),
+			}
+		}
+	}
+	h.conn.SetName(name)
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func clientGetname(h handlerArgs) handlerResponse {
+	name := h.conn.Name()
+	if name == "" {
+		return handlerResponse{
+			resp: generateNullResponse(),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateBulkResponse(name),
+	}
+}
+
+func clientNoEvict(h handlerArgs, args []resp.RespValue) handlerResponse {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "ON":
+		h.conn.SetNoEvict(true)
+	case "OFF":
+		h.conn.SetNoEvict(false)
+	default:
+		return handlerResponse{err: fmt.Errorf("syntax error")}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+// clientReply replies OK to ON, while OFF and SKIP are never replied to
+func clientReply(h handlerArgs, args []resp.RespValue) handlerResponse {
+	mode := strings.ToUpper(args[0].Bulk)
+	switch mode {
+	case connection.REPLY_ON, connection.REPLY_OFF:
+		h.conn.SetReplyMode(mode)
+		return handlerResponse{
+			resp: generateStringResponse("OK"),
+		}
+	case connection.REPLY_SKIP:
+		h.conn.SetReplyMode(mode)
+		return handlerResponse{
+			resp: generateVoidResponse(),
+		}
+	default:
+		return handlerResponse{err: fmt.Errorf("syntax error")}
+	}
+}
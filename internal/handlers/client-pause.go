@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Server wide state of CLIENT PAUSE. Waiting clients are woken by closing
+// unpaused, which is replaced whenever the pause changes.
+var pause = struct {
+	mutex    sync.Mutex
+	until    time.Time
+	all      bool
+	unpaused chan struct{}
+	waiting  int
+}{unpaused: make(chan struct{})}
+
+// waitWhilePaused blocks a command until the clients are unpaused. Pausing
+// WRITE only holds back commands that write to the keyspace, while ALL holds
+// back everything except CLIENT UNPAUSE, so that a pause can still be ended
+// early. EXEC writes if any of the commands it runs does.
+func waitWhilePaused(conn *connection.Connection, command string, args []resp.RespValue) {
+	if command == "CLIENT" && len(args) > 0 && strings.ToUpper(args[0].Bulk) == "UNPAUSE" {
+		return
+	}
+	write := isWriteCommand(command, args)
+	if command == "EXEC" {
+		for _, argv := range conn.QueuedCommands() {
+			write = write || isWriteCommand(strings.ToUpper(argv[0].Bulk), argv[1:])
+		}
+	}
+
+	for {
+		pause.mutex.Lock()
+		remaining := time.Until(pause.until)
+		paused := remaining > 0 && (pause.all || write)
+		unpaused := pause.unpaused
+		pause.mutex.Unlock()
+
+		if !paused {
+			return
+		}
+
+		pause.mutex.Lock()
+		pause.waiting++
+		pause.mutex.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-unpaused:
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		pause.mutex.Lock()
+		pause.waiting--
+		pause.mutex.Unlock()
+	}
+}
+
+func isWriteCommand(command string, args []resp.RespValue) bool {
+	spec, _ := lookupCommandSpec(command, args)
+	return slices.Contains(spec.categories, "write")
+}
+
+// BlockedClients counts the clients held back by CLIENT PAUSE, which are the
+// only ones that can block
+func BlockedClients() int {
+	pause.mutex.Lock()
+	defer pause.mutex.Unlock()
+	return pause.waiting
+}
+
+// pauseClients starts or extends a pause. An overlapping pause keeps the
+// later end time and the stricter mode.
+func pauseClients(until time.Time, all bool) {
+	pause.mutex.Lock()
+	defer pause.mutex.Unlock()
+
+	if time.Now().Before(pause.until) {
+		all = all || pause.all
+		if pause.until.After(until) {
+			until = pause.until
+		}
+	}
+	pause.until = until
+	pause.all = all
+}
+
+func unpauseClients() {
+	pause.mutex.Lock()
+	defer pause.mutex.Unlock()
+
+	pause.until = time.Time{}
+	pause.all = false
+	close(pause.unpaused)
+	pause.unpaused = make(chan struct{})
+}
+
+func clientPause(args []resp.RespValue) handlerResponse {
+	if len(args) > 2 {
+		return handlerResponse{
+			err: fmt.Errorf("wrong number of arguments for 'client|pause' command"),
+		}
+	}
+
+	timeout, err := strconv.ParseInt(args[0].Bulk, 10, 64)
+	if err != nil || timeout < 0 {
+		return handlerResponse{
+			err: fmt.Errorf("timeout is not an integer or out of range"),
+		}
+	}
+
+	all := true
+	if len(args) == 2 {
+		switch strings.ToUpper(args[1].Bulk) {
+		case "ALL":
+		case "WRITE":
+			all = false
+		default:
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+	}
+
+	pauseClients(time.Now().Add(time.Duration(timeout)*time.Millisecond), all)
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func clientUnpause() handlerResponse {
+	unpauseClients()
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
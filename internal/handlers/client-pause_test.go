@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+// CLIENT PAUSE WRITE holds back EXEC when one of the queued commands writes
+func TestClientPauseWriteHoldsBackExec(t *testing.T) {
+	s := newTestServer(t)
+	writer, reader, admin := s.client(), s.client(), s.client()
+
+	writer.do("MULTI")
+	writer.do("SET", "k", "v")
+	reader.do("MULTI")
+	reader.do("GET", "k")
+
+	if got := admin.do("CLIENT", "PAUSE", "10000", "WRITE"); got != "+OK\r\n" {
+		t.Fatalf("CLIENT PAUSE replied %q", got)
+	}
+	t.Cleanup(func() { admin.do("CLIENT", "UNPAUSE") })
+
+	done := make(chan string, 1)
+	go func() { done <- writer.do("EXEC") }()
+
+	if got, want := reader.do("EXEC"), "*1\r\n$-1\r\n"; got != want {
+		t.Errorf("EXEC of a read replied %q, want %q", got, want)
+	}
+
+	select {
+	case got := <-done:
+		t.Fatalf("EXEC of a write replied %q while the clients were paused", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	admin.do("CLIENT", "UNPAUSE")
+	select {
+	case got := <-done:
+		if want := "*1\r\n+OK\r\n"; got != want {
+			t.Errorf("EXEC replied %q, want %q", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EXEC was still held back after CLIENT UNPAUSE")
+	}
+}
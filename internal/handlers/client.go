@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+func client(h handlerArgs) handlerResponse {
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "ID":
+		return handlerResponse{
+			resp: generateIntegerResponse(int(h.conn.ID)),
+		}
+	case "INFO":
+		return clientInfo(h)
+	case "LIST":
+		return clientList(args)
+	case "KILL":
+		return clientKill(h, args)
+	case "SETNAME":
+		return clientSetname(h, args)
+	case "GETNAME":
+		return clientGetname(h)
+	case "PAUSE":
+		return clientPause(args)
+	case "UNPAUSE":
+		return clientUnpause()
+	case "NO-EVICT":
+		return clientNoEvict(h, args)
+	case "REPLY":
+		return clientReply(h, args)
+	case "TRACKING":
+		return clientTracking(h, args)
+	case "CACHING":
+		return clientCaching(h, args)
+	case "GETREDIR":
+		return clientGetredir(h)
+	case "TRACKINGINFO":
+		return clientTrackingInfo(h)
+	default:
+		return handlerResponse{
+			err: unknownSubcommandError("client", h.args[0].Bulk),
+		}
+	}
+}
+
+func clientTracking(h handlerArgs, args []resp.RespValue) handlerResponse {
+	switch strings.ToUpper(args[0].Bulk) {
+	case "OFF":
+		if len(args) > 1 {
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+		disableTracking(h.conn)
+		return handlerResponse{
+			resp: generateStringResponse("OK"),
+		}
+	case "ON":
+	default:
+		return handlerResponse{err: fmt.Errorf("syntax error")}
+	}
+
+	t := connection.Tracking{Enabled: true}
+	prefixes := []string{}
+	for i := 1; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "REDIRECT":
+			if i+1 >= len(args) {
+				return handlerResponse{err: fmt.Errorf("syntax error")}
+			}
+			i++
+			id, err := strconv.ParseInt(args[i].Bulk, 10, 64)
+			if err != nil {
+				return handlerResponse{err: fmt.Errorf("value is not an integer or out of range")}
+			}
+			t.Redirect = id
+		case "PREFIX":
+			if i+1 >= len(args) {
+				return handlerResponse{err: fmt.Errorf("syntax error")}
+			}
+			i++
+			prefixes = append(prefixes, args[i].Bulk)
+		case "BCAST":
+			t.BCast = true
+		case "OPTIN":
+			t.OptIn = true
+		case "OPTOUT":
+			t.OptOut = true
+		case "NOLOOP":
+			t.NoLoop = true
+		default:
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+	}
+
+	current := h.conn.Tracking()
+	if current.Enabled && current.BCast != t.BCast {
+		return handlerResponse{
+			err: fmt.Errorf("You can't switch BCAST mode on/off before disabling tracking for this client, and then re-enabling it with a different mode."),
+		}
+	}
+	if current.Enabled && (current.OptIn != t.OptIn || current.OptOut != t.OptOut) {
+		return handlerResponse{
+			err: fmt.Errorf("You can't switch OPTIN/OPTOUT mode before disabling tracking for this client, and then re-enabling it with a different mode."),
+		}
+	}
+	if t.OptIn && t.OptOut {
+		return handlerResponse{
+			err: fmt.Errorf("You can't use both OPTIN and OPTOUT"),
+		}
+	}
+	if t.BCast && (t.OptIn || t.OptOut) {
+		return handlerResponse{
+			err: fmt.Errorf("OPTIN and OPTOUT are not compatible with BCAST"),
+		}
+	}
+	if len(prefixes) > 0 && !t.BCast {
+		return handlerResponse{
+			err: fmt.Errorf("PREFIX option requires BCAST mode to be enabled"),
+		}
+	}
+	if t.Redirect != 0 {
+		if _, ok := connection.Get(t.Redirect); !ok {
+			return handlerResponse{
+				err: fmt.Errorf("The client ID you want redirect to does not exist"),
+			}
+		}
+	}
+
+	if t.BCast {
+		if current.Enabled {
+			t.Prefixes = append(t.Prefixes, current.Prefixes...)
+		}
+		if len(prefixes) == 0 && len(t.Prefixes) == 0 {
+			prefixes = append(prefixes, "")
+		}
+		for _, p := range prefixes {
+			if slices.Contains(t.Prefixes, p) {
+				continue
+			}
+			for _, existing := range t.Prefixes {
+				if strings.HasPrefix(p, existing) || strings.HasPrefix(existing, p) {
+					return handlerResponse{
+						err: fmt.Errorf("Prefix '%s' overlaps with an existing prefix '%s'. Prefixes for a single client must not overlap.", p, existing),
+					}
+				}
+			}
+			t.Prefixes = append(t.Prefixes, p)
+		}
+	}
+
+	enableTracking(h.conn, t)
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func clientCaching(h handlerArgs, args []resp.RespValue) handlerResponse {
+	t := h.conn.Tracking()
+	if !t.Enabled || (!t.OptIn && !t.OptOut) {
+		return handlerResponse{
+			err: fmt.Errorf("CLIENT CACHING can be called only when the client is in tracking mode with OPTIN or OPTOUT mode enabled"),
+		}
+	}
+
+	switch strings.ToUpper(args[0].Bulk) {
+	case "YES":
+		if !t.OptIn {
+			return handlerResponse{
+				err: fmt.Errorf("CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode."),
+			}
+		}
+		t.CachingYes = true
+	case "NO":
+		if !t.OptOut {
+			return handlerResponse{
+				err: fmt.Errorf("CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode."),
+			}
+		}
+		t.CachingNo = true
+	default:
+		return handlerResponse{err: fmt.Errorf("syntax error")}
+	}
+
+	h.conn.SetTracking(t)
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func clientGetredir(h handlerArgs) handlerResponse {
+	t := h.conn.Tracking()
+	if !t.Enabled {
+		return handlerResponse{
+			resp: generateIntegerResponse(-1),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateIntegerResponse(int(t.Redirect)),
+	}
+}
+
+func clientTrackingInfo(h handlerArgs) handlerResponse {
+	t := h.conn.Tracking()
+
+	flags := []resp.RespValue{}
+	redirect := -1
+	if !t.Enabled {
+		flags = append(flags, generateBulkResponse("off"))
+	} else {
+		redirect = int(t.Redirect)
+		flags = append(flags, generateBulkResponse("on"))
+		for _, f := range []struct {
+			name string
+			set  bool
+		}{
+			{"bcast", t.BCast},
+			{"optin", t.OptIn},
+			{"optout", t.OptOut},
+			{"caching-yes", t.CachingYes},
+			{"caching-no", t.CachingNo},
+			{"noloop", t.NoLoop},
+		} {
+			if f.set {
+				flags = append(flags, generateBulkResponse(f.name))
+			}
+		}
+		if t.Redirect != 0 {
+			if _, ok := connection.Get(t.Redirect); !ok {
+				flags = append(flags, generateBulkResponse("broken_redirect"))
+			}
+		}
+	}
+
+	prefixes := []resp.RespValue{}
+	for _, p := range t.Prefixes {
+		prefixes = append(prefixes, generateBulkResponse(p))
+	}
+
+	return handlerResponse{
+		resp: generateMapResponse(h, []resp.RespValue{
+			generateBulkResponse("flags"), generateArrayResponse(flags),
+			generateBulkResponse("redirect"), generateIntegerResponse(redirect),
+			generateBulkResponse("prefixes"), generateArrayResponse(prefixes),
+		}),
+	}
+}
@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/glob"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// commandCommand implements COMMAND and its subcommands from the command
+// table. Client libraries use the key positions to route commands in a
+// cluster.
+func commandCommand(h handlerArgs) handlerResponse {
+	if len(h.args) == 0 {
+		return commandInfo(h, nil)
+	}
+
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "COUNT":
+		return handlerResponse{
+			resp: generateIntegerResponse(len(commandTable)),
+		}
+	case "INFO":
+		return commandInfo(h, args)
+	case "DOCS":
+		return commandDocs(h, args)
+	case "GETKEYS":
+		return commandGetkeys(args)
+	case "LIST":
+		return commandList(args)
+	default:
+		return handlerResponse{
+			err: unknownSubcommandError("command", h.args[0].Bulk),
+		}
+	}
+}
+
+// sortedCommandNames returns the upper case names in the table
+func sortedCommandNames(commands map[string]commandSpec) []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// commandInfo describes the named commands, or all of them if none are
+// named. Unknown commands are replied with a null.
+func commandInfo(h handlerArgs, args []resp.RespValue) handlerResponse {
+	names := []string{}
+	for _, a := range args {
+		names = append(names, strings.ToUpper(a.Bulk))
+	}
+	if len(args) == 0 {
+		names = sortedCommandNames(commandTable)
+	}
+
+	list := []resp.RespValue{}
+	for _, name := range names {
+		spec, ok := commandTable[name]
+		if !ok {
+			list = append(list, generateNullResponse())
+			continue
+		}
+		list = append(list, commandInfoReply(h, strings.ToLower(name), spec))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(list),
+	}
+}
+
+// commandInfoReply builds the ten element reply Redis 7 gives for a command:
+// name, arity, flags, first key, last key, key step, ACL categories, tips,
+// key specs and subcommands
+func commandInfoReply(h handlerArgs, name string, spec commandSpec) resp.RespValue {
+	categories := []string{}
+	for _, c := range spec.categories {
+		categories = append(categories, "@"+c)
+	}
+
+	subcommands := []resp.RespValue{}
+	for _, subname := range sortedCommandNames(spec.subcommands) {
+		subcommands = append(subcommands, commandInfoReply(h, name+"|"+strings.ToLower(subname), spec.subcommands[subname]))
+	}
+
+	return generateArrayResponse([]resp.RespValue{
+		generateBulkResponse(name),
+		generateIntegerResponse(spec.arity),
+		statusSet(h, spec.flags),
+		generateIntegerResponse(spec.firstKey),
+		generateIntegerResponse(spec.lastKey),
+		generateIntegerResponse(spec.keyStep),
+		statusSet(h, categories),
+		generateArrayResponse([]resp.RespValue{}),
+		keySpecs(h, spec),
+		generateArrayResponse(subcommands),
+	})
+}
+
+// keySpecs describes the key positions as a single range key spec
+func keySpecs(h handlerArgs, spec commandSpec) resp.RespValue {
+	if spec.firstKey == 0 {
+		return generateArrayResponse([]resp.RespValue{})
+	}
+
+	flags := []string{}
+	switch {
+	case spec.notKey:
+		flags = append(flags, "not_key")
+	case spec.hasFlag(FLAG_WRITE):
+		flags = append(flags, "RW", "update")
+	default:
+		flags = append(flags, "RO", "access")
+	}
+
+	// The last key of a range is relative to the first one, unless it counts
+	// back from the end of the arguments
+	lastKey := spec.lastKey
+	if lastKey > 0 {
+		lastKey -= spec.firstKey
+	}
+
+	return generateArrayResponse([]resp.RespValue{
+		generateMapResponse(h, []resp.RespValue{
+			generateBulkResponse("flags"), statusSet(h, flags),
+			generateBulkResponse("begin_search"), generateMapResponse(h, []resp.RespValue{
+				generateBulkResponse("type"), generateBulkResponse("index"),
+				generateBulkResponse("spec"), generateMapResponse(h, []resp.RespValue{
+					generateBulkResponse("index"), generateIntegerResponse(spec.firstKey),
+				}),
+			}),
+			generateBulkResponse("find_keys"), generateMapResponse(h, []resp.RespValue{
+				generateBulkResponse("type"), generateBulkResponse("range"),
+				generateBulkResponse("spec"), generateMapResponse(h, []resp.RespValue{
+					generateBulkResponse("lastkey"), generateIntegerResponse(lastKey),
+					generateBulkResponse("keystep"), generateIntegerResponse(spec.keyStep),
+					generateBulkResponse("limit"), generateIntegerResponse(0),
+				}),
+			}),
+		}),
+	})
+}
+
+// statusSet replies with a set of status strings, which RESP2 clients get as
+// an array
+func statusSet(h handlerArgs, values []string) resp.RespValue {
+	list := []resp.RespValue{}
+	for _, v := range values {
+		list = append(list, generateStringResponse(v))
+	}
+
+	if h.conn.Protocol() == 3 {
+		return generateSetResponse(list)
+	}
+	return generateArrayResponse(list)
+}
+
+// commandDocs replies with a map of the named commands, or all of them if
+// none are named, to their docs. Unknown commands are left out.
+func commandDocs(h handlerArgs, args []resp.RespValue) handlerResponse {
+	names := []string{}
+	for _, a := range args {
+		names = append(names, strings.ToUpper(a.Bulk))
+	}
+	if len(args) == 0 {
+		names = sortedCommandNames(commandTable)
+	}
+
+	docs := []resp.RespValue{}
+	for _, name := range names {
+		spec, ok := commandTable[name]
+		if !ok {
+			continue
+		}
+		docs = append(docs, generateBulkResponse(strings.ToLower(name)), commandDocsReply(h, strings.ToLower(name), spec))
+	}
+
+	return handlerResponse{
+		resp: generateMapResponse(h, docs),
+	}
+}
+
+func commandDocsReply(h handlerArgs, name string, spec commandSpec) resp.RespValue {
+	fields := []resp.RespValue{
+		generateBulkResponse("summary"), generateBulkResponse(spec.summary),
+		generateBulkResponse("since"), generateBulkResponse(spec.since),
+		generateBulkResponse("group"), generateBulkResponse(spec.group),
+	}
+
+	if len(spec.subcommands) > 0 {
+		subcommands := []resp.RespValue{}
+		for _, subname := range sortedCommandNames(spec.subcommands) {
+			fullname := name + "|" + strings.ToLower(subname)
+			subcommands = append(subcommands, generateBulkResponse(fullname), commandDocsReply(h, fullname, spec.subcommands[subname]))
+		}
+		fields = append(fields, generateBulkResponse("subcommands"), generateMapResponse(h, subcommands))
+	}
+
+	return generateMapResponse(h, fields)
+}
+
+// commandGetkeys returns the keys of a command without running it. Shard
+// channels aren't keys, so they aren't returned.
+func commandGetkeys(args []resp.RespValue) handlerResponse {
+	command := strings.ToUpper(args[0].Bulk)
+	if _, ok := commandTable[command]; !ok {
+		return handlerResponse{
+			err: fmt.Errorf("Invalid command specified"),
+		}
+	}
+
+	spec, _ := lookupCommandSpec(command, args[1:])
+	if !spec.checkArity(len(args)) {
+		return handlerResponse{
+			err: fmt.Errorf("Invalid number of arguments specified for command"),
+		}
+	}
+	if spec.firstKey == 0 || spec.notKey {
+		return handlerResponse{
+			err: fmt.Errorf("The command has no key arguments"),
+		}
+	}
+
+	keys := []resp.RespValue{}
+	for _, k := range spec.keys(args[1:]) {
+		keys = append(keys, generateBulkResponse(k))
+	}
+	if len(keys) == 0 {
+		return handlerResponse{
+			err: fmt.Errorf("Invalid arguments specified for command"),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(keys),
+	}
+}
+
+// commandList lists the names of the commands and their subcommands,
+// optionally filtered by FILTERBY MODULE, ACLCAT or PATTERN. There are no
+// modules, so filtering by one lists nothing.
+func commandList(args []resp.RespValue) handlerResponse {
+	if len(args) != 0 && (len(args) != 3 || strings.ToUpper(args[0].Bulk) != "FILTERBY") {
+		return handlerResponse{
+			err: fmt.Errorf("syntax error"),
+		}
+	}
+
+	names := []string{}
+	for _, name := range sortedCommandNames(commandTable) {
+		names = append(names, strings.ToLower(name))
+		for _, subname := range sortedCommandNames(commandTable[name].subcommands) {
+			names = append(names, strings.ToLower(name+"|"+subname))
+		}
+	}
+
+	if len(args) == 3 {
+		filter := args[2].Bulk
+		switch strings.ToUpper(args[1].Bulk) {
+		case "MODULE":
+			names = nil
+		case "ACLCAT":
+			names = commandsInCategory(strings.ToLower(filter))
+		case "PATTERN":
+			names = slices.DeleteFunc(names, func(name string) bool {
+				return !glob.Match(filter, name)
+			})
+		default:
+			return handlerResponse{
+				err: fmt.Errorf("syntax error"),
+			}
+		}
+	}
+
+	list := []resp.RespValue{}
+	for _, name := range names {
+		list = append(list, generateBulkResponse(name))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(list),
+	}
+}
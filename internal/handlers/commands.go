@@ -0,0 +1,662 @@
+package handlers
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Command flags, as reported by COMMAND INFO
+const (
+	FLAG_WRITE      = "write"
+	FLAG_READONLY   = "readonly"
+	FLAG_DENYOOM    = "denyoom"
+	FLAG_ADMIN      = "admin"
+	FLAG_PUBSUB     = "pubsub"
+	FLAG_NOSCRIPT   = "noscript"
+	FLAG_BLOCKING   = "blocking"
+	FLAG_LOADING    = "loading"
+	FLAG_STALE      = "stale"
+	FLAG_FAST       = "fast"
+	FLAG_NO_AUTH    = "no_auth"
+	FLAG_ALLOW_BUSY = "allow_busy"
+	FLAG_NO_MULTI   = "no_multi"
+)
+
+// Command groups used by COMMAND DOCS
+const (
+	GROUP_GENERIC      = "generic"
+	GROUP_STRING       = "string"
+	GROUP_LIST         = "list"
+	GROUP_SET          = "set"
+	GROUP_PUBSUB       = "pubsub"
+	GROUP_CONNECTION   = "connection"
+	GROUP_SERVER       = "server"
+	GROUP_TRANSACTIONS = "transactions"
+)
+
+// commandSpec declares a command: its handler, its arity, the flags and docs
+// reported by COMMAND, its ACL categories, and where its keys and channels are
+// found.
+//
+// Arity counts the command name and is negative when it is a minimum, as in
+// Redis. Positions count the command name as 0 and a last position of -1
+// means the final argument. Subcommands are run by their container's handler,
+// so their specs have no handler of their own.
+type commandSpec struct {
+	handler    Handler
+	arity      int
+	flags      []string
+	categories []string
+	firstKey   int
+	lastKey    int
+	keyStep    int
+	// notKey marks key positions that hold shard channels. COMMAND reports
+	// them so that clients route them like keys, but ACL key patterns don't
+	// apply to them.
+	notKey       bool
+	firstChannel int
+	lastChannel  int
+	summary      string
+	since        string
+	group        string
+	subcommands  map[string]commandSpec
+}
+
+// commandTable holds every command by its upper case name. It is filled in
+// init because COMMAND and ACL DRYRUN read it from their handlers.
+var commandTable map[string]commandSpec
+
+func init() {
+	commandTable = map[string]commandSpec{
+		"AUTH": {
+			handler: auth, arity: -2,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_FAST, FLAG_NO_AUTH, FLAG_ALLOW_BUSY},
+			categories: []string{"fast", "connection"},
+			summary:    "Authenticates the connection.", since: "1.0.0", group: GROUP_CONNECTION,
+		},
+		"EXISTS": {
+			handler: exists, arity: -2,
+			flags:      []string{FLAG_READONLY, FLAG_FAST},
+			categories: []string{"keyspace", "read", "fast"},
+			firstKey:   1, lastKey: -1, keyStep: 1,
+			summary: "Determines whether one or more keys exist.", since: "1.0.0", group: GROUP_GENERIC,
+		},
+		"SET": {
+			handler: set, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM},
+			categories: []string{"write", "string", "slow"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.", since: "1.0.0", group: GROUP_STRING,
+		},
+		"GET": {
+			handler: get, arity: 2,
+			flags:      []string{FLAG_READONLY, FLAG_FAST},
+			categories: []string{"read", "string", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns the string value of a key.", since: "1.0.0", group: GROUP_STRING,
+		},
+		"DEL": {
+			handler: del, arity: -2,
+			flags:      []string{FLAG_WRITE},
+			categories: []string{"keyspace", "write", "slow"},
+			firstKey:   1, lastKey: -1, keyStep: 1,
+			summary: "Deletes one or more keys.", since: "1.0.0", group: GROUP_GENERIC,
+		},
+		"COPY": {
+			handler: copy, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM},
+			categories: []string{"keyspace", "write", "slow"},
+			firstKey:   1, lastKey: 2, keyStep: 1,
+			summary: "Copies the value of a key to a new key.", since: "6.2.0", group: GROUP_GENERIC,
+		},
+		"LPUSH": {
+			handler: lpush, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM, FLAG_FAST},
+			categories: []string{"write", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Prepends one or more elements to a list. Creates the key if it doesn't exist.", since: "1.0.0", group: GROUP_LIST,
+		},
+		"LPUSHX": {
+			handler: lpush, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM, FLAG_FAST},
+			categories: []string{"write", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Prepends one or more elements to a list only when the list exists.", since: "2.2.0", group: GROUP_LIST,
+		},
+		"LPOP": {
+			handler: lpop, arity: -2,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"write", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns the first elements in a list after removing it. Deletes the list if the last element was popped.", since: "1.0.0", group: GROUP_LIST,
+		},
+		"RPUSH": {
+			handler: rpush, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM, FLAG_FAST},
+			categories: []string{"write", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Appends one or more elements to a list. Creates the key if it doesn't exist.", since: "1.0.0", group: GROUP_LIST,
+		},
+		"RPUSHX": {
+			handler: rpush, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM, FLAG_FAST},
+			categories: []string{"write", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Appends an element to a list only when the list exists.", since: "2.2.0", group: GROUP_LIST,
+		},
+		"RPOP": {
+			handler: rpop, arity: -2,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"write", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns and removes the last elements of the list. Deletes the list if the last element was popped.", since: "1.0.0", group: GROUP_LIST,
+		},
+		"LLEN": {
+			handler: llen, arity: 2,
+			flags:      []string{FLAG_READONLY, FLAG_FAST},
+			categories: []string{"read", "list", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns the length of a list.", since: "1.0.0", group: GROUP_LIST,
+		},
+		"LINDEX": {
+			handler: lindex, arity: 3,
+			flags:      []string{FLAG_READONLY},
+			categories: []string{"read", "list", "slow"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns an element from a list by its index.", since: "1.0.0", group: GROUP_LIST,
+		},
+		"SADD": {
+			handler: sadd, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_DENYOOM, FLAG_FAST},
+			categories: []string{"write", "set", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Adds one or more members to a set. Creates the key if it doesn't exist.", since: "1.0.0", group: GROUP_SET,
+		},
+		"SMEMBERS": {
+			handler: smembers, arity: 2,
+			flags:      []string{FLAG_READONLY},
+			categories: []string{"read", "set", "slow"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns all members of a set.", since: "1.0.0", group: GROUP_SET,
+		},
+		"SISMEMBER": {
+			handler: sismember, arity: 3,
+			flags:      []string{FLAG_READONLY, FLAG_FAST},
+			categories: []string{"read", "set", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Determines whether a member belongs to a set.", since: "1.0.0", group: GROUP_SET,
+		},
+		"PERSIST": {
+			handler: persist, arity: 2,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"keyspace", "write", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Removes the expiration time of a key.", since: "2.2.0", group: GROUP_GENERIC,
+		},
+		"EXPIRE": {
+			handler: setExpiry, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"keyspace", "write", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Sets the expiration time of a key in seconds.", since: "1.0.0", group: GROUP_GENERIC,
+		},
+		"EXPIREAT": {
+			handler: setExpiry, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"keyspace", "write", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Sets the expiration time of a key to a Unix timestamp.", since: "1.2.0", group: GROUP_GENERIC,
+		},
+		"PEXPIRE": {
+			handler: setExpiry, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"keyspace", "write", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Sets the expiration time of a key in milliseconds.", since: "2.6.0", group: GROUP_GENERIC,
+		},
+		"PEXPIREAT": {
+			handler: setExpiry, arity: -3,
+			flags:      []string{FLAG_WRITE, FLAG_FAST},
+			categories: []string{"keyspace", "write", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Sets the expiration time of a key to a Unix milliseconds timestamp.", since: "2.6.0", group: GROUP_GENERIC,
+		},
+		"EXPIRETIME": {
+			handler: expiretime, arity: 2,
+			flags:      []string{FLAG_READONLY, FLAG_FAST},
+			categories: []string{"keyspace", "read", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1,
+			summary: "Returns the expiration time of a key as a Unix timestamp.", since: "7.0.0", group: GROUP_GENERIC,
+		},
+		"SUBSCRIBE": {
+			handler: subscribe, arity: -2,
+			flags:        []string{FLAG_PUBSUB, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_NO_MULTI},
+			categories:   []string{"pubsub", "slow"},
+			firstChannel: 1, lastChannel: -1,
+			summary: "Listens for messages published to channels.", since: "2.0.0", group: GROUP_PUBSUB,
+		},
+		"PUBLISH": {
+			handler: publish, arity: 3,
+			flags:        []string{FLAG_PUBSUB, FLAG_LOADING, FLAG_STALE, FLAG_FAST},
+			categories:   []string{"pubsub", "fast"},
+			firstChannel: 1, lastChannel: 1,
+			summary: "Posts a message to a channel.", since: "2.0.0", group: GROUP_PUBSUB,
+		},
+		"UNSUBSCRIBE": {
+			handler: unsubscribe, arity: -1,
+			flags:      []string{FLAG_PUBSUB, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_NO_MULTI},
+			categories: []string{"pubsub", "slow"},
+			summary:    "Stops listening to messages posted to channels.", since: "2.0.0", group: GROUP_PUBSUB,
+		},
+		"PING": {
+			handler: ping, arity: -1,
+			flags:      []string{FLAG_FAST},
+			categories: []string{"fast", "connection"},
+			summary:    "Returns the server's liveliness response.", since: "1.0.0", group: GROUP_CONNECTION,
+		},
+		"RESET": {
+			handler: reset, arity: 1,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_FAST, FLAG_NO_AUTH, FLAG_ALLOW_BUSY},
+			categories: []string{"fast", "connection"},
+			summary:    "Resets the connection.", since: "6.2.0", group: GROUP_CONNECTION,
+		},
+		"QUIT": {
+			handler: quit, arity: -1,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_FAST, FLAG_NO_AUTH, FLAG_ALLOW_BUSY},
+			categories: []string{"fast", "connection"},
+			summary:    "Closes the connection.", since: "1.0.0", group: GROUP_CONNECTION,
+		},
+		"SSUBSCRIBE": {
+			handler: ssubscribe, arity: -2,
+			flags:      []string{FLAG_PUBSUB, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_NO_MULTI},
+			categories: []string{"pubsub", "slow"},
+			firstKey:   1, lastKey: -1, keyStep: 1, notKey: true,
+			firstChannel: 1, lastChannel: -1,
+			summary: "Listens for messages published to shard channels.", since: "7.0.0", group: GROUP_PUBSUB,
+		},
+		"SUNSUBSCRIBE": {
+			handler: sunsubscribe, arity: -1,
+			flags:      []string{FLAG_PUBSUB, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_NO_MULTI},
+			categories: []string{"pubsub", "slow"},
+			firstKey:   1, lastKey: -1, keyStep: 1, notKey: true,
+			summary: "Stops listening to messages posted to shard channels.", since: "7.0.0", group: GROUP_PUBSUB,
+		},
+		"SPUBLISH": {
+			handler: spublish, arity: 3,
+			flags:      []string{FLAG_PUBSUB, FLAG_LOADING, FLAG_STALE, FLAG_FAST},
+			categories: []string{"pubsub", "fast"},
+			firstKey:   1, lastKey: 1, keyStep: 1, notKey: true,
+			firstChannel: 1, lastChannel: 1,
+			summary: "Post a message to a shard channel", since: "7.0.0", group: GROUP_PUBSUB,
+		},
+		"PUBSUB": {
+			handler: pubsub, arity: -2,
+			categories: []string{"pubsub", "slow"},
+			summary:    "A container for Pub/Sub commands.", since: "2.8.0", group: GROUP_PUBSUB,
+			subcommands: map[string]commandSpec{
+				"CHANNELS": {
+					arity: -2, flags: []string{FLAG_PUBSUB, FLAG_LOADING, FLAG_STALE}, categories: []string{"pubsub", "slow"},
+					summary: "Returns the active channels.", since: "2.8.0", group: GROUP_PUBSUB,
+				},
+				"NUMSUB": {
+					arity: -2, flags: []string{FLAG_PUBSUB, FLAG_LOADING, FLAG_STALE}, categories: []string{"pubsub", "slow"},
+					summary: "Returns a count of subscribers to channels.", since: "2.8.0", group: GROUP_PUBSUB,
+				},
+				"SHARDCHANNELS": {
+					arity: -2, flags: []string{FLAG_PUBSUB, FLAG_LOADING, FLAG_STALE}, categories: []string{"pubsub", "slow"},
+					summary: "Returns the active shard channels.", since: "7.0.0", group: GROUP_PUBSUB,
+				},
+				"SHARDNUMSUB": {
+					arity: -2, flags: []string{FLAG_PUBSUB, FLAG_LOADING, FLAG_STALE}, categories: []string{"pubsub", "slow"},
+					summary: "Returns the count of subscribers of shard channels.", since: "7.0.0", group: GROUP_PUBSUB,
+				},
+			},
+		},
+		"HELLO": {
+			handler: hello, arity: -1,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_FAST, FLAG_NO_AUTH, FLAG_ALLOW_BUSY},
+			categories: []string{"fast", "connection"},
+			summary:    "Handshakes with the Redis server.", since: "6.0.0", group: GROUP_CONNECTION,
+		},
+		"CLIENT": {
+			handler: client, arity: -2,
+			categories: []string{"slow", "connection"},
+			summary:    "A container for client connection commands.", since: "2.4.0", group: GROUP_CONNECTION,
+			subcommands: map[string]commandSpec{
+				"ID": {
+					arity: 2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns the unique client ID of the connection.", since: "5.0.0", group: GROUP_CONNECTION,
+				},
+				"INFO": {
+					arity: 2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns information about the connection.", since: "6.2.0", group: GROUP_CONNECTION,
+				},
+				"LIST": {
+					arity: -2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous", "connection"},
+					summary: "Lists open connections.", since: "2.4.0", group: GROUP_CONNECTION,
+				},
+				"SETNAME": {
+					arity: 3, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Sets the connection name.", since: "2.6.9", group: GROUP_CONNECTION,
+				},
+				"GETNAME": {
+					arity: 2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns the name of the connection.", since: "2.6.9", group: GROUP_CONNECTION,
+				},
+				"KILL": {
+					arity: -3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous", "connection"},
+					summary: "Terminates open connections.", since: "2.4.0", group: GROUP_CONNECTION,
+				},
+				"PAUSE": {
+					arity: -3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous", "connection"},
+					summary: "Suspends commands processing.", since: "3.0.0", group: GROUP_CONNECTION,
+				},
+				"UNPAUSE": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous", "connection"},
+					summary: "Resumes processing commands from paused clients.", since: "6.2.0", group: GROUP_CONNECTION,
+				},
+				"NO-EVICT": {
+					arity: 3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous", "connection"},
+					summary: "Sets the client eviction mode of the connection.", since: "7.0.0", group: GROUP_CONNECTION,
+				},
+				"REPLY": {
+					arity: 3, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_NO_MULTI}, categories: []string{"slow", "connection"},
+					summary: "Instructs the server whether to reply to commands.", since: "3.2.0", group: GROUP_CONNECTION,
+				},
+				"TRACKING": {
+					arity: -3, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Controls server-assisted client-side caching for the connection.", since: "6.0.0", group: GROUP_CONNECTION,
+				},
+				"CACHING": {
+					arity: 3, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Instructs the server whether to track the keys in the next request.", since: "6.0.0", group: GROUP_CONNECTION,
+				},
+				"GETREDIR": {
+					arity: 2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns the client ID to which the connection's tracking notifications are redirected.", since: "6.0.0", group: GROUP_CONNECTION,
+				},
+				"TRACKINGINFO": {
+					arity: 2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns information about server-assisted client-side caching for the connection.", since: "6.2.0", group: GROUP_CONNECTION,
+				},
+			},
+		},
+		"CONFIG": {
+			handler: config, arity: -2,
+			categories: []string{"admin", "slow", "dangerous"},
+			summary:    "A container for server configuration commands.", since: "2.0.0", group: GROUP_SERVER,
+			subcommands: map[string]commandSpec{
+				"GET": {
+					arity: -3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns the effective values of configuration parameters.", since: "2.0.0", group: GROUP_SERVER,
+				},
+				"SET": {
+					arity: -4, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Sets configuration parameters in-flight.", since: "2.0.0", group: GROUP_SERVER,
+				},
+				"RESETSTAT": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Resets the server's statistics.", since: "2.0.0", group: GROUP_SERVER,
+				},
+				"REWRITE": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Persists the effective configuration to file.", since: "2.8.0", group: GROUP_SERVER,
+				},
+			},
+		},
+		"ACL": {
+			handler: aclCommand, arity: -2,
+			categories: []string{"admin", "slow", "dangerous"},
+			summary:    "A container for Access List Control commands.", since: "6.0.0", group: GROUP_SERVER,
+			subcommands: map[string]commandSpec{
+				"SETUSER": {
+					arity: -3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Creates and modifies an ACL user and its rules.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"GETUSER": {
+					arity: 3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Lists the ACL rules of a user.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"DELUSER": {
+					arity: -3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Deletes ACL users, and terminates their connections.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"LIST": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Dumps the effective rules in ACL file format.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"USERS": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Lists all ACL users.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"WHOAMI": {
+					arity: 2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow"},
+					summary: "Returns the authenticated username of the current connection.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"CAT": {
+					arity: -2, flags: []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"slow"},
+					summary: "Lists the ACL categories, or the commands inside a category.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"DRYRUN": {
+					arity: -4, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Simulates the execution of a command by a user, without executing the command.", since: "7.0.0", group: GROUP_SERVER,
+				},
+				"LOG": {
+					arity: -2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Lists recent security events generated due to ACL rules.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"LOAD": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Reloads the rules from the configured ACL file.", since: "6.0.0", group: GROUP_SERVER,
+				},
+				"SAVE": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Saves the effective ACL rules in the configured ACL file.", since: "6.0.0", group: GROUP_SERVER,
+				},
+			},
+		},
+		"SHUTDOWN": {
+			handler: shutdown, arity: -1,
+			flags:      []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_ALLOW_BUSY, FLAG_NO_MULTI},
+			categories: []string{"admin", "slow", "dangerous"},
+			summary:    "Synchronously saves the database(s) to disk and shuts down the Redis server.", since: "1.0.0", group: GROUP_SERVER,
+		},
+		"INFO": {
+			handler: info, arity: -1,
+			flags:      []string{FLAG_LOADING, FLAG_STALE},
+			categories: []string{"slow", "dangerous"},
+			summary:    "Returns information and statistics about the server.", since: "1.0.0", group: GROUP_SERVER,
+		},
+		"SLOWLOG": {
+			handler: slowlogCommand, arity: -2,
+			categories: []string{"admin", "slow", "dangerous"},
+			summary:    "A container for slow log commands.", since: "2.2.12", group: GROUP_SERVER,
+			subcommands: map[string]commandSpec{
+				"GET": {
+					arity: -2, flags: []string{FLAG_ADMIN, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns the slow log's entries.", since: "2.2.12", group: GROUP_SERVER,
+				},
+				"LEN": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns the number of entries in the slow log.", since: "2.2.12", group: GROUP_SERVER,
+				},
+				"RESET": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Clears all entries from the slow log.", since: "2.2.12", group: GROUP_SERVER,
+				},
+			},
+		},
+		"LATENCY": {
+			handler: latencyCommand, arity: -2,
+			categories: []string{"admin", "slow", "dangerous"},
+			summary:    "A container for latency diagnostics commands.", since: "2.8.13", group: GROUP_SERVER,
+			subcommands: map[string]commandSpec{
+				"LATEST": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns the latest latency samples for all events.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"HISTORY": {
+					arity: 3, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns timestamp-latency samples for an event.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"RESET": {
+					arity: -2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Resets the latency data for one or more events.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"DOCTOR": {
+					arity: 2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns a human-readable latency analysis report.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"HISTOGRAM": {
+					arity: -2, flags: []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE}, categories: []string{"admin", "slow", "dangerous"},
+					summary: "Returns the cumulative distribution of latencies of a subset or all commands.", since: "7.0.0", group: GROUP_SERVER,
+				},
+			},
+		},
+		"MONITOR": {
+			handler: monitor, arity: 1,
+			flags:      []string{FLAG_ADMIN, FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_NO_MULTI},
+			categories: []string{"admin", "slow", "dangerous"},
+			summary:    "Listens for all requests received by the server in real-time.", since: "1.0.0", group: GROUP_SERVER,
+		},
+		"MULTI": {
+			handler: multi, arity: 1,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_FAST, FLAG_ALLOW_BUSY},
+			categories: []string{"fast", "transaction"},
+			summary:    "Starts a transaction.", since: "1.2.0", group: GROUP_TRANSACTIONS,
+		},
+		"EXEC": {
+			handler: exec, arity: 1,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE},
+			categories: []string{"slow", "transaction"},
+			summary:    "Executes all commands in a transaction.", since: "1.2.0", group: GROUP_TRANSACTIONS,
+		},
+		"DISCARD": {
+			handler: discard, arity: 1,
+			flags:      []string{FLAG_NOSCRIPT, FLAG_LOADING, FLAG_STALE, FLAG_FAST, FLAG_ALLOW_BUSY},
+			categories: []string{"fast", "transaction"},
+			summary:    "Discards a transaction.", since: "2.0.0", group: GROUP_TRANSACTIONS,
+		},
+		"COMMAND": {
+			handler: commandCommand, arity: -1,
+			flags:      []string{FLAG_LOADING, FLAG_STALE},
+			categories: []string{"slow", "connection"},
+			summary:    "Returns detailed information about all commands.", since: "2.8.13", group: GROUP_SERVER,
+			subcommands: map[string]commandSpec{
+				"COUNT": {
+					arity: 2, flags: []string{FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns a count of commands.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"INFO": {
+					arity: -2, flags: []string{FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns information about one, multiple or all commands.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"DOCS": {
+					arity: -2, flags: []string{FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns documentary information about one, multiple or all commands.", since: "7.0.0", group: GROUP_SERVER,
+				},
+				"GETKEYS": {
+					arity: -3, flags: []string{FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Extracts the key names from an arbitrary command.", since: "2.8.13", group: GROUP_SERVER,
+				},
+				"LIST": {
+					arity: -2, flags: []string{FLAG_LOADING, FLAG_STALE}, categories: []string{"slow", "connection"},
+					summary: "Returns a list of command names.", since: "7.0.0", group: GROUP_SERVER,
+				},
+			},
+		},
+	}
+
+	for name := range commandTable {
+		acl.RegisterCommand(name)
+	}
+}
+
+func (spec commandSpec) hasFlag(flag string) bool {
+	return slices.Contains(spec.flags, flag)
+}
+
+// checkArity reports whether argc, which includes the command name, is
+// allowed by the spec's arity
+func (spec commandSpec) checkArity(argc int) bool {
+	if spec.arity < 0 {
+		return argc >= -spec.arity
+	}
+	return argc == spec.arity
+}
+
+// lookupCommandSpec returns the spec of a subcommand if it has one, otherwise
+// that of the command
+func lookupCommandSpec(command string, args []resp.RespValue) (commandSpec, string) {
+	subcommand := ""
+	spec := commandTable[command]
+	if len(args) > 0 {
+		subcommand = strings.ToUpper(args[0].Bulk)
+		if sub, ok := spec.subcommands[subcommand]; ok {
+			return sub, subcommand
+		}
+	}
+
+	return spec, subcommand
+}
+
+// commandName is the lower case name shown in the ACL log and CLIENT LIST,
+// including the subcommand if it is a known one, e.g. client|list
+func commandName(command string, args []resp.RespValue) string {
+	_, subcommand := lookupCommandSpec(command, args)
+	if _, ok := commandTable[command].subcommands[subcommand]; ok {
+		return strings.ToLower(command + "|" + subcommand)
+	}
+
+	return strings.ToLower(command)
+}
+
+func argRange(args []resp.RespValue, first int, last int, step int) []string {
+	if first == 0 {
+		return nil
+	}
+	if last < 0 {
+		last = len(args) + last + 1
+	}
+
+	values := []string{}
+	for i := first; i <= last && i <= len(args); i += step {
+		values = append(values, args[i-1].Bulk)
+	}
+
+	return values
+}
+
+// keys returns the keys checked against ACL key patterns
+func (spec commandSpec) keys(args []resp.RespValue) []string {
+	if spec.notKey {
+		return nil
+	}
+	return argRange(args, spec.firstKey, spec.lastKey, spec.keyStep)
+}
+
+func (spec commandSpec) channels(args []resp.RespValue) []string {
+	return argRange(args, spec.firstChannel, spec.lastChannel, 1)
+}
+
+// commandsInCategory lists the lower case names of the commands and
+// subcommands in an ACL category
+func commandsInCategory(category string) []string {
+	names := []string{}
+	for name, spec := range commandTable {
+		if slices.Contains(spec.categories, category) {
+			names = append(names, strings.ToLower(name))
+		}
+		for subname, sub := range spec.subcommands {
+			if slices.Contains(sub.categories, category) {
+				names = append(names, strings.ToLower(name+"|"+subname))
+			}
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
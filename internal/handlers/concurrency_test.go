@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Many clients pushing to the same list and adding to the same set at once
+// must not lose any of each other's writes. The storage tests check the same
+// against every backend.
+func TestConcurrentWritesToOneKey(t *testing.T) {
+	const clients = 32
+	const writes = 50
+
+	s := newTestServer(t)
+	wg := sync.WaitGroup{}
+	errs := make(chan string, clients*writes*2)
+	for i := 0; i < clients; i++ {
+		c := s.client()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				member := fmt.Sprintf("%d-%d", i, j)
+				if reply := c.call("LPUSH", "list", member); reply.Type != resp.TYPE_INTEGER {
+					errs <- fmt.Sprintf("LPUSH replied %q", reply.Marshall())
+				}
+				if reply := c.call("SADD", "set", member); reply.Type != resp.TYPE_INTEGER {
+					errs <- fmt.Sprintf("SADD replied %q", reply.Marshall())
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	c := s.client()
+	if got, want := c.do("LLEN", "list"), fmt.Sprintf(":%d\r\n", clients*writes); got != want {
+		t.Errorf("LLEN replied %q, want %q", got, want)
+	}
+	if got := len(c.call("SMEMBERS", "set").Array); got != clients*writes {
+		t.Errorf("SMEMBERS returned %d members, want %d", got, clients*writes)
+	}
+}
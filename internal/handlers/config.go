@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/glob"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
+)
+
+func config(h handlerArgs) handlerResponse {
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "GET":
+		return configGet(h, args)
+	case "SET":
+		return configSet(h, args)
+	case "RESETSTAT":
+		return configResetstat(h)
+	case "REWRITE":
+		return configRewrite(h)
+	default:
+		return handlerResponse{
+			err: unknownSubcommandError("config", h.args[0].Bulk),
+		}
+	}
+}
+
+// configGet returns every parameter matching at least one of the glob
+// patterns
+func configGet(h handlerArgs, args []resp.RespValue) handlerResponse {
+	pairs := []resp.RespValue{}
+	for _, p := range h.config.All() {
+		for _, pattern := range args {
+			if glob.Match(strings.ToLower(pattern.Bulk), p.Name) {
+				pairs = append(pairs, generateBulkResponse(p.Name), generateBulkResponse(p.Value))
+				break
+			}
+		}
+	}
+
+	return handlerResponse{
+		resp: generateMapResponse(h, pairs),
+	}
+}
+
+func configSet(h handlerArgs, args []resp.RespValue) handlerResponse {
+	if len(args)%2 != 0 {
+		return handlerResponse{
+			err: fmt.Errorf("wrong number of arguments for 'config|set' command"),
+		}
+	}
+
+	changes := []configuration.Param{}
+	for i := 0; i < len(args); i += 2 {
+		changes = append(changes, configuration.Param{Name: args[i].Bulk, Value: args[i+1].Bulk})
+	}
+
+	if err := h.config.Set(changes); err != nil {
+		return handlerResponse{err: err}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func configResetstat(h handlerArgs) handlerResponse {
+	stats.Reset()
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func configRewrite(h handlerArgs) handlerResponse {
+	if err := h.config.Rewrite(); err != nil {
+		return handlerResponse{
+			err: fmt.Errorf("Rewriting config file: %s", err),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+func ping(h handlerArgs) handlerResponse {
+	if len(h.args) > 1 {
+		return handlerResponse{
+			err: fmt.Errorf("wrong number of arguments for 'ping' command"),
+		}
+	}
+
+	if h.conn.IsSubscribed() && h.conn.Protocol() == 2 {
+		message := ""
+		if len(h.args) == 1 {
+			message = h.args[0].Bulk
+		}
+		return handlerResponse{
+			resp: generateArrayResponse([]resp.RespValue{
+				generateBulkResponse("pong"),
+				generateBulkResponse(message),
+			}),
+		}
+	}
+
+	if len(h.args) == 1 {
+		return handlerResponse{
+			resp: generateBulkResponse(h.args[0].Bulk),
+		}
+	}
+
+	return handlerResponse{
+		resp: generateStringResponse("PONG"),
+	}
+}
+
+func reset(h handlerArgs) handlerResponse {
+	RemoveConnection(h.conn)
+	h.conn.EndMulti()
+	h.conn.SetUser(acl.DEFAULT_USER)
+	h.conn.SetValidated(acl.AuthenticatedByDefault())
+	h.conn.SetProtocol(2)
+
+	return handlerResponse{
+		resp: generateStringResponse("RESET"),
+	}
+}
+
+func quit(h handlerArgs) handlerResponse {
+	h.conn.SetCloseAfterReply()
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+const SERVER_VERSION = "7.2.4"
+
+func hello(h handlerArgs) handlerResponse {
+	protocol := h.conn.Protocol()
+	args := h.args
+
+	if len(args) > 0 {
+		p, err := strconv.Atoi(args[0].Bulk)
+		if err != nil {
+			return handlerResponse{
+				err: fmt.Errorf("Protocol version is not an integer or out of range"),
+			}
+		}
+		if p < 2 || p > 3 {
+			return handlerResponse{
+				err: ErrNoProto,
+			}
+		}
+		protocol = p
+		args = args[1:]
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].Bulk) {
+		case "AUTH":
+			if i+2 >= len(args) {
+				return handlerResponse{err: fmt.Errorf("Syntax error in HELLO option 'auth'")}
+			}
+			if err := authenticate(h.conn, args[i+1].Bulk, args[i+2].Bulk); err != nil {
+				return handlerResponse{
+					err: newRedisError(CODE_WRONGPASS, "%s", err),
+				}
+			}
+			i += 2
+		default:
+			return handlerResponse{
+				err: fmt.Errorf("Syntax error in HELLO option '%s'", args[i].Bulk),
+			}
+		}
+	}
+
+	if !h.conn.Validated() {
+		return handlerResponse{
+			err: ErrHelloNoAuth,
+		}
+	}
+
+	h.conn.SetProtocol(protocol)
+
+	return handlerResponse{
+		resp: generateMapResponse(h, []resp.RespValue{
+			generateBulkResponse("server"), generateBulkResponse("redis"),
+			generateBulkResponse("version"), generateBulkResponse(SERVER_VERSION),
+			generateBulkResponse("proto"), generateIntegerResponse(protocol),
+			generateBulkResponse("id"), generateIntegerResponse(int(h.conn.ID)),
+			generateBulkResponse("mode"), generateBulkResponse("standalone"),
+			generateBulkResponse("role"), generateBulkResponse("master"),
+			generateBulkResponse("modules"), generateArrayResponse([]resp.RespValue{}),
+		}),
+	}
+}
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Error codes that replies start with. Client libraries branch on them, so
+// the codes and messages match Redis exactly.
+const (
+	CODE_ERR       = "ERR"
+	CODE_WRONGTYPE = "WRONGTYPE"
+	CODE_NOAUTH    = "NOAUTH"
+	CODE_NOPERM    = "NOPERM"
+	CODE_WRONGPASS = "WRONGPASS"
+	CODE_NOPROTO   = "NOPROTO"
+	CODE_EXECABORT = "EXECABORT"
+)
+
+// RedisError is an error replied with its own code instead of ERR
+type RedisError struct {
+	Code    string
+	Message string
+}
+
+func (e *RedisError) Error() string {
+	return e.Code + " " + e.Message
+}
+
+func newRedisError(code string, format string, a ...any) *RedisError {
+	return &RedisError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+var (
+	ErrWrongType   = newRedisError(CODE_WRONGTYPE, "Operation against a key holding the wrong kind of value")
+	ErrNoAuth      = newRedisError(CODE_NOAUTH, "Authentication required.")
+	ErrHelloNoAuth = newRedisError(CODE_NOAUTH, "HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	ErrNoProto     = newRedisError(CODE_NOPROTO, "sorry, this protocol version is not supported.")
+	ErrExecAbort   = newRedisError(CODE_EXECABORT, "Transaction discarded because of previous errors.")
+)
+
+// unknownCommandError lists the first arguments of an unknown command, up to
+// 128 characters, like Redis
+func unknownCommandError(argv []resp.RespValue) error {
+	args := strings.Builder{}
+	for _, a := range argv[1:] {
+		if args.Len() >= 128 {
+			break
+		}
+		arg := a.Bulk
+		if len(arg) > 128-args.Len() {
+			arg = arg[:128-args.Len()]
+		}
+		fmt.Fprintf(&args, "'%s' ", arg)
+	}
+
+	name := argv[0].Bulk
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	return fmt.Errorf("unknown command '%s', with args beginning with: %s", name, args.String())
+}
+
+func unknownSubcommandError(command string, subcommand string) error {
+	return fmt.Errorf("unknown subcommand '%s'. Try %s HELP.", subcommand, strings.ToUpper(command))
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+)
+
+const (
+	replyWrongType = "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	replySyntax    = "-ERR syntax error\r\n"
+	replyNotInt    = "-ERR value is not an integer or out of range\r\n"
+	replyExecAbort = "-EXECABORT Transaction discarded because of previous errors.\r\n"
+)
+
+// The error replied by every command for the ways it can be misused. Client
+// libraries branch on the codes and often the messages, so they match Redis.
+func TestErrorReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup [][]string
+		args  []string
+		want  string
+	}{
+		{"unknown command", nil, []string{"NOSUCH", "a", "b"}, "-ERR unknown command 'NOSUCH', with args beginning with: 'a' 'b' \r\n"},
+		{"unknown subcommand", nil, []string{"CLIENT", "NOSUCH"}, "-ERR unknown subcommand 'NOSUCH'. Try CLIENT HELP.\r\n"},
+
+		{"get arity", nil, []string{"GET"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"get list", [][]string{{"RPUSH", "k", "a"}}, []string{"GET", "k"}, replyWrongType},
+		{"set syntax", nil, []string{"SET", "k", "v", "NX", "XX"}, replySyntax},
+		{"set expire not integer", nil, []string{"SET", "k", "v", "EX", "soon"}, replyNotInt},
+		{"set expire zero", nil, []string{"SET", "k", "v", "EX", "0"}, "-ERR invalid expire time in 'set' command\r\n"},
+		{"set unknown option", nil, []string{"SET", "k", "v", "NOSUCH"}, replySyntax},
+		{"set two expiries", nil, []string{"SET", "k", "v", "EX", "10", "KEEPTTL"}, replySyntax},
+		{"set arity", nil, []string{"SET", "k"}, "-ERR wrong number of arguments for 'set' command\r\n"},
+		{"del arity", nil, []string{"DEL"}, "-ERR wrong number of arguments for 'del' command\r\n"},
+		{"exists arity", nil, []string{"EXISTS"}, "-ERR wrong number of arguments for 'exists' command\r\n"},
+		{"copy syntax", nil, []string{"COPY", "a", "b", "NOSUCH"}, replySyntax},
+
+		{"lpush string", [][]string{{"SET", "k", "v"}}, []string{"LPUSH", "k", "a"}, replyWrongType},
+		{"rpush string", [][]string{{"SET", "k", "v"}}, []string{"RPUSH", "k", "a"}, replyWrongType},
+		{"lpush arity", nil, []string{"LPUSH", "k"}, "-ERR wrong number of arguments for 'lpush' command\r\n"},
+		{"lpop string", [][]string{{"SET", "k", "v"}}, []string{"LPOP", "k"}, replyWrongType},
+		{"rpop string", [][]string{{"SET", "k", "v"}}, []string{"RPOP", "k"}, replyWrongType},
+		{"llen string", [][]string{{"SET", "k", "v"}}, []string{"LLEN", "k"}, replyWrongType},
+		{"lindex string", [][]string{{"SET", "k", "v"}}, []string{"LINDEX", "k", "0"}, replyWrongType},
+		{"lindex not integer", [][]string{{"RPUSH", "k", "a"}}, []string{"LINDEX", "k", "first"}, replyNotInt},
+
+		{"sadd string", [][]string{{"SET", "k", "v"}}, []string{"SADD", "k", "a"}, replyWrongType},
+		{"sadd arity", nil, []string{"SADD", "k"}, "-ERR wrong number of arguments for 'sadd' command\r\n"},
+		{"smembers string", [][]string{{"SET", "k", "v"}}, []string{"SMEMBERS", "k"}, replyWrongType},
+		{"sismember string", [][]string{{"SET", "k", "v"}}, []string{"SISMEMBER", "k", "a"}, replyWrongType},
+
+		{"expire not integer", [][]string{{"SET", "k", "v"}}, []string{"EXPIRE", "k", "soon"}, replyNotInt},
+		{"expire flags", [][]string{{"SET", "k", "v"}}, []string{"EXPIRE", "k", "10", "NX", "XX"}, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"},
+		{"expire gt and lt", [][]string{{"SET", "k", "v"}}, []string{"EXPIRE", "k", "10", "GT", "LT"}, "-ERR GT and LT options at the same time are not compatible\r\n"},
+		{"expire unknown option", [][]string{{"SET", "k", "v"}}, []string{"EXPIRE", "k", "10", "NOSUCH"}, "-ERR Unsupported option NOSUCH\r\n"},
+		{"persist arity", nil, []string{"PERSIST"}, "-ERR wrong number of arguments for 'persist' command\r\n"},
+
+		{"exec without multi", nil, []string{"EXEC"}, "-ERR EXEC without MULTI\r\n"},
+		{"discard without multi", nil, []string{"DISCARD"}, "-ERR DISCARD without MULTI\r\n"},
+		{"nested multi", [][]string{{"MULTI"}}, []string{"MULTI"}, "-ERR MULTI calls can not be nested\r\n"},
+		{"exec after queue error", [][]string{{"MULTI"}, {"GET"}}, []string{"EXEC"}, replyExecAbort},
+		{"exec after unknown command", [][]string{{"MULTI"}, {"SET", "k", "v"}, {"NOSUCH"}}, []string{"EXEC"}, replyExecAbort},
+		{"no multi command", [][]string{{"MULTI"}}, []string{"SUBSCRIBE", "c"}, "-ERR Command not allowed inside a transaction\r\n"},
+
+		{"hello protocol", nil, []string{"HELLO", "4"}, "-NOPROTO sorry, this protocol version is not supported.\r\n"},
+		{"subscriber mode", [][]string{{"SUBSCRIBE", "c"}}, []string{"GET", "k"}, "-ERR Can't execute 'get': only (S)SUBSCRIBE / (S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"},
+		{"config get arity", nil, []string{"CONFIG", "GET"}, "-ERR wrong number of arguments for 'config|get' command\r\n"},
+		{"config set unknown", nil, []string{"CONFIG", "SET", "nosuch", "1"}, "-ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'\r\n"},
+		{"config set not integer", nil, []string{"CONFIG", "SET", "maxclients", "lots"}, "-ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument couldn't be parsed into an integer\r\n"},
+		{"config set out of range", nil, []string{"CONFIG", "SET", "maxclients", "0"}, "-ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument must be between 1 and 2147483647 inclusive\r\n"},
+		{"config set enum", nil, []string{"CONFIG", "SET", "loglevel", "loud"}, "-ERR CONFIG SET failed (possibly related to argument 'loglevel') - argument(s) must be one of the following: debug, verbose, notice, warning, nothing\r\n"},
+		{"config set immutable", nil, []string{"CONFIG", "SET", "port", "7000"}, "-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n"},
+		{"config set duplicate", nil, []string{"CONFIG", "SET", "maxclients", "10", "maxclients", "11"}, "-ERR CONFIG SET failed (possibly related to argument 'maxclients') - duplicate parameter\r\n"},
+		{"config set arity", nil, []string{"CONFIG", "SET", "maxclients"}, "-ERR wrong number of arguments for 'config|set' command\r\n"},
+
+		{"acl setuser syntax", nil, []string{"ACL", "SETUSER", "bob", "nosuch"}, "-ERR Error in ACL SETUSER modifier 'nosuch': Syntax error\r\n"},
+		{"acl setuser unknown command", nil, []string{"ACL", "SETUSER", "bob", "+nosuch"}, "-ERR Error in ACL SETUSER modifier '+nosuch': Unknown command or category name in ACL\r\n"},
+		{"acl setuser unknown category", nil, []string{"ACL", "SETUSER", "bob", "+@nosuch"}, "-ERR Error in ACL SETUSER modifier '+@nosuch': Unknown command or category name in ACL\r\n"},
+		{"acl deluser default", nil, []string{"ACL", "DELUSER", "default"}, "-ERR The 'default' user cannot be removed\r\n"},
+		{"acl deluser arity", nil, []string{"ACL", "DELUSER"}, "-ERR wrong number of arguments for 'acl|deluser' command\r\n"},
+		{"acl unknown subcommand", nil, []string{"ACL", "NOSUCH"}, "-ERR unknown subcommand 'NOSUCH'. Try ACL HELP.\r\n"},
+
+		{"client kill address", nil, []string{"CLIENT", "KILL", "1.2.3.4:5"}, "-ERR No such client\r\n"},
+		{"client kill id", nil, []string{"CLIENT", "KILL", "ID", "x"}, "-ERR client-id should be greater than 0\r\n"},
+		{"client kill filter", nil, []string{"CLIENT", "KILL", "NOSUCH", "x"}, replySyntax},
+		{"client pause timeout", nil, []string{"CLIENT", "PAUSE", "soon"}, "-ERR timeout is not an integer or out of range\r\n"},
+		{"client pause negative", nil, []string{"CLIENT", "PAUSE", "-1"}, "-ERR timeout is not an integer or out of range\r\n"},
+		{"client pause mode", nil, []string{"CLIENT", "PAUSE", "10", "SOME"}, replySyntax},
+		{"client no-evict", nil, []string{"CLIENT", "NO-EVICT", "maybe"}, replySyntax},
+		{"client no-evict arity", nil, []string{"CLIENT", "NO-EVICT"}, "-ERR wrong number of arguments for 'client|no-evict' command\r\n"},
+
+		{"pubsub arity", nil, []string{"PUBSUB"}, "-ERR wrong number of arguments for 'pubsub' command\r\n"},
+		{"pubsub channels arity", nil, []string{"PUBSUB", "CHANNELS", "a", "b"}, "-ERR wrong number of arguments for 'pubsub|channels' command\r\n"},
+		{"pubsub unknown subcommand", nil, []string{"PUBSUB", "NOSUCH"}, "-ERR unknown subcommand 'NOSUCH'. Try PUBSUB HELP.\r\n"},
+
+		{"command getkeys arity", nil, []string{"COMMAND", "GETKEYS"}, "-ERR wrong number of arguments for 'command|getkeys' command\r\n"},
+		{"command getkeys unknown", nil, []string{"COMMAND", "GETKEYS", "nosuch", "a"}, "-ERR Invalid command specified\r\n"},
+		{"command getkeys command arity", nil, []string{"COMMAND", "GETKEYS", "get"}, "-ERR Invalid number of arguments specified for command\r\n"},
+		{"command getkeys no keys", nil, []string{"COMMAND", "GETKEYS", "ping"}, "-ERR The command has no key arguments\r\n"},
+		{"command info unknown", nil, []string{"COMMAND", "INFO", "nosuch"}, "*1\r\n$-1\r\n"},
+		{"command unknown subcommand", nil, []string{"COMMAND", "NOSUCH"}, "-ERR unknown subcommand 'NOSUCH'. Try COMMAND HELP.\r\n"},
+		{"auth without password", nil, []string{"AUTH", "secret"}, "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestServer(t).client()
+			for _, args := range test.setup {
+				c.do(args...)
+			}
+
+			if got := c.do(test.args...); got != test.want {
+				t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+			}
+		})
+	}
+}
+
+func TestAuthErrorReplies(t *testing.T) {
+	acl.SetDefaultPassword("secret")
+	t.Cleanup(func() { acl.SetDefaultPassword("") })
+
+	c := newTestServer(t).client()
+	if got, want := c.do("GET", "k"), "-NOAUTH Authentication required.\r\n"; got != want {
+		t.Errorf("GET before AUTH replied %q, want %q", got, want)
+	}
+	if got, want := c.do("AUTH", "wrong"), "-WRONGPASS invalid username-password pair or user is disabled.\r\n"; got != want {
+		t.Errorf("AUTH with the wrong password replied %q, want %q", got, want)
+	}
+	if got, want := c.do("AUTH", "secret"), "+OK\r\n"; got != want {
+		t.Errorf("AUTH replied %q, want %q", got, want)
+	}
+}
+
+// Commands, keys and channels a user's ACL rules deny are refused with
+// NOPERM, and inside MULTI the refusal discards the transaction
+func TestPermissionErrorReplies(t *testing.T) {
+	rules := []string{"on", ">pw", "~allowed:*", "&news", "+@read", "+@pubsub", "-smembers", "+set", "+multi", "+exec"}
+	if err := acl.SetUser("limited", rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { acl.DeleteUsers([]string{"limited"}) })
+
+	tests := []struct {
+		name  string
+		setup [][]string
+		args  []string
+		want  string
+	}{
+		{"allowed", nil, []string{"GET", "allowed:k"}, "$-1\r\n"},
+		{"denied key", nil, []string{"GET", "denied:k"}, "-NOPERM No permissions to access a key\r\n"},
+		{"denied write", nil, []string{"SET", "denied:k", "v"}, "-NOPERM No permissions to access a key\r\n"},
+		{"denied command", nil, []string{"DEL", "allowed:k"}, "-NOPERM User limited has no permissions to run the 'del' command\r\n"},
+		{"denied in category", nil, []string{"SMEMBERS", "allowed:k"}, "-NOPERM User limited has no permissions to run the 'smembers' command\r\n"},
+		{"denied subcommand", nil, []string{"CONFIG", "GET", "port"}, "-NOPERM User limited has no permissions to run the 'config|get' command\r\n"},
+		{"denied publish", nil, []string{"PUBLISH", "sport", "m"}, "-NOPERM No permissions to access a channel\r\n"},
+		{"denied subscribe", nil, []string{"SUBSCRIBE", "news", "sport"}, "-NOPERM No permissions to access a channel\r\n"},
+		{"denied in multi", [][]string{{"MULTI"}}, []string{"SET", "denied:k", "v"}, "-NOPERM No permissions to access a key\r\n"},
+		{"exec after denied", [][]string{{"MULTI"}, {"SET", "allowed:k", "v"}, {"DEL", "allowed:k"}}, []string{"EXEC"}, replyExecAbort},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestServer(t).client()
+			if got := c.do("AUTH", "limited", "pw"); got != "+OK\r\n" {
+				t.Fatalf("AUTH replied %q", got)
+			}
+			for _, args := range test.setup {
+				c.do(args...)
+			}
+
+			if got := c.do(test.args...); got != test.want {
+				t.Errorf("%q replied %q, want %q", test.args, got, test.want)
+			}
+		})
+	}
+}
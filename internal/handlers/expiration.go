@@ -3,83 +3,95 @@ package handlers
 import (
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
 	"github.com/mmacdo54/go-redis-clone/internal/storage"
 )
 
 func setExpiry(h handlerArgs) handlerResponse {
-	if len(h.args) < 2 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of arguments for '%s' command", strings.ToLower(h.command)),
-		}
-	}
-
 	key := h.args[0].Bulk
 	value := h.args[1].Bulk
 	expiry, err := strconv.Atoi(value)
 
 	if err != nil {
-		return handlerResponse{err: invalidOptionsError{}}
+		return handlerResponse{err: errNotInteger}
+	}
+
+	opts, err := parseExpireOptions(h.args[2:])
+	if err != nil {
+		return handlerResponse{err: err}
+	}
+
+	now := int(time.Now().Unix())
+	var exp int
+	switch h.command {
+	case "EXPIRE":
+		exp = (now + expiry) * 1000
+	case "EXPIREAT":
+		exp = expiry * 1000
+	case "PEXPIRE":
+		exp = now*1000 + expiry
+	case "PEXPIREAT":
+		exp = expiry
+	default:
+		return handlerResponse{
+			err: fmt.Errorf("command '%s' not handled", h.command),
+		}
 	}
 
-	var opts options
-	if len(h.args) > 2 {
-		o, err := parseSetOptions(h.args[2:])
-		if err != nil {
-			return handlerResponse{err: err}
+	tx, err := h.store.InitTransaction()
+	if err != nil {
+		return handlerResponse{
+			err: err,
 		}
-		opts = o
 	}
 
-	v, exists, err := h.store.GetByKey(storage.KV{Key: key})
+	v, exists, err := h.store.GetForUpdate(storage.KV{Key: key}, tx)
 
 	if err != nil {
 		return handlerResponse{err: err}
 	}
 
 	if !exists {
+		tx.Abort()
 		return handlerResponse{
 			resp: generateIntegerResponse(0),
 		}
 	}
 
 	if opts.nx && v.Exp != 0 {
+		tx.Abort()
 		return handlerResponse{
 			resp: generateIntegerResponse(0),
 		}
 	}
 
 	if opts.xx && v.Exp == 0 {
+		tx.Abort()
 		return handlerResponse{
 			resp: generateIntegerResponse(0),
 		}
 	}
 
-	now := int(time.Now().Unix())
-	switch h.command {
-	case "EXPIRE":
-		v.Exp = (now + expiry) * 1000
-	case "EXPIREAT":
-		v.Exp = expiry * 1000
-	case "PEXPIRE":
-		v.Exp = now*1000 + expiry
-	case "PEXPIREAT":
-		v.Exp = expiry
-	default:
+	// A key without an expiry has an infinite TTL, so GT never applies to
+	// it and LT always does
+	if opts.gt && (v.Exp == 0 || exp <= v.Exp) {
+		tx.Abort()
 		return handlerResponse{
-			err: fmt.Errorf("command '%s' not handled", h.command),
+			resp: generateIntegerResponse(0),
 		}
 	}
 
-	tx, err := h.store.InitTransaction()
-	if err != nil {
+	if opts.lt && v.Exp != 0 && exp >= v.Exp {
+		tx.Abort()
 		return handlerResponse{
-			err: err,
+			resp: generateIntegerResponse(0),
 		}
 	}
 
+	v.Exp = exp
+
 	err = h.store.SetKV(v, tx)
 
 	if err != nil {
@@ -94,20 +106,24 @@ func setExpiry(h handlerArgs) handlerResponse {
 		}
 	}
 
+	signalModifiedKey(h, key)
+	notifyKeyspaceEvent(h.config, configuration.NOTIFY_GENERIC, "expire", key)
+
 	return handlerResponse{
 		resp: generateIntegerResponse(1),
 	}
 }
 
 func persist(h handlerArgs) handlerResponse {
-	if len(h.args) != 1 {
+	key := h.args[0].Bulk
+	tx, err := h.store.InitTransaction()
+	if err != nil {
 		return handlerResponse{
-			err: fmt.Errorf("wrong number of args passed to 'persist' command"),
+			err: err,
 		}
 	}
 
-	key := h.args[0].Bulk
-	v, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	v, ok, err := h.store.GetForUpdate(storage.KV{Key: key}, tx)
 
 	if err != nil {
 		return handlerResponse{
@@ -116,18 +132,13 @@ func persist(h handlerArgs) handlerResponse {
 	}
 
 	if !ok || v.Exp == 0 {
+		tx.Abort()
 		return handlerResponse{
 			resp: generateIntegerResponse(0),
 		}
 	}
 
 	v.Exp = 0
-	tx, err := h.store.InitTransaction()
-	if err != nil {
-		return handlerResponse{
-			err: err,
-		}
-	}
 
 	if err = h.store.SetKV(v, tx); err != nil {
 		return handlerResponse{
@@ -141,20 +152,17 @@ func persist(h handlerArgs) handlerResponse {
 		}
 	}
 
+	signalModifiedKey(h, key)
+	notifyKeyspaceEvent(h.config, configuration.NOTIFY_GENERIC, "persist", key)
+
 	return handlerResponse{
 		resp: generateIntegerResponse(1),
 	}
 }
 
 func expiretime(h handlerArgs) handlerResponse {
-	if len(h.args) != 1 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of arguments for 'expiretime' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
-	v, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	v, ok, err := h.store.GetTypeByKey(storage.KV{Key: key})
 
 	if err != nil {
 		return handlerResponse{
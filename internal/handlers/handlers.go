@@ -1,12 +1,19 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
 	"github.com/mmacdo54/go-redis-clone/internal/configuration"
 	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/executor"
+	"github.com/mmacdo54/go-redis-clone/internal/latency"
 	"github.com/mmacdo54/go-redis-clone/internal/resp"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
 	"github.com/mmacdo54/go-redis-clone/internal/storage"
 )
 
@@ -17,12 +24,16 @@ const (
 	INTEGER = "integer"
 )
 
+// A command whose transaction keeps failing with transient errors is run
+// this many times before its error is replied
+const MAX_COMMAND_ATTEMPTS = 5
+
 type handlerArgs struct {
 	args    []resp.RespValue
 	conn    *connection.Connection
 	command string
 	store   storage.Store
-	config  configuration.Config
+	config  *configuration.Config
 }
 type handlerResponse struct {
 	err  error
@@ -30,34 +41,11 @@ type handlerResponse struct {
 }
 type Handler func(handlerArgs) handlerResponse
 
-var Handlers = map[string]Handler{
-	"AUTH":        auth,
-	"EXISTS":      exists,
-	"SET":         set,
-	"GET":         get,
-	"DEL":         del,
-	"COPY":        copy,
-	"LPUSH":       lpush,
-	"LPUSHX":      lpush,
-	"LPOP":        lpop,
-	"RPUSH":       rpush,
-	"RPUSHX":      rpush,
-	"RPOP":        rpop,
-	"LLEN":        llen,
-	"LINDEX":      lindex,
-	"SADD":        sadd,
-	"SMEMBERS":    smembers,
-	"SISMEMBER":   sismember,
-	"PERSIST":     persist,
-	"EXPIRE":      setExpiry,
-	"EXPIREAT":    setExpiry,
-	"PEXPIRE":     setExpiry,
-	"PEXPIREAT":   setExpiry,
-	"EXPIRETIME":  expiretime,
-	"SUBSCRIBE":   subscribe,
-	"PUBLISH":     publish,
-	"UNSUBSCRIBE": unsubscribe,
-}
+// Commands that can still be run once a connection has entered subscriber mode
+var subscriberModeCommands = []string{"SUBSCRIBE", "UNSUBSCRIBE", "SSUBSCRIBE", "SUNSUBSCRIBE", "PING", "RESET", "QUIT"}
+
+// Commands that are run straight away after MULTI instead of being queued
+var transactionCommands = []string{"MULTI", "EXEC", "DISCARD", "RESET", "QUIT"}
 
 func generateVoidResponse() resp.RespValue {
 	return resp.RespValue{Type: resp.TYPE_VOID}
@@ -79,6 +67,15 @@ func generateNullResponse() resp.RespValue {
 	return resp.RespValue{Type: resp.TYPE_NULL}
 }
 
+// generateMapResponse expects alternating keys and values, and falls back to
+// a flat array for RESP2 clients
+func generateMapResponse(h handlerArgs, pairs []resp.RespValue) resp.RespValue {
+	if h.conn.Protocol() == 3 {
+		return resp.RespValue{Type: resp.TYPE_MAP, Array: pairs}
+	}
+	return generateArrayResponse(pairs)
+}
+
 func generateStringResponse(str string) resp.RespValue {
 	return resp.RespValue{Type: resp.TYPE_STRING, Str: str}
 }
@@ -87,28 +84,110 @@ func generateIntegerResponse(num int) resp.RespValue {
 	return resp.RespValue{Type: resp.TYPE_INTEGER, Num: num}
 }
 
+// generateErrorResponse prefixes an error with ERR, unless it is a
+// RedisError with its own code
 func generateErrorResponse(err error) resp.RespValue {
-	return resp.RespValue{Type: resp.TYPE_ERROR, Str: fmt.Sprintf("ERR %s", err.Error())}
+	var redisErr *RedisError
+	if errors.As(err, &redisErr) {
+		return resp.RespValue{Type: resp.TYPE_ERROR, Str: redisErr.Error()}
+	}
+
+	return resp.RespValue{Type: resp.TYPE_ERROR, Str: fmt.Sprintf("%s %s", CODE_ERR, err.Error())}
 }
 
-func HandleRespValue(v resp.RespValue, conn *connection.Connection, store storage.Store, config configuration.Config) resp.RespValue {
+func HandleRespValue(v resp.RespValue, conn *connection.Connection, store storage.Store, config *configuration.Config) resp.RespValue {
 	if v.Type != "array" {
-		return generateErrorResponse(fmt.Errorf("Only accept array type"))
+		return rejectCommand(conn, "", fmt.Errorf("Only accept array type"))
 	}
 
 	command := strings.ToUpper(v.Array[0].Bulk)
 	args := v.Array[1:]
-	handler, ok := Handlers[command]
+	handler := commandTable[command].handler
+
+	if handler == nil {
+		return rejectCommand(conn, "", unknownCommandError(v.Array))
+	}
+
+	spec, _ := lookupCommandSpec(command, args)
+	name := commandName(command, args)
 
-	if !ok {
-		return generateErrorResponse(fmt.Errorf("Invalid command: %s", command))
+	if !spec.checkArity(len(v.Array)) {
+		return rejectCommand(conn, name, fmt.Errorf("wrong number of arguments for '%s' command", name))
 	}
 
-	if command != "AUTH" && !conn.Validated {
-		return generateErrorResponse(fmt.Errorf("Not validated"))
+	if !spec.hasFlag(FLAG_NO_AUTH) && !conn.Validated() {
+		return rejectCommand(conn, name, ErrNoAuth)
 	}
 
-	r := handler(handlerArgs{args: args, conn: conn, command: command, store: store, config: config})
+	conn.StartCommand(name)
+
+	if command != "AUTH" && conn.Validated() {
+		if err := checkPermissions(acl.GetUser(conn.User()), command, args); err != nil {
+			stats.RecordRejected(name)
+			conn.FlagMulti()
+			return permissionDenied(conn, err)
+		}
+	}
+
+	if conn.IsSubscribed() && !slices.Contains(subscriberModeCommands, command) {
+		return rejectCommand(conn, name, fmt.Errorf("Can't execute '%s': only (S)SUBSCRIBE / (S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", strings.ToLower(command)))
+	}
+
+	if conn.InMulti() && !slices.Contains(transactionCommands, command) {
+		if spec.hasFlag(FLAG_NO_MULTI) {
+			return rejectCommand(conn, name, fmt.Errorf("Command not allowed inside a transaction"))
+		}
+		conn.QueueCommand(v.Array)
+		return generateStringResponse("QUEUED")
+	}
+
+	waitWhilePaused(conn, command, args)
+
+	h := handlerArgs{args: args, conn: conn, command: command, store: store, config: config}
+
+	// SHUTDOWN isn't counted as running, so that it doesn't wait for itself
+	// and ABORT can get through while a shutdown is pending. It runs on the
+	// client's goroutine as it waits for the commands queued on the executor.
+	if command == "SHUTDOWN" {
+		return call(h, v.Array, spec, name)
+	}
+
+	if err := beginCommand(); err != nil {
+		return rejectCommand(conn, name, err)
+	}
+	defer endCommand()
+
+	response := resp.RespValue{}
+	run := executor.Run
+	if command == "EXEC" {
+		run = executor.RunAtomically
+	}
+	run(executorKeys(conn, command, args, spec), func() {
+		response = call(h, v.Array, spec, name)
+	})
+
+	return response
+}
+
+// call runs a command that passed every check, feeding it to the monitors and
+// recording it in the stats, slow log and latency monitor
+func call(h handlerArgs, argv []resp.RespValue, spec commandSpec, name string) resp.RespValue {
+	feedMonitors(h.conn, h.command, argv, spec)
+
+	start := time.Now()
+	r := runHandler(h)
+	duration := time.Since(start)
+	stats.RecordCommand(name, duration, r.err != nil || r.resp.Type == resp.TYPE_ERROR)
+	logSlowCommand(h.conn, h.command, argv, duration)
+	if spec.hasFlag(FLAG_FAST) {
+		latency.AddSample(latency.EVENT_FAST_COMMAND, duration)
+	} else {
+		latency.AddSample(latency.EVENT_COMMAND, duration)
+	}
+
+	if h.command != "CLIENT" || len(h.args) == 0 || strings.ToUpper(h.args[0].Bulk) != "CACHING" {
+		resetTrackingCaching(h.conn)
+	}
 
 	if r.err != nil {
 		return generateErrorResponse(r.err)
@@ -116,3 +195,42 @@ func HandleRespValue(v resp.RespValue, conn *connection.Connection, store storag
 
 	return r.resp
 }
+
+// runHandler runs the command's handler, and runs it again from the start if
+// its transaction fails with a transient error, such as a write conflict with
+// another server. Handlers only have effects outside the store once their
+// transaction has committed, so a failed attempt leaves nothing behind.
+func runHandler(h handlerArgs) handlerResponse {
+	handler := commandTable[h.command].handler
+	r := handler(h)
+	for attempt := 1; attempt < MAX_COMMAND_ATTEMPTS && h.store.IsTransient(r.err); attempt++ {
+		r = handler(h)
+	}
+
+	return r
+}
+
+// executorKeys returns the keys that decide which shards of the executor a
+// command runs on. Shard channels count as keys, and EXEC runs on the shards
+// of every queued command.
+func executorKeys(conn *connection.Connection, command string, args []resp.RespValue, spec commandSpec) []string {
+	if command != "EXEC" {
+		return argRange(args, spec.firstKey, spec.lastKey, spec.keyStep)
+	}
+
+	keys := []string{}
+	for _, argv := range conn.QueuedCommands() {
+		queued, _ := lookupCommandSpec(strings.ToUpper(argv[0].Bulk), argv[1:])
+		keys = append(keys, argRange(argv[1:], queued.firstKey, queued.lastKey, queued.keyStep)...)
+	}
+
+	return keys
+}
+
+// rejectCommand counts a command refused before it could run and replies
+// with the error. A transaction the command was meant for is failed too.
+func rejectCommand(conn *connection.Connection, name string, err error) resp.RespValue {
+	stats.RecordRejected(name)
+	conn.FlagMulti()
+	return generateErrorResponse(err)
+}
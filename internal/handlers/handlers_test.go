@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+func TestMain(m *testing.M) {
+	if err := acl.Init("", ""); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// testServer runs commands through HandleRespValue against a file store in
+// a temporary directory, without a listener
+type testServer struct {
+	t      *testing.T
+	store  storage.Store
+	config *configuration.Config
+}
+
+// newTestServer starts a server configured with args on top of the defaults
+func newTestServer(t *testing.T, args ...string) *testServer {
+	t.Helper()
+
+	config, err := configuration.InitConfig(append([]string{"--dir", t.TempDir()}, args...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := storage.InitStore(storage.Options{
+		Backend:     storage.BACKEND_FILE,
+		File:        filepath.Join(config.Get().Dir, "store.log"),
+		AppendFsync: storage.FSYNC_NO,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return &testServer{t: t, store: store, config: config}
+}
+
+// testClient is a connection whose pushed messages are discarded, so that
+// only the replies HandleRespValue returns are seen
+type testClient struct {
+	server *testServer
+	conn   *connection.Connection
+}
+
+func (s *testServer) client() *testClient {
+	local, remote := net.Pipe()
+	go io.Copy(io.Discard, remote)
+
+	conn := connection.NewConnection(local)
+	conn.SetValidated(acl.AuthenticatedByDefault())
+	s.t.Cleanup(func() {
+		conn.Close()
+		remote.Close()
+	})
+
+	return &testClient{server: s, conn: conn}
+}
+
+// call runs the command and returns its reply
+func (c *testClient) call(args ...string) resp.RespValue {
+	argv := []resp.RespValue{}
+	for _, a := range args {
+		argv = append(argv, resp.RespValue{Type: resp.TYPE_BULK, Bulk: a})
+	}
+
+	return HandleRespValue(resp.RespValue{Type: resp.TYPE_ARRAY, Array: argv}, c.conn, c.server.store, c.server.config)
+}
+
+// do runs the command and returns its reply in RESP
+func (c *testClient) do(args ...string) string {
+	reply := c.call(args...)
+	return string(reply.Marshall())
+}
+
+// The channel testSubscriber uses to tell when it has seen every message
+// published before
+const MARKER_CHANNEL = "test:marker"
+
+// testSubscriber is a client subscribed to channels that keeps the messages
+// pushed to it
+type testSubscriber struct {
+	*testClient
+	messages chan [2]string
+}
+
+func (s *testServer) subscriber(channels ...string) *testSubscriber {
+	local, remote := net.Pipe()
+	messages := make(chan [2]string, 1024)
+	go func() {
+		defer close(messages)
+		reader := resp.NewRespReader(remote)
+		for {
+			v, err := reader.ReadResp()
+			if err != nil {
+				return
+			}
+			if len(v.Array) == 3 && v.Array[0].Bulk == "message" {
+				messages <- [2]string{v.Array[1].Bulk, v.Array[2].Bulk}
+			}
+		}
+	}()
+
+	conn := connection.NewConnection(local)
+	conn.SetValidated(acl.AuthenticatedByDefault())
+	s.t.Cleanup(func() {
+		conn.Close()
+		remote.Close()
+	})
+
+	sub := &testSubscriber{testClient: &testClient{server: s, conn: conn}, messages: messages}
+	sub.call(append([]string{"SUBSCRIBE", MARKER_CHANNEL}, channels...)...)
+	return sub
+}
+
+// received returns the channel and message of everything published to the
+// subscriber since the last call
+func (sub *testSubscriber) received() [][2]string {
+	sub.server.t.Helper()
+
+	sub.server.client().call("PUBLISH", MARKER_CHANNEL, "")
+	received := [][2]string{}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case m, ok := <-sub.messages:
+			if !ok {
+				sub.server.t.Fatal("the subscriber's connection closed")
+			}
+			if m[0] == MARKER_CHANNEL {
+				return received
+			}
+			received = append(received, m)
+		case <-timeout:
+			sub.server.t.Fatalf("the subscriber didn't receive the marker, after %q", received)
+		}
+	}
+}
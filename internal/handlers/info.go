@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// infoSection writes the fields of one INFO section
+type infoSection struct {
+	name  string
+	write func(h handlerArgs, w *infoWriter) error
+}
+
+// Sections in the order INFO prints them. commandstats is only included when
+// asked for, or with all or everything.
+var infoSections = []infoSection{
+	{"server", infoServer},
+	{"clients", infoClients},
+	{"memory", infoMemory},
+	{"persistence", infoPersistence},
+	{"stats", infoStats},
+	{"replication", infoReplication},
+	{"commandstats", infoCommandstats},
+	{"keyspace", infoKeyspace},
+}
+
+var defaultInfoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "keyspace"}
+
+type infoWriter struct {
+	strings.Builder
+}
+
+func (w *infoWriter) field(name string, value any) {
+	fmt.Fprintf(w, "%s:%v\r\n", name, value)
+}
+
+func info(h handlerArgs) handlerResponse {
+	wanted := []string{}
+	for _, arg := range h.args {
+		wanted = append(wanted, strings.ToLower(arg.Bulk))
+	}
+
+	switch {
+	case len(wanted) == 0 || slices.Contains(wanted, "default"):
+		wanted = append(wanted, defaultInfoSections...)
+	case slices.Contains(wanted, "all") || slices.Contains(wanted, "everything"):
+		for _, s := range infoSections {
+			wanted = append(wanted, s.name)
+		}
+	}
+
+	w := &infoWriter{}
+	for _, s := range infoSections {
+		if !slices.Contains(wanted, s.name) {
+			continue
+		}
+		if w.Len() > 0 {
+			w.WriteString("\r\n")
+		}
+		fmt.Fprintf(w, "# %s\r\n", strings.ToUpper(s.name[:1])+s.name[1:])
+		if err := s.write(h, w); err != nil {
+			return handlerResponse{err: err}
+		}
+	}
+
+	return handlerResponse{
+		resp: generateBulkResponse(w.String()),
+	}
+}
+
+func infoServer(h handlerArgs, w *infoWriter) error {
+	uptime := time.Since(stats.StartedAt)
+	executable, _ := os.Executable()
+
+	w.field("redis_version", SERVER_VERSION)
+	w.field("redis_mode", "standalone")
+	w.field("os", runtime.GOOS+" "+runtime.GOARCH)
+	w.field("arch_bits", 32<<(^uint(0)>>63))
+	w.field("go_version", runtime.Version())
+	w.field("process_id", os.Getpid())
+	w.field("run_id", storage.InstanceID)
+	w.field("tcp_port", h.config.Get().Port)
+	w.field("server_time_usec", time.Now().UnixMicro())
+	w.field("uptime_in_seconds", int64(uptime.Seconds()))
+	w.field("uptime_in_days", int64(uptime.Hours()/24))
+	// serverCron runs every 100ms
+	w.field("hz", 10)
+	w.field("executable", executable)
+	w.field("config_file", h.config.ConfigFile())
+	return nil
+}
+
+func infoClients(h handlerArgs, w *infoWriter) error {
+	clients := connection.All()
+	maxInput, maxOutput := 0, int64(0)
+	tracking, pubsub := 0, 0
+	for _, c := range clients {
+		maxInput = max(maxInput, c.QueryBuffer())
+		_, pendingBytes := c.OutputBuffer()
+		maxOutput = max(maxOutput, pendingBytes)
+		if c.Tracking().Enabled {
+			tracking++
+		}
+		if c.IsSubscribed() {
+			pubsub++
+		}
+	}
+
+	w.field("connected_clients", len(clients))
+	w.field("cluster_connections", 0)
+	w.field("maxclients", h.config.Get().MaxClients)
+	w.field("client_recent_max_input_buffer", maxInput)
+	w.field("client_recent_max_output_buffer", maxOutput)
+	w.field("blocked_clients", BlockedClients())
+	w.field("tracking_clients", tracking)
+	w.field("pubsub_clients", pubsub)
+	w.field("clients_in_timeout_table", 0)
+	w.field("total_blocking_keys", 0)
+	return nil
+}
+
+// infoMemory reports the Go heap as used memory, since that is what holds
+// the server's data. used_memory_rss is everything obtained from the OS.
+func infoMemory(h handlerArgs, w *infoWriter) error {
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+	peak := stats.MemoryPeak(m.HeapAlloc)
+
+	w.field("used_memory", m.HeapAlloc)
+	w.field("used_memory_human", bytesToHuman(m.HeapAlloc))
+	w.field("used_memory_rss", m.Sys)
+	w.field("used_memory_rss_human", bytesToHuman(m.Sys))
+	w.field("used_memory_peak", peak)
+	w.field("used_memory_peak_human", bytesToHuman(peak))
+	w.field("used_memory_peak_perc", fmt.Sprintf("%.2f%%", float64(m.HeapAlloc)*100/float64(peak)))
+	w.field("maxmemory", 0)
+	w.field("maxmemory_human", "0B")
+	w.field("maxmemory_policy", "noeviction")
+	w.field("mem_fragmentation_ratio", fmt.Sprintf("%.2f", float64(m.Sys)/float64(max(m.HeapAlloc, 1))))
+	w.field("mem_allocator", "go")
+	return nil
+}
+
+// infoPersistence reports nothing in progress, since every write goes
+// straight to the storage
+func infoPersistence(h handlerArgs, w *infoWriter) error {
+	w.field("loading", 0)
+	w.field("async_loading", 0)
+	w.field("rdb_changes_since_last_save", 0)
+	w.field("rdb_bgsave_in_progress", 0)
+	w.field("rdb_last_save_time", stats.StartedAt.Unix())
+	w.field("rdb_last_bgsave_status", "ok")
+	w.field("aof_enabled", 0)
+	w.field("aof_rewrite_in_progress", 0)
+	w.field("aof_last_bgrewrite_status", "ok")
+	return nil
+}
+
+func infoStats(h handlerArgs, w *infoWriter) error {
+	w.field("total_connections_received", stats.ConnectionsReceived.Load())
+	w.field("total_commands_processed", stats.CommandsProcessed.Load())
+	w.field("instantaneous_ops_per_sec", stats.InstantaneousOps())
+	w.field("rejected_connections", stats.RejectedConnections.Load())
+	w.field("expired_keys", stats.ExpiredKeys.Load())
+	w.field("evicted_keys", stats.EvictedKeys.Load())
+	w.field("keyspace_hits", stats.KeyspaceHits.Load())
+	w.field("keyspace_misses", stats.KeyspaceMisses.Load())
+	w.field("pubsub_channels", len(getAllChannels()))
+	w.field("pubsub_patterns", 0)
+	w.field("pubsub_shardchannels", len(getAllShardChannels()))
+	w.field("total_error_replies", stats.ErrorReplies.Load())
+	return nil
+}
+
+func infoReplication(h handlerArgs, w *infoWriter) error {
+	w.field("role", "master")
+	w.field("connected_slaves", 0)
+	w.field("master_failover_state", "no-failover")
+	w.field("master_replid", storage.InstanceID)
+	w.field("master_repl_offset", 0)
+	return nil
+}
+
+func infoCommandstats(h handlerArgs, w *infoWriter) error {
+	commands := stats.Commands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		c := commands[name]
+		usec := c.Duration.Microseconds()
+		perCall := 0.0
+		if c.Calls > 0 {
+			perCall = float64(usec) / float64(c.Calls)
+		}
+		w.field("cmdstat_"+name, fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d,failed_calls=%d", c.Calls, usec, perCall, c.RejectedCalls, c.FailedCalls))
+	}
+	return nil
+}
+
+// infoKeyspace lists db0 only, as the server has a single keyspace. Like
+// Redis, an empty database isn't listed.
+func infoKeyspace(h handlerArgs, w *infoWriter) error {
+	keyspace, err := h.store.KeyspaceInfo()
+	if err != nil {
+		return err
+	}
+
+	if keyspace.Keys > 0 {
+		w.field("db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keyspace.Keys, keyspace.Expires, keyspace.AvgTTL))
+	}
+	return nil
+}
+
+// bytesToHuman formats a size the way Redis does, e.g. 1.50M
+func bytesToHuman(n uint64) string {
+	units := []string{"K", "M", "G", "T", "P"}
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+
+	size := float64(n) / 1024
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f%s", size, units[unit])
+}
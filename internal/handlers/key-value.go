@@ -1,18 +1,8 @@
 package handlers
 
-import (
-	"fmt"
-
-	"github.com/mmacdo54/go-redis-clone/internal/storage"
-)
+import "github.com/mmacdo54/go-redis-clone/internal/storage"
 
 func exists(h handlerArgs) handlerResponse {
-	if len(h.args) == 0 {
-		return handlerResponse{
-			err: fmt.Errorf("no keys passed to 'exists' command"),
-		}
-	}
-
 	count := 0
 	for _, k := range h.args {
 		exists, err := h.store.Exists(storage.KV{Key: k.Bulk})
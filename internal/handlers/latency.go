@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/latency"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
+)
+
+func latencyCommand(h handlerArgs) handlerResponse {
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "LATEST":
+		return latencyLatest()
+	case "HISTORY":
+		return latencyHistory(args)
+	case "RESET":
+		names := []string{}
+		for _, a := range args {
+			names = append(names, a.Bulk)
+		}
+		return handlerResponse{resp: generateIntegerResponse(latency.Reset(names...))}
+	case "DOCTOR":
+		return handlerResponse{resp: generateBulkResponse(latency.Doctor())}
+	case "HISTOGRAM":
+		return latencyHistogram(h, args)
+	default:
+		return handlerResponse{
+			err: unknownSubcommandError("latency", h.args[0].Bulk),
+		}
+	}
+}
+
+func latencyLatest() handlerResponse {
+	events := []resp.RespValue{}
+	for _, e := range latency.LatestSamples() {
+		events = append(events, generateArrayResponse([]resp.RespValue{
+			generateBulkResponse(e.Name),
+			generateIntegerResponse(int(e.Time)),
+			generateIntegerResponse(e.Latest),
+			generateIntegerResponse(e.Max),
+		}))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(events),
+	}
+}
+
+func latencyHistory(args []resp.RespValue) handlerResponse {
+	samples := []resp.RespValue{}
+	for _, s := range latency.History(args[0].Bulk) {
+		samples = append(samples, generateArrayResponse([]resp.RespValue{
+			generateIntegerResponse(int(s.Time)),
+			generateIntegerResponse(s.Latency),
+		}))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(samples),
+	}
+}
+
+// latencyHistogram reports the cumulative latency distribution of the named
+// commands, or of every command that has been called. Buckets are keyed by
+// their upper bound in microseconds.
+func latencyHistogram(h handlerArgs, args []resp.RespValue) handlerResponse {
+	commands := stats.Commands()
+	names := []string{}
+	if len(args) == 0 {
+		for name := range commands {
+			names = append(names, name)
+		}
+	} else {
+		for _, a := range args {
+			name := strings.ToLower(a.Bulk)
+			if _, ok := commands[name]; ok && !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+
+	histograms := []resp.RespValue{}
+	for _, name := range names {
+		c := commands[name]
+		buckets := []resp.RespValue{}
+		cumulative := int64(0)
+		for i, bound := range stats.LatencyBuckets {
+			if i < len(c.Latency.Buckets) {
+				cumulative += c.Latency.Buckets[i]
+			}
+			if cumulative == 0 {
+				continue
+			}
+			buckets = append(buckets, generateIntegerResponse(int(bound.Microseconds())), generateIntegerResponse(int(cumulative)))
+			if cumulative == c.Latency.Count {
+				break
+			}
+		}
+
+		histograms = append(histograms,
+			generateBulkResponse(name),
+			generateMapResponse(h, []resp.RespValue{
+				generateBulkResponse("calls"), generateIntegerResponse(int(c.Calls)),
+				generateBulkResponse("histogram_usec"), generateMapResponse(h, buckets),
+			}),
+		)
+	}
+
+	return handlerResponse{
+		resp: generateMapResponse(h, histograms),
+	}
+}
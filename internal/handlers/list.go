@@ -4,83 +4,30 @@ import (
 	"fmt"
 	"strconv"
 
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
 	"github.com/mmacdo54/go-redis-clone/internal/storage"
 )
 
 func lpush(h handlerArgs) handlerResponse {
-	if len(h.args) < 2 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of commands passed to '%s' command", h.command),
-		}
-	}
-
-	key := h.args[0].Bulk
-	el, ok, err := h.store.GetByKey(storage.KV{Key: key})
-
-	if err != nil {
-		return handlerResponse{
-			err: err,
-		}
-	}
-
-	if h.command == "LPUSHX" && !ok {
-		return handlerResponse{
-			err: fmt.Errorf("key does not exist"),
-		}
-	}
-
-	if ok && el.Typ != "" && el.Typ != LIST {
-		return handlerResponse{
-			err: fmt.Errorf("value stored at key is not a list"),
-		}
-	}
-
-	el.Key = key
-	el.Typ = LIST
-	list := []string{}
-	for i := len(h.args) - 1; i >= 1; i-- {
-		list = append(list, h.args[i].Bulk)
-	}
+	return push(h, true)
+}
 
-	if ok {
-		list = append(list, el.Arr...)
-	}
-	el.Arr = list
+func rpush(h handlerArgs) handlerResponse {
+	return push(h, false)
+}
 
+// push implements LPUSH, RPUSH and their X variants, which only push to a
+// list that exists. The store adds the elements without reading the list.
+func push(h handlerArgs, left bool) handlerResponse {
+	key := h.args[0].Bulk
 	tx, err := h.store.InitTransaction()
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
-	err = h.store.SetKV(el, tx)
 
-	if err != nil {
-		return handlerResponse{
-			err: err,
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
-		return handlerResponse{
-			err: err,
-		}
-	}
-
-	return handlerResponse{
-		resp: generateIntegerResponse(len(list)),
-	}
-}
-
-func rpush(h handlerArgs) handlerResponse {
-	if len(h.args) < 2 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of commands passed to '%s' command", h.command),
-		}
-	}
-
-	key := h.args[0].Bulk
-	el, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	el, ok, err := h.store.GetTypeForUpdate(storage.KV{Key: key}, tx)
 
 	if err != nil {
 		return handlerResponse{
@@ -88,31 +35,26 @@ func rpush(h handlerArgs) handlerResponse {
 		}
 	}
 
-	if h.command == "RPUSHX" && !ok {
+	if (h.command == "LPUSHX" || h.command == "RPUSHX") && !ok {
+		tx.Abort()
 		return handlerResponse{
-			err: fmt.Errorf("key does not exist"),
+			resp: generateIntegerResponse(0),
 		}
 	}
 
 	if ok && el.Typ != "" && el.Typ != LIST {
+		tx.Abort()
 		return handlerResponse{
-			err: fmt.Errorf("value stored at key is not a list"),
+			err: ErrWrongType,
 		}
 	}
 
-	el.Key = key
-	el.Typ = LIST
+	values := []string{}
 	for _, v := range h.args[1:] {
-		el.Arr = append(el.Arr, v.Bulk)
+		values = append(values, v.Bulk)
 	}
 
-	tx, err := h.store.InitTransaction()
-	if err != nil {
-		return handlerResponse{
-			err: err,
-		}
-	}
-	err = h.store.SetKV(el, tx)
+	length, err := h.store.ListPush(storage.KV{Key: key}, values, left, tx)
 
 	if err != nil {
 		return handlerResponse{
@@ -126,78 +68,74 @@ func rpush(h handlerArgs) handlerResponse {
 		}
 	}
 
+	event := "rpush"
+	if left {
+		event = "lpush"
+	}
+
+	signalModifiedKey(h, key)
+	if !ok {
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_NEW, "new", key)
+	}
+	notifyKeyspaceEvent(h.config, configuration.NOTIFY_LIST, event, key)
+
 	return handlerResponse{
-		resp: generateIntegerResponse(len(el.Arr)),
+		resp: generateIntegerResponse(length),
 	}
 }
 
 func lpop(h handlerArgs) handlerResponse {
+	return pop(h, true)
+}
+
+func rpop(h handlerArgs) handlerResponse {
+	return pop(h, false)
+}
+
+func pop(h handlerArgs, left bool) handlerResponse {
+	event := "rpop"
+	if left {
+		event = "lpop"
+	}
+
 	// TODO handle a range
 	if len(h.args) == 2 {
 		return handlerResponse{
-			err: fmt.Errorf("wrong number of commands passed to 'lpop' command"),
+			err: fmt.Errorf("wrong number of arguments for '%s' command", event),
 		}
 	}
 
 	key := h.args[0].Bulk
-	el, ok, err := h.store.GetByKey(storage.KV{Key: key})
-
+	tx, err := h.store.InitTransaction()
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
 
-	if !ok || el.Typ != LIST || len(el.Arr) == 0 {
-		return handlerResponse{
-			resp: generateNullResponse(),
-		}
-	}
+	el, ok, err := h.store.GetTypeForUpdate(storage.KV{Key: key}, tx)
 
-	tx, err := h.store.InitTransaction()
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
 
-	val := el.Arr[0]
-	if len(el.Arr) == 1 {
-		if _, err := h.store.DeleteByKey(el, tx); err != nil {
-			return handlerResponse{
-				err: err,
-			}
-		}
-	} else {
-		el.Arr = el.Arr[1:]
-		if err := h.store.SetKV(el, tx); err != nil {
-			return handlerResponse{
-				err: err,
-			}
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
+	if ok && el.Typ != LIST {
+		tx.Abort()
 		return handlerResponse{
-			err: err,
+			err: ErrWrongType,
 		}
 	}
 
-	return handlerResponse{
-		resp: generateBulkResponse(val),
-	}
-}
-
-func rpop(h handlerArgs) handlerResponse {
-	// TODO handle a range
-	if len(h.args) == 2 {
+	if !ok {
+		tx.Abort()
 		return handlerResponse{
-			err: fmt.Errorf("wrong number of commands passed to 'rpop' command"),
+			resp: generateNullResponse(),
 		}
 	}
 
-	key := h.args[0].Bulk
-	el, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	val, popped, emptied, err := h.store.ListPop(storage.KV{Key: key}, left, tx)
 
 	if err != nil {
 		return handlerResponse{
@@ -205,39 +143,24 @@ func rpop(h handlerArgs) handlerResponse {
 		}
 	}
 
-	if !ok || el.Typ != LIST || len(el.Arr) == 0 {
+	if err := tx.Commit(); err != nil {
 		return handlerResponse{
-			resp: generateNullResponse(),
+			err: err,
 		}
 	}
 
-	tx, err := h.store.InitTransaction()
-	if err != nil {
+	// A list with no elements to pop is removed by ListPop, and replied to
+	// as if it didn't exist
+	if !popped {
 		return handlerResponse{
-			err: err,
+			resp: generateNullResponse(),
 		}
 	}
 
-	val := el.Arr[len(el.Arr)-1]
-	if len(el.Arr) == 1 {
-		if _, err := h.store.DeleteByKey(el, tx); err != nil {
-			return handlerResponse{
-				err: err,
-			}
-		}
-	} else {
-		el.Arr = el.Arr[:len(el.Arr)-1]
-		if err := h.store.SetKV(el, tx); err != nil {
-			return handlerResponse{
-				err: err,
-			}
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
-		return handlerResponse{
-			err: err,
-		}
+	signalModifiedKey(h, key)
+	notifyKeyspaceEvent(h.config, configuration.NOTIFY_LIST, event, key)
+	if emptied {
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_GENERIC, "del", key)
 	}
 
 	return handlerResponse{
@@ -246,15 +169,9 @@ func rpop(h handlerArgs) handlerResponse {
 }
 
 func llen(h handlerArgs) handlerResponse {
-	if len(h.args) == 0 {
-		return handlerResponse{
-			err: fmt.Errorf("no key passed to 'llen' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
 
-	l, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	l, ok, err := lookupKeyRead(h, key)
 
 	if err != nil {
 		return handlerResponse{
@@ -270,7 +187,7 @@ func llen(h handlerArgs) handlerResponse {
 
 	if l.Typ != LIST {
 		return handlerResponse{
-			err: fmt.Errorf("value at key is not a list"),
+			err: ErrWrongType,
 		}
 	}
 
@@ -280,22 +197,16 @@ func llen(h handlerArgs) handlerResponse {
 }
 
 func lindex(h handlerArgs) handlerResponse {
-	if len(h.args) != 2 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong amount of arguments to 'lindex' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
 	index, err := strconv.Atoi(h.args[1].Bulk)
 
 	if err != nil {
 		return handlerResponse{
-			err: err,
+			err: fmt.Errorf("value is not an integer or out of range"),
 		}
 	}
 
-	l, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	l, ok, err := lookupKeyRead(h, key)
 
 	if err != nil {
 		return handlerResponse{
@@ -311,7 +222,7 @@ func lindex(h handlerArgs) handlerResponse {
 
 	if l.Typ != LIST {
 		return handlerResponse{
-			err: fmt.Errorf("value at key is not a list"),
+			err: ErrWrongType,
 		}
 	}
 
@@ -321,7 +232,7 @@ func lindex(h handlerArgs) handlerResponse {
 
 	if index < 0 || index >= len(l.Arr) {
 		return handlerResponse{
-			err: fmt.Errorf("index out of range"),
+			resp: generateNullResponse(),
 		}
 	}
 
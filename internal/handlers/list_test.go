@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// emptyListStore has a list with no elements under the key "empty", as a
+// Postgres row of type list without element rows
+type emptyListStore struct {
+	storage.Store
+}
+
+func (s emptyListStore) GetTypeForUpdate(kv storage.KV, t storage.Transaction) (storage.KV, bool, error) {
+	if kv.Key == "empty" {
+		return storage.KV{Key: kv.Key, Typ: LIST}, true, nil
+	}
+	return s.Store.GetTypeForUpdate(kv, t)
+}
+
+func (s emptyListStore) ListPop(kv storage.KV, left bool, t storage.Transaction) (string, bool, bool, error) {
+	if kv.Key == "empty" {
+		return "", false, true, nil
+	}
+	return s.Store.ListPop(kv, left, t)
+}
+
+// Popping a list with nothing to pop replies null rather than an empty
+// string
+func TestPopEmptyList(t *testing.T) {
+	s := newTestServer(t)
+	s.store = emptyListStore{Store: s.store}
+	c := s.client()
+
+	for _, command := range []string{"LPOP", "RPOP"} {
+		if got, want := c.do(command, "empty"), "$-1\r\n"; got != want {
+			t.Errorf("%s of a list without elements replied %q, want %q", command, got, want)
+		}
+	}
+
+	c.do("RPUSH", "list", "a", "b")
+	if got, want := c.do("LPOP", "list"), "$1\r\na\r\n"; got != want {
+		t.Errorf("LPOP replied %q, want %q", got, want)
+	}
+}
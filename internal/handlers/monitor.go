@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+var monitors = map[int64]*connection.Connection{}
+var monitorsMutex = sync.RWMutex{}
+
+func monitor(h handlerArgs) handlerResponse {
+	monitorsMutex.Lock()
+	defer monitorsMutex.Unlock()
+
+	h.conn.SetMonitor(true)
+	monitors[h.conn.ID] = h.conn
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func stopMonitoring(conn *connection.Connection) {
+	monitorsMutex.Lock()
+	defer monitorsMutex.Unlock()
+
+	conn.SetMonitor(false)
+	delete(monitors, conn.ID)
+}
+
+// feedMonitors sends a command about to run to every monitor. Like Redis,
+// admin commands aren't shown. Writes are only queued, so a slow monitor
+// can't hold up the client running the command.
+func feedMonitors(conn *connection.Connection, command string, argv []resp.RespValue, spec commandSpec) {
+	monitorsMutex.RLock()
+	defer monitorsMutex.RUnlock()
+
+	if len(monitors) == 0 || command == "MONITOR" || slices.Contains(spec.categories, "admin") {
+		return
+	}
+
+	addr := conn.Addr()
+	if conn.IsUnixSocket() {
+		addr = "unix:" + conn.Conn.LocalAddr().String()
+	}
+
+	var line strings.Builder
+	now := time.Now()
+	fmt.Fprintf(&line, "%d.%06d [0 %s]", now.Unix(), now.Nanosecond()/1000, addr)
+	for _, arg := range redactedArgs(argv) {
+		line.WriteString(" ")
+		line.WriteString(quoteMonitorArg(arg))
+	}
+
+	message := generateStringResponse(line.String())
+	for _, m := range monitors {
+		m.Write(message)
+	}
+}
+
+// quoteMonitorArg quotes an argument the way Redis' sdscatrepr does
+func quoteMonitorArg(arg string) string {
+	var quoted strings.Builder
+	quoted.WriteString(`"`)
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch c {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(c)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		default:
+			if c < ' ' || c > '~' {
+				fmt.Fprintf(&quoted, `\x%02x`, c)
+			} else {
+				quoted.WriteByte(c)
+			}
+		}
+	}
+	quoted.WriteString(`"`)
+	return quoted.String()
+}
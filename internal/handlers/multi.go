@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+func multi(h handlerArgs) handlerResponse {
+	if h.conn.InMulti() {
+		return handlerResponse{
+			err: fmt.Errorf("MULTI calls can not be nested"),
+		}
+	}
+	h.conn.StartMulti()
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+func discard(h handlerArgs) handlerResponse {
+	if !h.conn.InMulti() {
+		return handlerResponse{
+			err: fmt.Errorf("DISCARD without MULTI"),
+		}
+	}
+	h.conn.EndMulti()
+
+	return handlerResponse{
+		resp: generateStringResponse("OK"),
+	}
+}
+
+// exec runs the queued commands one after the other and replies with all
+// their replies. It is called by the executor on the shards of every queued
+// key, or exclusively when the executor is threaded, so no other command can
+// run in between. Commands that fail don't stop the others, as in Redis, but a
+// command rejected while queueing discards the whole transaction.
+func exec(h handlerArgs) handlerResponse {
+	if !h.conn.InMulti() {
+		return handlerResponse{
+			err: fmt.Errorf("EXEC without MULTI"),
+		}
+	}
+
+	commands, dirty := h.conn.EndMulti()
+	if dirty {
+		return handlerResponse{
+			err: ErrExecAbort,
+		}
+	}
+
+	replies := []resp.RespValue{}
+	for _, argv := range commands {
+		command := strings.ToUpper(argv[0].Bulk)
+		args := argv[1:]
+		spec, _ := lookupCommandSpec(command, args)
+		queued := handlerArgs{args: args, conn: h.conn, command: command, store: h.store, config: h.config}
+		replies = append(replies, call(queued, argv, spec, commandName(command, args)))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(replies),
+	}
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// notifyKeyspaceEvent publishes a keyspace and/or keyevent message for a key
+// if the event class has been enabled with notify-keyspace-events.
+func notifyKeyspaceEvent(config *configuration.Config, class int, event string, key string) {
+	if config.Get().NotifyKeyspaceEvents&class == 0 {
+		return
+	}
+
+	publishKeyspaceEvent(config, class, event, key)
+	if relayKeyspaceEvent != nil {
+		relayKeyspaceEvent(class, event, key)
+	}
+}
+
+func publishKeyspaceEvent(config *configuration.Config, class int, event string, key string) {
+	flags := config.Get().NotifyKeyspaceEvents
+	if flags&class == 0 {
+		return
+	}
+
+	if flags&configuration.NOTIFY_KEYSPACE != 0 {
+		channel := fmt.Sprintf("__keyspace@0__:%s", key)
+		sendMessageToChannel(channel, createMessage(channel, event))
+	}
+
+	if flags&configuration.NOTIFY_KEYEVENT != 0 {
+		channel := fmt.Sprintf("__keyevent@0__:%s", event)
+		sendMessageToChannel(channel, createMessage(channel, key))
+	}
+}
+
+// KeyExpired is called by the store whenever it removes an expired key.
+func KeyExpired(key string, config *configuration.Config) {
+	stats.ExpiredKeys.Add(1)
+	invalidateKey(key, nil)
+	notifyKeyspaceEvent(config, configuration.NOTIFY_EXPIRED, "expired", key)
+}
+
+// lookupKeyRead fetches a key for a read only command, remembering the read
+// for client side caching and emitting a keymiss event when it does not exist.
+func lookupKeyRead(h handlerArgs, key string) (storage.KV, bool, error) {
+	kv, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	if err != nil {
+		return kv, ok, err
+	}
+
+	keyRead(h, key, ok)
+	return kv, ok, err
+}
+
+// lookupKeyTypeRead is lookupKeyRead for commands that only need the type
+// of the key, and read its elements themselves
+func lookupKeyTypeRead(h handlerArgs, key string) (storage.KV, bool, error) {
+	kv, ok, err := h.store.GetTypeByKey(storage.KV{Key: key})
+	if err != nil {
+		return kv, ok, err
+	}
+
+	keyRead(h, key, ok)
+	return kv, ok, err
+}
+
+func keyRead(h handlerArgs, key string, ok bool) {
+	trackKeyRead(h, key)
+	if ok {
+		stats.KeyspaceHits.Add(1)
+	} else {
+		stats.KeyspaceMisses.Add(1)
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_KEY_MISS, "keymiss", key)
+	}
+}
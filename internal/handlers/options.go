@@ -1,10 +1,8 @@
 package handlers
 
 import (
-	"slices"
+	"fmt"
 	"strconv"
-	"strings"
-	"time"
 
 	"github.com/mmacdo54/go-redis-clone/internal/resp"
 )
@@ -38,140 +36,33 @@ type options struct {
 	opts    []resp.RespValue
 }
 
-type invalidOptionsError struct{}
-
-func (i invalidOptionsError) Error() string {
-	return "invalid options sent to 'set' command"
-}
+var (
+	errSyntax     = fmt.Errorf("syntax error")
+	errNotInteger = fmt.Errorf("value is not an integer or out of range")
+)
 
 func newOptions(opts []resp.RespValue) options {
 	return options{opts: opts}
 }
 
-func (o *options) setNXOrXXOption() error {
-	filteredOptions := []resp.RespValue{}
-
-	for _, opt := range o.opts {
-		upper := strings.ToUpper(opt.Bulk)
-		if upper == XX || upper == NX {
-			filteredOptions = append(filteredOptions, opt)
-		}
-	}
-
-	if len(filteredOptions) == 0 {
-		return nil
-	}
-
-	if len(filteredOptions) > 1 {
-		return invalidOptionsError{}
-	}
-
-	if strings.ToUpper(filteredOptions[0].Bulk) == NX {
-		o.nx = true
-		return nil
-	}
-
-	o.xx = true
-	return nil
-}
-
-func (o *options) setTTLOptions() error {
-	filteredOptions := []resp.RespValue{}
-
-	for _, opt := range o.opts {
-		if slices.Contains([]string{KEEPTTL, EX, PX, EXAT, PXAT}, strings.ToUpper(opt.Bulk)) {
-			filteredOptions = append(filteredOptions, opt)
-		}
-	}
-
-	if len(filteredOptions) == 0 {
-		return nil
-	}
-
-	if len(filteredOptions) > 1 {
-		return invalidOptionsError{}
-	}
-
-	opt := strings.ToUpper(filteredOptions[0].Bulk)
-
-	if strings.ToUpper(opt) == KEEPTTL {
-		o.keepttl = true
-		return nil
-	}
-
-	i := slices.IndexFunc(o.opts, func(rv resp.RespValue) bool {
-		return strings.ToUpper(rv.Bulk) == opt
-	})
-
-	if len(o.opts)-1 < i+1 {
-		return invalidOptionsError{}
+// ttlArgument reads the integer following the option at i, which must be
+// positive
+func (o *options) ttlArgument(i int, command string) (int, error) {
+	if i+1 >= len(o.opts) {
+		return 0, errSyntax
 	}
 
 	t, err := strconv.Atoi(o.opts[i+1].Bulk)
-
 	if err != nil {
-		return invalidOptionsError{}
-	}
-
-	now := int(time.Now().Unix())
-
-	switch opt {
-	case EX:
-		{
-			o.ex = (now + t) * 1000
-			return nil
-		}
-	case PX:
-		{
-			o.px = now*1000 + t
-			return nil
-		}
-	case EXAT:
-		{
-			o.exat = t * 1000
-			return nil
-		}
-	case PXAT:
-		{
-			o.pxat = t
-			return nil
-		}
-	}
-
-	return invalidOptionsError{}
-}
-
-func (o *options) setLTorGTOptions() error {
-	filteredOptions := []resp.RespValue{}
-
-	for _, opt := range o.opts {
-		upper := strings.ToUpper(opt.Bulk)
-		if upper == LT || upper == GT {
-			filteredOptions = append(filteredOptions, opt)
-		}
-	}
-
-	if len(filteredOptions) == 0 {
-		return nil
+		return 0, errNotInteger
 	}
-
-	if len(filteredOptions) > 1 {
-		return invalidOptionsError{}
+	if t <= 0 {
+		return 0, fmt.Errorf("invalid expire time in '%s' command", command)
 	}
 
-	if strings.ToUpper(filteredOptions[0].Bulk) == LT {
-		o.lt = true
-		return nil
-	}
-
-	o.gt = true
-	return nil
+	return t, nil
 }
 
-func (o *options) setReplaceOption() {
-	if slices.ContainsFunc(o.opts, func(rv resp.RespValue) bool {
-		return strings.ToUpper(rv.Bulk) == REPLACE
-	}) {
-		o.replace = true
-	}
+func (o *options) hasTTL() bool {
+	return o.keepttl || o.ex != 0 || o.px != 0 || o.exat != 0 || o.pxat != 0
 }
@@ -1,58 +1,99 @@
 package handlers
 
 import (
-	"slices"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mmacdo54/go-redis-clone/internal/resp"
 )
 
+// parseSetOptions reads the options of SET in order, like Redis, so that
+// conflicting or unknown options are a syntax error
 func parseSetOptions(opts []resp.RespValue) (options, error) {
 	s := newOptions(opts)
-	if len(opts) == 0 {
-		return s, nil
-	}
-
-	if err := s.setNXOrXXOption(); err != nil {
-		return s, err
-	}
+	now := int(time.Now().Unix())
 
-	if err := s.setTTLOptions(); err != nil {
-		return s, err
-	}
-
-	if slices.ContainsFunc(opts, func(rv resp.RespValue) bool {
-		return strings.ToUpper(rv.Bulk) == GET
-	}) {
-		s.get = true
+	for i := 0; i < len(opts); i++ {
+		switch opt := strings.ToUpper(opts[i].Bulk); opt {
+		case NX, XX:
+			if s.nx || s.xx {
+				return s, errSyntax
+			}
+			s.nx = opt == NX
+			s.xx = opt == XX
+		case GET:
+			s.get = true
+		case KEEPTTL:
+			if s.hasTTL() {
+				return s, errSyntax
+			}
+			s.keepttl = true
+		case EX, PX, EXAT, PXAT:
+			if s.hasTTL() {
+				return s, errSyntax
+			}
+			t, err := s.ttlArgument(i, "set")
+			if err != nil {
+				return s, err
+			}
+			switch opt {
+			case EX:
+				s.ex = (now + t) * 1000
+			case PX:
+				s.px = now*1000 + t
+			case EXAT:
+				s.exat = t * 1000
+			case PXAT:
+				s.pxat = t
+			}
+			i++
+		default:
+			return s, errSyntax
+		}
 	}
 
 	return s, nil
 }
 
+// parseExpireOptions reads the NX, XX, GT and LT flags of the EXPIRE family
 func parseExpireOptions(opts []resp.RespValue) (options, error) {
 	s := newOptions(opts)
-	if len(opts) == 0 {
-		return s, nil
-	}
 
-	if err := s.setNXOrXXOption(); err != nil {
-		return s, err
+	for _, opt := range opts {
+		switch strings.ToUpper(opt.Bulk) {
+		case NX:
+			s.nx = true
+		case XX:
+			s.xx = true
+		case GT:
+			s.gt = true
+		case LT:
+			s.lt = true
+		default:
+			return s, fmt.Errorf("Unsupported option %s", opt.Bulk)
+		}
 	}
 
-	if err := s.setLTorGTOptions(); err != nil {
-		return s, err
+	if s.nx && (s.xx || s.gt || s.lt) {
+		return s, fmt.Errorf("NX and XX, GT or LT options at the same time are not compatible")
 	}
-
-	if s.nx && (s.lt || s.gt) {
-		return s, invalidOptionsError{}
+	if s.gt && s.lt {
+		return s, fmt.Errorf("GT and LT options at the same time are not compatible")
 	}
 
 	return s, nil
 }
 
-func parseCopyOptions(opts []resp.RespValue) options {
+func parseCopyOptions(opts []resp.RespValue) (options, error) {
 	s := newOptions(opts)
-	s.setReplaceOption()
-	return s
+
+	for _, opt := range opts {
+		if strings.ToUpper(opt.Bulk) != REPLACE {
+			return s, errSyntax
+		}
+		s.replace = true
+	}
+
+	return s, nil
 }
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// PUBSUB_RELAY_CHANNEL is the backend channel used to fan published messages
+// out to every server instance sharing the store.
+const PUBSUB_RELAY_CHANNEL = "redis_pubsub"
+
+type relayedMessage struct {
+	Instance string `json:"instance"`
+	Shard    bool   `json:"shard"`
+	Channel  string `json:"channel"`
+	Message  string `json:"message"`
+}
+
+// StartPubSubRelay delivers messages published on other instances to the
+// subscribers connected to this one when pubsub-fanout is postgres.
+func StartPubSubRelay(store storage.Store, config *configuration.Config) error {
+	if config.Get().PubsubFanout != configuration.PUBSUB_FANOUT_POSTGRES {
+		return nil
+	}
+
+	notifier, ok := store.(storage.Notifier)
+	if !ok {
+		return fmt.Errorf("pubsub-fanout %s is not supported by the configured store", config.Get().PubsubFanout)
+	}
+
+	payloads, err := notifier.Listen(PUBSUB_RELAY_CHANNEL)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for payload := range payloads {
+			m := relayedMessage{}
+			if err := json.Unmarshal([]byte(payload), &m); err != nil {
+				logger.Warning("Invalid relayed pub/sub message: %v", err)
+				continue
+			}
+
+			// Messages published here have already been delivered locally
+			if m.Instance == storage.InstanceID {
+				continue
+			}
+
+			if m.Shard {
+				sendMessageToShardChannel(m.Channel, createShardMessage(m.Channel, m.Message))
+			} else {
+				sendMessageToChannel(m.Channel, createMessage(m.Channel, m.Message))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// relayMessage sends a message that was published here to the other
+// instances. The message has already been delivered to the subscribers of
+// this instance, so a message that can't be relayed is logged and skipped
+// rather than failing the command.
+func relayMessage(h handlerArgs, shard bool, channel string, message string) {
+	if h.config.Get().PubsubFanout != configuration.PUBSUB_FANOUT_POSTGRES {
+		return
+	}
+
+	notifier, ok := h.store.(storage.Notifier)
+	if !ok {
+		logger.Warning("pubsub-fanout %s is not supported by the configured store", h.config.Get().PubsubFanout)
+		return
+	}
+
+	payload, err := json.Marshal(relayedMessage{
+		Instance: storage.InstanceID,
+		Shard:    shard,
+		Channel:  channel,
+		Message:  message,
+	})
+	if err != nil {
+		logger.Warning("Could not relay a message published to '%s': %v", channel, err)
+		return
+	}
+	if len(payload) > storage.MAX_NOTIFY_PAYLOAD {
+		logger.Warning("Message published to '%s' is too large to relay to other instances (%d bytes, the limit is %d)", channel, len(payload), storage.MAX_NOTIFY_PAYLOAD)
+		return
+	}
+
+	if err := notifier.Notify(PUBSUB_RELAY_CHANNEL, string(payload)); err != nil {
+		logger.Warning("Could not relay a message published to '%s': %v", channel, err)
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// notifyingStore records the payloads it is asked to relay, as a Postgres
+// store would send them to other instances
+type notifyingStore struct {
+	storage.Store
+	mu       sync.Mutex
+	payloads []string
+}
+
+func (s *notifyingStore) Notify(channel string, payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.payloads = append(s.payloads, payload)
+	return nil
+}
+
+func (s *notifyingStore) Listen(channel string) (<-chan string, error) {
+	return make(chan string), nil
+}
+
+func (s *notifyingStore) relayed() []relayedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []relayedMessage{}
+	for _, p := range s.payloads {
+		m := relayedMessage{}
+		json.Unmarshal([]byte(p), &m)
+		messages = append(messages, m)
+	}
+	return messages
+}
+
+// A message too large for pg_notify still reaches the subscribers of this
+// instance, and is left out of the relay rather than failing the command
+func TestPublishOversizedMessage(t *testing.T) {
+	s := newTestServer(t, "--pubsub-fanout", configuration.PUBSUB_FANOUT_POSTGRES)
+	store := &notifyingStore{Store: s.store}
+	s.store = store
+
+	subscriber := s.client()
+	subscriber.call("SUBSCRIBE", "channel")
+	subscriber.call("SSUBSCRIBE", "shard")
+	publisher := s.client()
+
+	large := strings.Repeat("x", storage.MAX_NOTIFY_PAYLOAD)
+	if got, want := publisher.do("PUBLISH", "channel", large), ":1\r\n"; got != want {
+		t.Errorf("PUBLISH of a large message replied %q, want %q", got, want)
+	}
+	if got, want := publisher.do("SPUBLISH", "shard", large), ":1\r\n"; got != want {
+		t.Errorf("SPUBLISH of a large message replied %q, want %q", got, want)
+	}
+	if got, want := publisher.do("PUBLISH", "channel", "small"), ":1\r\n"; got != want {
+		t.Errorf("PUBLISH replied %q, want %q", got, want)
+	}
+
+	relayed := store.relayed()
+	if len(relayed) != 1 || relayed[0].Channel != "channel" || relayed[0].Message != "small" {
+		t.Errorf("relayed %+v, want only the small message", relayed)
+	}
+	for _, p := range store.payloads {
+		if len(p) > storage.MAX_NOTIFY_PAYLOAD {
+			t.Errorf("relayed a payload of %d bytes", len(p))
+		}
+	}
+}
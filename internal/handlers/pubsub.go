@@ -2,132 +2,141 @@ package handlers
 
 import (
 	"fmt"
-	"net"
 	"slices"
+	"sort"
+	"strings"
 	"sync"
 
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/glob"
 	"github.com/mmacdo54/go-redis-clone/internal/resp"
 )
 
-var connections = map[string][]*net.Conn{}
+var connections = map[string][]*connection.Connection{}
 var connectionMutex = sync.RWMutex{}
 
-func getAllChannels() (keys []string) {
+func getAllChannels() map[string]int {
 	connectionMutex.RLock()
-	for k := range connections {
-		keys = append(keys, k)
+	defer connectionMutex.RUnlock()
+
+	channels := map[string]int{}
+	for channel, conns := range connections {
+		channels[channel] = len(conns)
 	}
-	connectionMutex.RUnlock()
-	return
+	return channels
+}
+
+// PubSubCounts returns the number of channels with subscribers and the
+// total number of subscriptions to them, for either SUBSCRIBE or SSUBSCRIBE
+// channels
+func PubSubCounts(shard bool) (int, int) {
+	channels := getAllChannels()
+	if shard {
+		channels = getAllShardChannels()
+	}
+
+	subscriptions := 0
+	for _, count := range channels {
+		subscriptions += count
+	}
+	return len(channels), subscriptions
 }
 
-func isInChannel(conn *net.Conn, channel string) bool {
+func isInChannel(conn *connection.Connection, channel string) bool {
 	connectionMutex.RLock()
-	conns, ok := connections[channel]
-	connectionMutex.RUnlock()
+	defer connectionMutex.RUnlock()
+
+	return slices.Contains(connections[channel], conn)
+}
 
-	if !ok {
-		return false
+func addToChannel(conn *connection.Connection, channel string) {
+	connectionMutex.Lock()
+	defer connectionMutex.Unlock()
+
+	if slices.Contains(connections[channel], conn) {
+		return
 	}
+	connections[channel] = append(connections[channel], conn)
+}
 
-	return slices.ContainsFunc(conns, func(c *net.Conn) bool {
-		return c == conn
-	})
+func removeFromChannel(conn *connection.Connection, channel string) {
+	connectionMutex.Lock()
+	defer connectionMutex.Unlock()
+
+	updatedConnections := []*connection.Connection{}
+	for _, c := range connections[channel] {
+		if conn != c {
+			updatedConnections = append(updatedConnections, c)
+		}
+	}
+
+	if len(updatedConnections) == 0 {
+		delete(connections, channel)
+		return
+	}
+	connections[channel] = updatedConnections
 }
 
-func sendMessageToConnection(conn *net.Conn, message resp.RespValue) {
-	w := resp.NewRespWriter(*conn)
-	w.WriteResp(message)
+// sendMessageToConnection never blocks. The message is queued and written by
+// the client's own writer with a deadline, and clients that fall too far
+// behind are disconnected by client-output-buffer-limit.
+func sendMessageToConnection(conn *connection.Connection, message resp.RespValue) {
+	if conn.Protocol() == 3 && message.Type == resp.TYPE_ARRAY {
+		message.Type = resp.TYPE_PUSH
+	}
+	conn.Write(message)
 }
 
 func sendMessageToChannel(channel string, message resp.RespValue) resp.RespValue {
 	connectionMutex.RLock()
-	connections := connections[channel]
+	conns := connections[channel]
 	connectionMutex.RUnlock()
 
-	var wg sync.WaitGroup
-	wg.Add(len(connections))
-	for _, c := range connections {
-		go sendMessageToConnection(c, message)
-		wg.Done()
+	// Writes are queued per connection, so a slow subscriber can't hold up
+	// the others
+	for _, c := range conns {
+		sendMessageToConnection(c, message)
 	}
-	wg.Wait()
 
-	return generateIntegerResponse(len(connections))
+	return generateIntegerResponse(len(conns))
 }
 
-func createSubMessage(channel string) resp.RespValue {
+func createMessage(channel string, message string) resp.RespValue {
 	return generateArrayResponse([]resp.RespValue{
-		generateBulkResponse("subscribe"),
+		generateBulkResponse("message"),
 		generateBulkResponse(channel),
-		generateIntegerResponse(1),
+		generateBulkResponse(message),
 	})
 }
 
-func sendSubMessages(channels []string) {
-	for _, c := range channels {
-		message := createSubMessage(c)
-		sendMessageToChannel(c, message)
-	}
-}
-
-func createUnsubMessage(channel string) resp.RespValue {
+func createSubscriptionMessage(kind string, channel resp.RespValue, count int) resp.RespValue {
 	return generateArrayResponse([]resp.RespValue{
-		generateBulkResponse("unsubscribe"),
-		generateBulkResponse(channel),
-		generateIntegerResponse(1),
+		generateBulkResponse(kind),
+		channel,
+		generateIntegerResponse(count),
 	})
 }
 
-func sendUnsubMessages(channels []string) {
-	for _, c := range channels {
-		message := createUnsubMessage(c)
-		sendMessageToChannel(c, message)
-	}
-}
-
-func removeFromChannels(conn *net.Conn, channels []string) {
-	unsubbedChannels := []string{}
-	for _, c := range channels {
-		if !isInChannel(conn, c) {
-			return
-		}
-		connectionMutex.Lock()
-		updatedConnections := []*net.Conn{}
-		for _, c := range connections[c] {
-			if conn != c {
-				updatedConnections = append(updatedConnections, c)
-			}
-		}
-		connections[c] = updatedConnections
-		connectionMutex.Unlock()
-		unsubbedChannels = append(unsubbedChannels, c)
+// RemoveConnection drops a disconnected client from every channel it was
+// subscribed to, and from the monitors, so that nothing else is written to
+// the closed socket.
+func RemoveConnection(conn *connection.Connection) {
+	stopMonitoring(conn)
+	for _, c := range conn.Channels() {
+		conn.Unsubscribe(c)
+		removeFromChannel(conn, c)
 	}
+	removeFromShardChannels(conn)
+	disableTracking(conn)
 }
 
 func subscribe(h handlerArgs) handlerResponse {
-	if len(h.args) == 0 {
-		return handlerResponse{
-			err: fmt.Errorf("'subscribe' command needs at least one channel"),
-		}
-	}
-
-	channels := []string{}
-
 	for _, c := range h.args {
-		channels = append(channels, c.Bulk)
-	}
-
-	connectionMutex.Lock()
-	for _, c := range channels {
-		if v, ok := connections[c]; ok && slices.Contains(v, h.conn.Conn) {
-			continue
+		if h.conn.Subscribe(c.Bulk) {
+			addToChannel(h.conn, c.Bulk)
 		}
-		connections[c] = append(connections[c], h.conn.Conn)
+		sendMessageToConnection(h.conn, createSubscriptionMessage("subscribe", generateBulkResponse(c.Bulk), h.conn.SubscriptionCount()))
 	}
-	connectionMutex.Unlock()
-
-	sendSubMessages(channels)
 
 	return handlerResponse{
 		resp: generateVoidResponse(),
@@ -137,36 +146,93 @@ func subscribe(h handlerArgs) handlerResponse {
 func unsubscribe(h handlerArgs) handlerResponse {
 	unsubChannels := []string{}
 	if len(h.args) == 0 {
-		unsubChannels = append(unsubChannels, getAllChannels()...)
+		unsubChannels = h.conn.Channels()
 	} else {
 		for _, c := range h.args {
 			unsubChannels = append(unsubChannels, c.Bulk)
 		}
 	}
 
-	removeFromChannels(h.conn.Conn, unsubChannels)
+	if len(unsubChannels) == 0 {
+		sendMessageToConnection(h.conn, createSubscriptionMessage("unsubscribe", generateNullResponse(), 0))
+	}
+
+	for _, c := range unsubChannels {
+		if h.conn.Unsubscribe(c) {
+			removeFromChannel(h.conn, c)
+		}
+		sendMessageToConnection(h.conn, createSubscriptionMessage("unsubscribe", generateBulkResponse(c), h.conn.SubscriptionCount()))
+	}
 
 	return handlerResponse{
-		resp: generateStringResponse("OK"),
+		resp: generateVoidResponse(),
 	}
 }
 
 func publish(h handlerArgs) handlerResponse {
-	if len(h.args) != 2 {
+	channel := h.args[0].Bulk
+	message := h.args[1].Bulk
+
+	receivers := sendMessageToChannel(channel, createMessage(channel, message))
+	relayMessage(h, false, channel, message)
+
+	return handlerResponse{
+		resp: receivers,
+	}
+}
+
+func pubsub(h handlerArgs) handlerResponse {
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "CHANNELS":
+		return listChannels(getAllChannels(), subcommand, args)
+	case "SHARDCHANNELS":
+		return listChannels(getAllShardChannels(), subcommand, args)
+	case "NUMSUB":
+		return countSubscribers(getAllChannels(), args)
+	case "SHARDNUMSUB":
+		return countSubscribers(getAllShardChannels(), args)
+	default:
 		return handlerResponse{
-			err: fmt.Errorf("wrong number of arguments for 'publish' command"),
+			err: unknownSubcommandError("pubsub", h.args[0].Bulk),
 		}
 	}
+}
 
-	channel := h.args[0].Bulk
-	message := h.args[1].Bulk
-	subMessage := generateArrayResponse([]resp.RespValue{
-		generateBulkResponse("message"),
-		generateBulkResponse(channel),
-		generateBulkResponse(message),
-	})
+func listChannels(channels map[string]int, subcommand string, args []resp.RespValue) handlerResponse {
+	if len(args) > 1 {
+		return handlerResponse{
+			err: fmt.Errorf("wrong number of arguments for 'pubsub|%s' command", strings.ToLower(subcommand)),
+		}
+	}
+
+	matched := []string{}
+	for c := range channels {
+		if len(args) == 0 || glob.Match(args[0].Bulk, c) {
+			matched = append(matched, c)
+		}
+	}
+	sort.Strings(matched)
+
+	res := []resp.RespValue{}
+	for _, c := range matched {
+		res = append(res, generateBulkResponse(c))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(res),
+	}
+}
+
+func countSubscribers(channels map[string]int, args []resp.RespValue) handlerResponse {
+	res := []resp.RespValue{}
+	for _, c := range args {
+		res = append(res, generateBulkResponse(c.Bulk), generateIntegerResponse(channels[c.Bulk]))
+	}
 
 	return handlerResponse{
-		resp: sendMessageToChannel(channel, subMessage),
+		resp: generateArrayResponse(res),
 	}
 }
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+const REDACTED = "(redacted)"
+
+// redactedArgs returns a command and its arguments, as sent by the client,
+// the way they may be shown in logs, with passwords and ACL rules replaced
+func redactedArgs(argv []resp.RespValue) []string {
+	redacted := []string{}
+	for _, a := range argv {
+		redacted = append(redacted, a.Bulk)
+	}
+
+	switch strings.ToUpper(redacted[0]) {
+	case "AUTH":
+		for i := 1; i < len(redacted); i++ {
+			redacted[i] = REDACTED
+		}
+	case "HELLO":
+		for i := 1; i < len(redacted); i++ {
+			if strings.ToUpper(redacted[i]) == "AUTH" {
+				for j := i + 1; j <= i+2 && j < len(redacted); j++ {
+					redacted[j] = REDACTED
+				}
+			}
+		}
+	case "ACL":
+		if len(redacted) > 1 && strings.ToUpper(redacted[1]) == "SETUSER" {
+			for i := 3; i < len(redacted); i++ {
+				redacted[i] = REDACTED
+			}
+		}
+	}
+
+	return redacted
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// transientError stands in for a conflict with a transaction of another
+// server
+type transientError struct{}
+
+func (transientError) Error() string {
+	return "WriteConflict error: this operation conflicted with another operation. Please retry your operation or multi-document transaction."
+}
+
+// conflictingStore fails the commits of its first transactions with a
+// transient error, aborting them instead
+type conflictingStore struct {
+	storage.Store
+	conflicts atomic.Int64
+}
+
+func (s *conflictingStore) IsTransient(err error) bool {
+	return errors.As(err, &transientError{})
+}
+
+type conflictingTransaction struct {
+	storage.Transaction
+	store *conflictingStore
+}
+
+func (s *conflictingStore) InitTransaction() (storage.Transaction, error) {
+	tx, err := s.Store.InitTransaction()
+	return conflictingTransaction{Transaction: tx, store: s}, err
+}
+
+func (t conflictingTransaction) Commit() error {
+	if t.store.conflicts.Add(-1) >= 0 {
+		t.Transaction.Abort()
+		return transientError{}
+	}
+	return t.Transaction.Commit()
+}
+
+// The store's methods type assert the transactions they are given, so the
+// ones of conflictingStore are unwrapped
+func unwrap(t storage.Transaction) storage.Transaction {
+	if tx, ok := t.(conflictingTransaction); ok {
+		return tx.Transaction
+	}
+	return t
+}
+
+func (s *conflictingStore) GetForUpdate(kv storage.KV, t storage.Transaction) (storage.KV, bool, error) {
+	return s.Store.GetForUpdate(kv, unwrap(t))
+}
+
+func (s *conflictingStore) GetTypeForUpdate(kv storage.KV, t storage.Transaction) (storage.KV, bool, error) {
+	return s.Store.GetTypeForUpdate(kv, unwrap(t))
+}
+
+func (s *conflictingStore) SetKV(kv storage.KV, t storage.Transaction) error {
+	return s.Store.SetKV(kv, unwrap(t))
+}
+
+func (s *conflictingStore) ListPush(kv storage.KV, values []string, left bool, t storage.Transaction) (int, error) {
+	return s.Store.ListPush(kv, values, left, unwrap(t))
+}
+
+func (s *conflictingStore) ListPop(kv storage.KV, left bool, t storage.Transaction) (string, bool, bool, error) {
+	return s.Store.ListPop(kv, left, unwrap(t))
+}
+
+func (s *conflictingStore) DeleteByKey(kv storage.KV, t storage.Transaction) (int, error) {
+	return s.Store.DeleteByKey(kv, unwrap(t))
+}
+
+func (s *conflictingStore) SetAdd(kv storage.KV, members []string, t storage.Transaction) (int, error) {
+	return s.Store.SetAdd(kv, members, unwrap(t))
+}
+
+// A command whose transaction conflicts is run again from the start, and
+// the attempts that failed leave nothing behind
+func TestTransientErrorsRetryCommand(t *testing.T) {
+	s := newTestServer(t)
+	store := &conflictingStore{Store: s.store}
+	s.store = store
+	c := s.client()
+
+	store.conflicts.Store(MAX_COMMAND_ATTEMPTS - 1)
+	if got, want := c.do("LPUSH", "list", "a"), ":1\r\n"; got != want {
+		t.Errorf("LPUSH replied %q, want %q", got, want)
+	}
+	if got, want := c.do("LLEN", "list"), ":1\r\n"; got != want {
+		t.Errorf("LLEN replied %q after a retried LPUSH, want %q", got, want)
+	}
+
+	store.conflicts.Store(MAX_COMMAND_ATTEMPTS)
+	conflict := transientError{}
+	if got, want := c.do("SADD", "set", "a"), "-ERR "+conflict.Error()+"\r\n"; got != want {
+		t.Errorf("SADD replied %q once it ran out of attempts, want %q", got, want)
+	}
+	if got, want := c.do("EXISTS", "set"), ":0\r\n"; got != want {
+		t.Errorf("EXISTS replied %q after SADD failed, want %q", got, want)
+	}
+}
@@ -1,22 +1,22 @@
 package handlers
 
 import (
-	"fmt"
-
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
 	"github.com/mmacdo54/go-redis-clone/internal/resp"
 	"github.com/mmacdo54/go-redis-clone/internal/storage"
 )
 
 func sadd(h handlerArgs) handlerResponse {
-	if len(h.args) < 2 {
+	key := h.args[0].Bulk
+
+	tx, err := h.store.InitTransaction()
+	if err != nil {
 		return handlerResponse{
-			err: fmt.Errorf("wrong amount of arguments passed to 'sadd' command"),
+			err: err,
 		}
 	}
 
-	key := h.args[0].Bulk
-
-	s, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	s, ok, err := h.store.GetTypeForUpdate(storage.KV{Key: key}, tx)
 
 	if err != nil {
 		return handlerResponse{
@@ -25,42 +25,37 @@ func sadd(h handlerArgs) handlerResponse {
 	}
 
 	if ok && s.Typ != SET {
+		tx.Abort()
 		return handlerResponse{
-			err: fmt.Errorf("key is not of type set"),
+			err: ErrWrongType,
 		}
 	}
 
-	if !ok {
-		s.Key = key
-		s.Typ = SET
-		s.Set = map[string]interface{}{}
-	}
-
-	count := 0
+	members := []string{}
 	for _, m := range h.args[1:] {
-		if _, ok := s.Set[m.Bulk]; !ok {
-			s.Set[m.Bulk] = struct{}{}
-			count++
-		}
+		members = append(members, m.Bulk)
 	}
 
-	tx, err := h.store.InitTransaction()
+	count, err := h.store.SetAdd(storage.KV{Key: key}, members, tx)
+
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
 
-	if err := h.store.SetKV(s, tx); err != nil {
+	if err := tx.Commit(); err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return handlerResponse{
-			err: err,
-		}
+	if !ok {
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_NEW, "new", key)
+	}
+	if count > 0 {
+		signalModifiedKey(h, key)
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_SET, "sadd", key)
 	}
 
 	return handlerResponse{
@@ -69,14 +64,8 @@ func sadd(h handlerArgs) handlerResponse {
 }
 
 func smembers(h handlerArgs) handlerResponse {
-	if len(h.args) != 1 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong amount of arguments passed to 'smembers' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
-	s, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	s, ok, err := lookupKeyRead(h, key)
 
 	if err != nil {
 		return handlerResponse{
@@ -92,7 +81,7 @@ func smembers(h handlerArgs) handlerResponse {
 
 	if s.Typ != SET {
 		return handlerResponse{
-			err: fmt.Errorf("key is not of type set"),
+			err: ErrWrongType,
 		}
 	}
 
@@ -107,15 +96,9 @@ func smembers(h handlerArgs) handlerResponse {
 }
 
 func sismember(h handlerArgs) handlerResponse {
-	if len(h.args) != 2 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong amount of arguments to 'sismember' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
 	value := h.args[1].Bulk
-	s, ok, err := h.store.GetByKey(storage.KV{Key: key})
+	s, ok, err := lookupKeyTypeRead(h, key)
 
 	if err != nil {
 		return handlerResponse{
@@ -123,13 +106,27 @@ func sismember(h handlerArgs) handlerResponse {
 		}
 	}
 
-	if !ok || s.Typ != SET {
+	if !ok {
 		return handlerResponse{
 			resp: generateIntegerResponse(0),
 		}
 	}
 
-	if _, exists := s.Set[value]; !exists {
+	if s.Typ != SET {
+		return handlerResponse{
+			err: ErrWrongType,
+		}
+	}
+
+	exists, err := h.store.SetIsMember(storage.KV{Key: key}, value)
+
+	if err != nil {
+		return handlerResponse{
+			err: err,
+		}
+	}
+
+	if !exists {
 		return handlerResponse{
 			resp: generateIntegerResponse(0),
 		}
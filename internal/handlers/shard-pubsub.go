@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/mmacdo54/go-redis-clone/internal/cluster"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+// Shard channels are kept apart from classic channels and grouped by hash
+// slot so that they can be routed with the keyspace once clustering exists.
+var shardConnections = map[int]map[string][]*connection.Connection{}
+var shardConnectionMutex = sync.RWMutex{}
+
+func getShardConnections(channel string) []*connection.Connection {
+	shardConnectionMutex.RLock()
+	defer shardConnectionMutex.RUnlock()
+
+	return shardConnections[cluster.KeySlot(channel)][channel]
+}
+
+func getAllShardChannels() map[string]int {
+	shardConnectionMutex.RLock()
+	defer shardConnectionMutex.RUnlock()
+
+	channels := map[string]int{}
+	for _, slot := range shardConnections {
+		for channel, conns := range slot {
+			channels[channel] = len(conns)
+		}
+	}
+	return channels
+}
+
+func addToShardChannel(conn *connection.Connection, channel string) {
+	shardConnectionMutex.Lock()
+	defer shardConnectionMutex.Unlock()
+
+	slot := cluster.KeySlot(channel)
+	if _, ok := shardConnections[slot]; !ok {
+		shardConnections[slot] = map[string][]*connection.Connection{}
+	}
+	if slices.Contains(shardConnections[slot][channel], conn) {
+		return
+	}
+	shardConnections[slot][channel] = append(shardConnections[slot][channel], conn)
+}
+
+func removeFromShardChannel(conn *connection.Connection, channel string) {
+	shardConnectionMutex.Lock()
+	defer shardConnectionMutex.Unlock()
+
+	slot := cluster.KeySlot(channel)
+	updatedConnections := []*connection.Connection{}
+	for _, c := range shardConnections[slot][channel] {
+		if conn != c {
+			updatedConnections = append(updatedConnections, c)
+		}
+	}
+
+	if len(updatedConnections) > 0 {
+		shardConnections[slot][channel] = updatedConnections
+		return
+	}
+	delete(shardConnections[slot], channel)
+	if len(shardConnections[slot]) == 0 {
+		delete(shardConnections, slot)
+	}
+}
+
+func sendMessageToShardChannel(channel string, message resp.RespValue) resp.RespValue {
+	conns := getShardConnections(channel)
+
+	for _, c := range conns {
+		sendMessageToConnection(c, message)
+	}
+
+	return generateIntegerResponse(len(conns))
+}
+
+func createShardMessage(channel string, message string) resp.RespValue {
+	return generateArrayResponse([]resp.RespValue{
+		generateBulkResponse("smessage"),
+		generateBulkResponse(channel),
+		generateBulkResponse(message),
+	})
+}
+
+func removeFromShardChannels(conn *connection.Connection) {
+	for _, c := range conn.ShardChannels() {
+		conn.ShardUnsubscribe(c)
+		removeFromShardChannel(conn, c)
+	}
+}
+
+func ssubscribe(h handlerArgs) handlerResponse {
+	for _, c := range h.args {
+		if h.conn.ShardSubscribe(c.Bulk) {
+			addToShardChannel(h.conn, c.Bulk)
+		}
+		sendMessageToConnection(h.conn, createSubscriptionMessage("ssubscribe", generateBulkResponse(c.Bulk), h.conn.ShardSubscriptionCount()))
+	}
+
+	return handlerResponse{
+		resp: generateVoidResponse(),
+	}
+}
+
+func sunsubscribe(h handlerArgs) handlerResponse {
+	unsubChannels := []string{}
+	if len(h.args) == 0 {
+		unsubChannels = h.conn.ShardChannels()
+	} else {
+		for _, c := range h.args {
+			unsubChannels = append(unsubChannels, c.Bulk)
+		}
+	}
+
+	if len(unsubChannels) == 0 {
+		sendMessageToConnection(h.conn, createSubscriptionMessage("sunsubscribe", generateNullResponse(), 0))
+	}
+
+	for _, c := range unsubChannels {
+		if h.conn.ShardUnsubscribe(c) {
+			removeFromShardChannel(h.conn, c)
+		}
+		sendMessageToConnection(h.conn, createSubscriptionMessage("sunsubscribe", generateBulkResponse(c), h.conn.ShardSubscriptionCount()))
+	}
+
+	return handlerResponse{
+		resp: generateVoidResponse(),
+	}
+}
+
+func spublish(h handlerArgs) handlerResponse {
+	channel := h.args[0].Bulk
+	message := h.args[1].Bulk
+
+	receivers := sendMessageToShardChannel(channel, createShardMessage(channel, message))
+	relayMessage(h, true, channel, message)
+
+	return handlerResponse{
+		resp: receivers,
+	}
+}
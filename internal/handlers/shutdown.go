@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+)
+
+// ErrShutdownFailed is the reply to a SHUTDOWN that was aborted or couldn't
+// complete, as in Redis
+var ErrShutdownFailed = errors.New("Errors trying to SHUTDOWN. Check logs.")
+
+var errShuttingDown = errors.New("Server is shutting down")
+
+// ShutdownRequest asks the main goroutine to shut the server down, either
+// from SHUTDOWN or a signal. Result receives an error if the shutdown was
+// aborted, and nothing if it succeeded, since the process exits.
+type ShutdownRequest struct {
+	Save   bool
+	NoSave bool
+	Now    bool
+	Force  bool
+	Result chan error
+}
+
+// ShutdownRequests is read by the main goroutine, which owns the listeners
+// and the store and so performs the shutdown
+var ShutdownRequests = make(chan ShutdownRequest)
+
+// Server wide shutdown state. Commands are counted while they run so that a
+// shutdown can wait for them, and idle is closed once none are left.
+var lifecycle = struct {
+	mutex        sync.Mutex
+	shuttingDown bool
+	inFlight     int
+	idle         chan struct{}
+	aborted      chan struct{}
+}{}
+
+// beginCommand registers a running command, unless a shutdown is pending in
+// which case no new commands are started
+func beginCommand() error {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	if lifecycle.shuttingDown {
+		return errShuttingDown
+	}
+	lifecycle.inFlight++
+	return nil
+}
+
+func endCommand() {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	lifecycle.inFlight--
+	if lifecycle.inFlight == 0 && lifecycle.idle != nil {
+		close(lifecycle.idle)
+		lifecycle.idle = nil
+	}
+}
+
+// BeginShutdown stops new commands from starting. Until CommitShutdown is
+// called the shutdown can still be aborted with SHUTDOWN ABORT.
+func BeginShutdown() {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	lifecycle.shuttingDown = true
+	lifecycle.aborted = make(chan struct{})
+}
+
+// CommitShutdown ends the window in which a shutdown can be aborted
+func CommitShutdown() {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	lifecycle.aborted = nil
+}
+
+// CancelShutdown lets commands run again after a shutdown was aborted or
+// failed
+func CancelShutdown() {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	lifecycle.shuttingDown = false
+	lifecycle.aborted = nil
+}
+
+func ShuttingDown() bool {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	return lifecycle.shuttingDown
+}
+
+// ShutdownAborted is closed when SHUTDOWN ABORT is run during a shutdown
+func ShutdownAborted() <-chan struct{} {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	return lifecycle.aborted
+}
+
+func abortShutdown() bool {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	if lifecycle.aborted == nil {
+		return false
+	}
+	close(lifecycle.aborted)
+	lifecycle.aborted = nil
+	return true
+}
+
+// CommandsFinished is closed once no commands are running
+func CommandsFinished() <-chan struct{} {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	if lifecycle.inFlight == 0 {
+		idle := make(chan struct{})
+		close(idle)
+		return idle
+	}
+	if lifecycle.idle == nil {
+		lifecycle.idle = make(chan struct{})
+	}
+	return lifecycle.idle
+}
+
+func CommandsRunning() int {
+	lifecycle.mutex.Lock()
+	defer lifecycle.mutex.Unlock()
+
+	return lifecycle.inFlight
+}
+
+// NotifySubscribers unsubscribes every subscriber from all its channels,
+// sending the usual unsubscribe messages so that clients know they won't
+// receive anything else. Clients may still be unsubscribing themselves, so
+// a message is only sent for channels this removes.
+func NotifySubscribers() {
+	for _, conn := range connection.All() {
+		for _, c := range conn.Channels() {
+			if !conn.Unsubscribe(c) {
+				continue
+			}
+			removeFromChannel(conn, c)
+			sendMessageToConnection(conn, createSubscriptionMessage("unsubscribe", generateBulkResponse(c), conn.SubscriptionCount()))
+		}
+		for _, c := range conn.ShardChannels() {
+			if !conn.ShardUnsubscribe(c) {
+				continue
+			}
+			removeFromShardChannel(conn, c)
+			sendMessageToConnection(conn, createSubscriptionMessage("sunsubscribe", generateBulkResponse(c), conn.ShardSubscriptionCount()))
+		}
+	}
+}
+
+func shutdown(h handlerArgs) handlerResponse {
+	request := ShutdownRequest{Result: make(chan error, 1)}
+	abort := false
+	for _, arg := range h.args {
+		switch strings.ToUpper(arg.Bulk) {
+		case "NOSAVE":
+			request.NoSave = true
+		case "SAVE":
+			request.Save = true
+		case "NOW":
+			request.Now = true
+		case "FORCE":
+			request.Force = true
+		case "ABORT":
+			abort = true
+		default:
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+	}
+
+	if request.Save && request.NoSave {
+		return handlerResponse{err: fmt.Errorf("syntax error")}
+	}
+
+	if abort {
+		if len(h.args) != 1 {
+			return handlerResponse{err: fmt.Errorf("syntax error")}
+		}
+		if !abortShutdown() {
+			return handlerResponse{err: fmt.Errorf("No shutdown in progress.")}
+		}
+		return handlerResponse{resp: generateStringResponse("OK")}
+	}
+
+	ShutdownRequests <- request
+	if err := <-request.Result; err != nil {
+		return handlerResponse{err: err}
+	}
+
+	// The server exits on success, so this is only reached if the process
+	// is somehow still running
+	return handlerResponse{resp: generateVoidResponse()}
+}
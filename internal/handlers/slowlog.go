@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+	"github.com/mmacdo54/go-redis-clone/internal/slowlog"
+)
+
+// Number of entries SLOWLOG GET returns without a count
+const SLOWLOG_DEFAULT_COUNT = 10
+
+// logSlowCommand adds a command to the slow log if it took long enough.
+// AUTH is never logged, since even redacted it says nothing useful.
+func logSlowCommand(conn *connection.Connection, command string, argv []resp.RespValue, duration time.Duration) {
+	if command == "AUTH" {
+		return
+	}
+	slowlog.Add(redactedArgs(argv), duration, conn.Addr(), conn.Name())
+}
+
+func slowlogCommand(h handlerArgs) handlerResponse {
+	subcommand := strings.ToUpper(h.args[0].Bulk)
+	args := h.args[1:]
+
+	switch subcommand {
+	case "GET":
+		return slowlogGet(args)
+	case "LEN":
+		return handlerResponse{resp: generateIntegerResponse(slowlog.Len())}
+	case "RESET":
+		slowlog.Reset()
+		return handlerResponse{resp: generateStringResponse("OK")}
+	default:
+		return handlerResponse{
+			err: unknownSubcommandError("slowlog", h.args[0].Bulk),
+		}
+	}
+}
+
+func slowlogGet(args []resp.RespValue) handlerResponse {
+	if len(args) > 1 {
+		return handlerResponse{err: fmt.Errorf("wrong number of arguments for 'slowlog|get' command")}
+	}
+
+	count := SLOWLOG_DEFAULT_COUNT
+	if len(args) == 1 {
+		c, err := strconv.Atoi(args[0].Bulk)
+		if err != nil || c < -1 {
+			return handlerResponse{err: fmt.Errorf("count should be greater than or equal to -1")}
+		}
+		count = c
+	}
+
+	entries := []resp.RespValue{}
+	for _, e := range slowlog.Entries(count) {
+		entryArgs := []resp.RespValue{}
+		for _, a := range e.Args {
+			entryArgs = append(entryArgs, generateBulkResponse(a))
+		}
+		entries = append(entries, generateArrayResponse([]resp.RespValue{
+			generateIntegerResponse(int(e.ID)),
+			generateIntegerResponse(int(e.Time.Unix())),
+			generateIntegerResponse(int(e.Duration.Microseconds())),
+			generateArrayResponse(entryArgs),
+			generateBulkResponse(e.ClientAddr),
+			generateBulkResponse(e.ClientName),
+		}))
+	}
+
+	return handlerResponse{
+		resp: generateArrayResponse(entries),
+	}
+}
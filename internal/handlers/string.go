@@ -2,17 +2,13 @@ package handlers
 
 import (
 	"fmt"
+	"slices"
 
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
 	"github.com/mmacdo54/go-redis-clone/internal/storage"
 )
 
 func set(h handlerArgs) handlerResponse {
-	if len(h.args) < 2 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of arguments for 'set' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
 	value := h.args[1].Bulk
 	var opts options
@@ -26,7 +22,14 @@ func set(h handlerArgs) handlerResponse {
 		opts = o
 	}
 
-	v, exists, err := h.store.GetByKey(storage.KV{Key: key})
+	tx, err := h.store.InitTransaction()
+	if err != nil {
+		return handlerResponse{
+			err: err,
+		}
+	}
+
+	v, exists, err := h.store.GetForUpdate(storage.KV{Key: key}, tx)
 
 	if err != nil {
 		return handlerResponse{
@@ -35,12 +38,14 @@ func set(h handlerArgs) handlerResponse {
 	}
 
 	if opts.nx && exists {
+		tx.Abort()
 		return handlerResponse{
 			resp: generateNullResponse(),
 		}
 	}
 
 	if opts.xx && !exists {
+		tx.Abort()
 		return handlerResponse{
 			resp: generateNullResponse(),
 		}
@@ -65,10 +70,10 @@ func set(h handlerArgs) handlerResponse {
 		}
 	}
 
-	tx, err := h.store.InitTransaction()
-	if err != nil {
+	if opts.get && exists && v.Typ != STRING {
+		tx.Abort()
 		return handlerResponse{
-			err: err,
+			err: ErrWrongType,
 		}
 	}
 
@@ -78,44 +83,40 @@ func set(h handlerArgs) handlerResponse {
 		}
 	}
 
+	if err := tx.Commit(); err != nil {
+		return handlerResponse{
+			err: err,
+		}
+	}
+
+	signalModifiedKey(h, key)
+	if !exists {
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_NEW, "new", key)
+	}
+	notifyKeyspaceEvent(h.config, configuration.NOTIFY_STRING, "set", key)
+	if kv.Exp > 0 && !opts.keepttl {
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_GENERIC, "expire", key)
+	}
+
 	if opts.get && !exists {
 		return handlerResponse{
 			resp: generateNullResponse(),
 		}
 	}
 	if opts.get {
-		if v.Typ != STRING {
-			return handlerResponse{
-				err: fmt.Errorf("value stored at key is not a string"),
-			}
-		}
 		return handlerResponse{
 			resp: generateBulkResponse(v.Str),
 		}
 	}
 
-	if err := tx.Commit(); err != nil {
-		return handlerResponse{
-			err: err,
-		}
-	}
-
 	return handlerResponse{
 		resp: generateStringResponse("OK"),
 	}
 }
 
 func get(h handlerArgs) handlerResponse {
-	if len(h.args) != 1 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of arguments for 'get' command"),
-		}
-	}
-
 	key := h.args[0].Bulk
-	kv := storage.KV{Key: key}
-
-	v, exists, err := h.store.GetByKey(kv)
+	v, exists, err := lookupKeyRead(h, key)
 
 	if err != nil {
 		return handlerResponse{
@@ -131,7 +132,7 @@ func get(h handlerArgs) handlerResponse {
 
 	if v.Typ != STRING {
 		return handlerResponse{
-			err: fmt.Errorf("value stored at key is not a string"),
+			err: ErrWrongType,
 		}
 	}
 
@@ -141,19 +142,13 @@ func get(h handlerArgs) handlerResponse {
 }
 
 func del(h handlerArgs) handlerResponse {
-	if len(h.args) == 0 {
-		return handlerResponse{
-			err: fmt.Errorf("no keys passed to 'del' command"),
-		}
-	}
-
 	tx, err := h.store.InitTransaction()
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
-	count := 0
+	deleted := []string{}
 	for _, k := range h.args {
 		dc, err := h.store.DeleteByKey(storage.KV{Key: k.Bulk}, tx)
 
@@ -164,7 +159,7 @@ func del(h handlerArgs) handlerResponse {
 		}
 
 		if dc == 1 {
-			count++
+			deleted = append(deleted, k.Bulk)
 		}
 	}
 
@@ -174,46 +169,65 @@ func del(h handlerArgs) handlerResponse {
 		}
 	}
 
+	for _, k := range deleted {
+		signalModifiedKey(h, k)
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_GENERIC, "del", k)
+	}
+
 	return handlerResponse{
-		resp: generateIntegerResponse(count),
+		resp: generateIntegerResponse(len(deleted)),
 	}
 }
 
-func copy(h handlerArgs) handlerResponse {
-	if len(h.args) == 0 {
-		return handlerResponse{
-			err: fmt.Errorf("wrong number of commands passed to 'copy' command"),
-		}
-	}
+var errSameObject = fmt.Errorf("source and destination objects are the same")
 
+func copy(h handlerArgs) handlerResponse {
 	key := h.args[0].Bulk
 	newKey := h.args[1].Bulk
-	o := parseCopyOptions(h.args)
-
-	current, oldExists, err := h.store.GetByKey(storage.KV{Key: key})
+	o, err := parseCopyOptions(h.args[2:])
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
+	if key == newKey {
+		return handlerResponse{
+			err: errSameObject,
+		}
+	}
 
-	_, newExists, err := h.store.GetByKey(storage.KV{Key: newKey})
+	tx, err := h.store.InitTransaction()
 	if err != nil {
 		return handlerResponse{
 			err: err,
 		}
 	}
 
-	if !oldExists || newExists {
-		return handlerResponse{
-			resp: generateIntegerResponse(0),
+	// Keys are locked in order so that two COPYs between the same keys in
+	// opposite directions can't deadlock
+	keys := []string{key, newKey}
+	slices.Sort(keys)
+	found := map[string]storage.KV{}
+	for _, k := range keys {
+		v, exists, err := h.store.GetForUpdate(storage.KV{Key: k}, tx)
+		if err != nil {
+			return handlerResponse{
+				err: err,
+			}
+		}
+		if exists {
+			found[k] = v
 		}
 	}
 
-	tx, err := h.store.InitTransaction()
-	if err != nil {
+	// The source is only read. REPLACE lets the copy overwrite an existing
+	// destination, which SetKV replaces whatever its type.
+	current, oldExists := found[key]
+	_, newExists := found[newKey]
+	if !oldExists || (newExists && !o.replace) {
+		tx.Abort()
 		return handlerResponse{
-			err: err,
+			resp: generateIntegerResponse(0),
 		}
 	}
 
@@ -223,13 +237,6 @@ func copy(h handlerArgs) handlerResponse {
 			err: err,
 		}
 	}
-	if o.replace {
-		if _, err := h.store.DeleteByKey(storage.KV{Key: key}, tx); err != nil {
-			return handlerResponse{
-				err: err,
-			}
-		}
-	}
 
 	if err := tx.Commit(); err != nil {
 		return handlerResponse{
@@ -237,6 +244,12 @@ func copy(h handlerArgs) handlerResponse {
 		}
 	}
 
+	signalModifiedKey(h, newKey)
+	if !newExists {
+		notifyKeyspaceEvent(h.config, configuration.NOTIFY_NEW, "new", newKey)
+	}
+	notifyKeyspaceEvent(h.config, configuration.NOTIFY_GENERIC, "copy_to", newKey)
+
 	return handlerResponse{
 		resp: generateIntegerResponse(1),
 	}
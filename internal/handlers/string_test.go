@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+// newCopyServer starts a server publishing generic and new key events, with
+// a subscriber to the events COPY can cause
+func newCopyServer(t *testing.T) (*testServer, *testSubscriber) {
+	s := newTestServer(t, "--notify-keyspace-events", "Eg$n")
+	sub := s.subscriber("__keyevent@0__:new", "__keyevent@0__:copy_to", "__keyevent@0__:del", "__keyevent@0__:set")
+	return s, sub
+}
+
+func TestCopy(t *testing.T) {
+	s, sub := newCopyServer(t)
+	c := s.client()
+	c.do("SET", "src", "v", "EX", "100")
+	expires := c.do("EXPIRETIME", "src")
+	sub.received()
+
+	if got, want := c.do("COPY", "src", "dst"), ":1\r\n"; got != want {
+		t.Errorf("COPY replied %q, want %q", got, want)
+	}
+	for _, key := range []string{"src", "dst"} {
+		if got, want := c.do("GET", key), "$1\r\nv\r\n"; got != want {
+			t.Errorf("GET %s replied %q after COPY, want %q", key, got, want)
+		}
+		if got := c.do("EXPIRETIME", key); got != expires {
+			t.Errorf("EXPIRETIME %s replied %q after COPY, want the source's %q", key, got, expires)
+		}
+	}
+	want := [][2]string{{"__keyevent@0__:new", "dst"}, {"__keyevent@0__:copy_to", "dst"}}
+	if got := sub.received(); !slices.Equal(got, want) {
+		t.Errorf("COPY published %q, want %q", got, want)
+	}
+}
+
+// Without REPLACE an existing destination is left alone
+func TestCopyExisting(t *testing.T) {
+	s, sub := newCopyServer(t)
+	c := s.client()
+	c.do("SET", "src", "v")
+	c.do("SET", "dst", "old")
+	sub.received()
+
+	if got, want := c.do("COPY", "src", "dst"), ":0\r\n"; got != want {
+		t.Errorf("COPY replied %q, want %q", got, want)
+	}
+	if got, want := c.do("GET", "dst"), "$3\r\nold\r\n"; got != want {
+		t.Errorf("GET dst replied %q, want %q", got, want)
+	}
+	if got := sub.received(); len(got) != 0 {
+		t.Errorf("COPY that did nothing published %q", got)
+	}
+}
+
+// REPLACE overwrites the destination, whatever its type, and never touches
+// the source
+func TestCopyReplace(t *testing.T) {
+	s, sub := newCopyServer(t)
+	c := s.client()
+	c.do("SADD", "src", "a", "b")
+	c.do("RPUSH", "dst", "x")
+	sub.received()
+
+	if got, want := c.do("COPY", "src", "dst", "REPLACE"), ":1\r\n"; got != want {
+		t.Errorf("COPY REPLACE replied %q, want %q", got, want)
+	}
+	for _, key := range []string{"src", "dst"} {
+		if got, want := c.do("SISMEMBER", key, "b"), ":1\r\n"; got != want {
+			t.Errorf("SISMEMBER %s b replied %q after COPY REPLACE, want %q", key, got, want)
+		}
+	}
+	want := [][2]string{{"__keyevent@0__:copy_to", "dst"}}
+	if got := sub.received(); !slices.Equal(got, want) {
+		t.Errorf("COPY REPLACE published %q, want %q", got, want)
+	}
+
+	// A missing destination is created, and the source still kept
+	if got, want := c.do("COPY", "src", "other", "replace"), ":1\r\n"; got != want {
+		t.Errorf("COPY REPLACE to a new key replied %q, want %q", got, want)
+	}
+	if got, want := c.do("EXISTS", "src", "other"), ":2\r\n"; got != want {
+		t.Errorf("EXISTS replied %q after COPY REPLACE to a new key, want %q", got, want)
+	}
+	want = [][2]string{{"__keyevent@0__:new", "other"}, {"__keyevent@0__:copy_to", "other"}}
+	if got := sub.received(); !slices.Equal(got, want) {
+		t.Errorf("COPY REPLACE to a new key published %q, want %q", got, want)
+	}
+}
+
+func TestCopyMissingOrSame(t *testing.T) {
+	c := newTestServer(t).client()
+	if got, want := c.do("COPY", "nosuch", "dst", "REPLACE"), ":0\r\n"; got != want {
+		t.Errorf("COPY of a missing key replied %q, want %q", got, want)
+	}
+
+	c.do("SET", "k", "v")
+	if got, want := c.do("COPY", "k", "k", "REPLACE"), "-ERR source and destination objects are the same\r\n"; got != want {
+		t.Errorf("COPY of a key onto itself replied %q, want %q", got, want)
+	}
+	if got, want := c.do("GET", "k"), "$1\r\nv\r\n"; got != want {
+		t.Errorf("GET replied %q after COPY onto itself, want %q", got, want)
+	}
+}
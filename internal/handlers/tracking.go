@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/resp"
+)
+
+const INVALIDATE_CHANNEL = "__redis__:invalidate"
+
+// Keys read by clients in default tracking mode, and the prefixes registered
+// by clients in BCAST mode, mapped to the IDs of the interested clients.
+var trackingTable = map[string]map[int64]struct{}{}
+var trackingPrefixes = map[string]map[int64]struct{}{}
+var trackingMutex = sync.Mutex{}
+
+func enableTracking(conn *connection.Connection, t connection.Tracking) {
+	trackingMutex.Lock()
+	defer trackingMutex.Unlock()
+
+	removeTrackingPrefixes(conn)
+	if t.BCast {
+		for _, p := range t.Prefixes {
+			if _, ok := trackingPrefixes[p]; !ok {
+				trackingPrefixes[p] = map[int64]struct{}{}
+			}
+			trackingPrefixes[p][conn.ID] = struct{}{}
+		}
+	}
+	conn.SetTracking(t)
+}
+
+func disableTracking(conn *connection.Connection) {
+	trackingMutex.Lock()
+	defer trackingMutex.Unlock()
+
+	removeTrackingPrefixes(conn)
+	conn.SetTracking(connection.Tracking{})
+}
+
+// removeTrackingPrefixes expects trackingMutex to be held
+func removeTrackingPrefixes(conn *connection.Connection) {
+	t := conn.Tracking()
+	if !t.BCast {
+		return
+	}
+
+	for _, p := range t.Prefixes {
+		delete(trackingPrefixes[p], conn.ID)
+		if len(trackingPrefixes[p]) == 0 {
+			delete(trackingPrefixes, p)
+		}
+	}
+}
+
+// resetTrackingCaching clears a CLIENT CACHING yes|no once the command
+// following it has run.
+func resetTrackingCaching(conn *connection.Connection) {
+	t := conn.Tracking()
+	if !t.CachingYes && !t.CachingNo {
+		return
+	}
+	t.CachingYes = false
+	t.CachingNo = false
+	conn.SetTracking(t)
+}
+
+// trackKeyRead remembers that a client in default tracking mode has read a
+// key so it can be told when the key changes.
+func trackKeyRead(h handlerArgs, key string) {
+	t := h.conn.Tracking()
+	if !t.Enabled || t.BCast {
+		return
+	}
+	if t.OptIn && !t.CachingYes {
+		return
+	}
+	if t.OptOut && t.CachingNo {
+		return
+	}
+
+	trackingMutex.Lock()
+	defer trackingMutex.Unlock()
+
+	if _, ok := trackingTable[key]; !ok {
+		trackingTable[key] = map[int64]struct{}{}
+	}
+	trackingTable[key][h.conn.ID] = struct{}{}
+}
+
+// signalModifiedKey must be called by every command that changes a key
+func signalModifiedKey(h handlerArgs, key string) {
+	invalidateKey(key, h.conn)
+}
+
+// invalidateKey sends an invalidation message to every client tracking the
+// key. origin is the client that modified it, or nil if the server did.
+func invalidateKey(key string, origin *connection.Connection) {
+	trackingMutex.Lock()
+	ids := trackingTable[key]
+	delete(trackingTable, key)
+
+	targets := map[int64]struct{}{}
+	for id := range ids {
+		targets[id] = struct{}{}
+	}
+	for p, prefixIDs := range trackingPrefixes {
+		if strings.HasPrefix(key, p) {
+			for id := range prefixIDs {
+				targets[id] = struct{}{}
+			}
+		}
+	}
+	trackingMutex.Unlock()
+
+	for id := range targets {
+		conn, ok := connection.Get(id)
+		if !ok {
+			continue
+		}
+
+		t := conn.Tracking()
+		if !t.Enabled || (t.NoLoop && conn == origin) {
+			continue
+		}
+
+		sendInvalidation(conn, t, []string{key})
+	}
+}
+
+// invalidateAll tells every tracking client that any key may have changed
+func invalidateAll(origin *connection.Connection) {
+	trackingMutex.Lock()
+	trackingTable = map[string]map[int64]struct{}{}
+	trackingMutex.Unlock()
+
+	for _, conn := range connection.All() {
+		t := conn.Tracking()
+		if !t.Enabled || (t.NoLoop && conn == origin) {
+			continue
+		}
+
+		sendInvalidation(conn, t, nil)
+	}
+}
+
+// sendInvalidation sends a null key list when keys is nil, meaning that all
+// keys have been invalidated.
+func sendInvalidation(conn *connection.Connection, t connection.Tracking, keys []string) {
+	target := conn
+	if t.Redirect != 0 {
+		r, ok := connection.Get(t.Redirect)
+		if !ok {
+			if conn.Protocol() == 3 {
+				conn.Write(resp.RespValue{Type: resp.TYPE_PUSH, Array: []resp.RespValue{
+					generateBulkResponse("tracking-redir-broken"),
+					generateIntegerResponse(int(t.Redirect)),
+				}})
+			}
+			return
+		}
+		target = r
+	}
+
+	invalidated := generateNullResponse()
+	if keys != nil {
+		arr := []resp.RespValue{}
+		for _, k := range keys {
+			arr = append(arr, generateBulkResponse(k))
+		}
+		invalidated = generateArrayResponse(arr)
+	}
+
+	if target.Protocol() == 3 {
+		target.Write(resp.RespValue{Type: resp.TYPE_PUSH, Array: []resp.RespValue{
+			generateBulkResponse("invalidate"),
+			invalidated,
+		}})
+		return
+	}
+
+	// RESP2 clients can only receive invalidations through a redirect to a
+	// client subscribed to the invalidation channel
+	if t.Redirect == 0 || !isInChannel(target, INVALIDATE_CHANNEL) {
+		return
+	}
+	target.Write(generateArrayResponse([]resp.RespValue{
+		generateBulkResponse("message"),
+		generateBulkResponse(INVALIDATE_CHANNEL),
+		invalidated,
+	}))
+}
@@ -0,0 +1,211 @@
+package latency
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Events sampled by the latency monitor. Storage calls are reported as
+// storage-<op>, e.g. storage-get or storage-transaction.
+const (
+	EVENT_COMMAND      = "command"
+	EVENT_FAST_COMMAND = "fast-command"
+	EVENT_EXPIRE_CYCLE = "expire-cycle"
+	EVENT_SNAPSHOT     = "snapshot"
+	EVENT_STORAGE      = "storage-"
+)
+
+// Samples kept per event, as in Redis
+const HISTORY_LEN = 160
+
+// Sample is the worst latency of an event within one second
+type Sample struct {
+	Time    int64
+	Latency int
+}
+
+type event struct {
+	history []Sample
+	max     int
+}
+
+var (
+	events    = map[string]*event{}
+	threshold time.Duration
+	mutex     sync.Mutex
+)
+
+// SetThreshold applies latency-monitor-threshold in milliseconds. 0 turns
+// the monitor off.
+func SetThreshold(ms int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	threshold = time.Duration(ms) * time.Millisecond
+}
+
+// AddSample records an event that took at least latency-monitor-threshold.
+// Samples within the same second are merged, keeping the worst.
+func AddSample(name string, duration time.Duration) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if threshold == 0 || duration < threshold {
+		return
+	}
+
+	e, ok := events[name]
+	if !ok {
+		e = &event{}
+		events[name] = e
+	}
+
+	ms := int(duration.Milliseconds())
+	now := time.Now().Unix()
+	e.max = max(e.max, ms)
+	if n := len(e.history); n > 0 && e.history[n-1].Time == now {
+		e.history[n-1].Latency = max(e.history[n-1].Latency, ms)
+		return
+	}
+	e.history = append(e.history, Sample{Time: now, Latency: ms})
+	if len(e.history) > HISTORY_LEN {
+		e.history = e.history[1:]
+	}
+}
+
+// Latest is an event's most recent sample and its all time maximum
+type Latest struct {
+	Name   string
+	Time   int64
+	Latest int
+	Max    int
+}
+
+func LatestSamples() []Latest {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	latest := []Latest{}
+	for _, name := range eventNames() {
+		e := events[name]
+		last := e.history[len(e.history)-1]
+		latest = append(latest, Latest{Name: name, Time: last.Time, Latest: last.Latency, Max: e.max})
+	}
+	return latest
+}
+
+func History(name string) []Sample {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	e, ok := events[name]
+	if !ok {
+		return nil
+	}
+	return append([]Sample(nil), e.history...)
+}
+
+// Reset drops the named events, or all of them if none are named, and
+// returns how many were dropped
+func Reset(names ...string) int {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if len(names) == 0 {
+		n := len(events)
+		events = map[string]*event{}
+		return n
+	}
+
+	n := 0
+	for _, name := range names {
+		if _, ok := events[name]; ok {
+			delete(events, name)
+			n++
+		}
+	}
+	return n
+}
+
+func eventNames() []string {
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Doctor describes the recorded spikes in plain English with advice on what
+// to look at, like Redis' LATENCY DOCTOR
+func Doctor() string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	var report strings.Builder
+	if threshold == 0 && len(events) == 0 {
+		report.WriteString("I'm sorry, Dave, I can't do that. Latency monitoring is disabled in this Redis instance. You may use \"CONFIG SET latency-monitor-threshold <milliseconds>.\" in order to enable it.\n")
+		return report.String()
+	}
+	if len(events) == 0 {
+		report.WriteString("Dave, no latency spike was observed during the lifetime of this Redis instance, not in the slightest bit. I honestly think you ought to sleep tonight.\n")
+		return report.String()
+	}
+
+	report.WriteString("Dave, I have observed latency spikes in this Redis instance. You don't mind talking about it, do you Dave?\n\n")
+	advice := []string{}
+	for i, name := range eventNames() {
+		e := events[name]
+		sum := 0
+		for _, s := range e.history {
+			sum += s.Latency
+		}
+		avg := sum / len(e.history)
+		deviation := 0
+		for _, s := range e.history {
+			deviation += abs(s.Latency - avg)
+		}
+		deviation /= len(e.history)
+		period := int64(0)
+		if len(e.history) > 1 {
+			period = (e.history[len(e.history)-1].Time - e.history[0].Time) / int64(len(e.history)-1)
+		}
+
+		fmt.Fprintf(&report, "%d. %s: %d latency spikes (average %dms, mean deviation %dms, period %d sec). Worst all time event %dms.\n", i+1, name, len(e.history), avg, deviation, period, e.max)
+
+		if a := eventAdvice(name); a != "" && !slices.Contains(advice, a) {
+			advice = append(advice, a)
+		}
+	}
+
+	report.WriteString("\nI have a few advices for you:\n\n")
+	for _, a := range advice {
+		fmt.Fprintf(&report, "- %s\n", a)
+	}
+	return report.String()
+}
+
+func eventAdvice(name string) string {
+	switch {
+	case name == EVENT_COMMAND || name == EVENT_FAST_COMMAND:
+		return "Check your SLOWLOG to see which commands are slow, and if they are waiting on storage calls, which are sampled as storage-* events."
+	case name == EVENT_EXPIRE_CYCLE:
+		return "Deleting expired keys is slow. Many keys expiring at the same time, or a slow storage backend, can cause this."
+	case name == EVENT_SNAPSHOT:
+		return "Saving the snapshot is slow. Check the disk the snapshot is written to."
+	case name == EVENT_STORAGE+"transaction":
+		return "Storage transactions are held open for long. This usually means lock contention between clients writing the same keys, or slow round trips inside the transaction."
+	case strings.HasPrefix(name, EVENT_STORAGE):
+		return "Storage calls are slow. Check the load on the storage backend and the network latency to it."
+	}
+	return ""
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
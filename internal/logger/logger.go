@@ -0,0 +1,106 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Log levels, matching the loglevel values accepted in redis.conf
+const (
+	DEBUG = iota
+	VERBOSE
+	NOTICE
+	WARNING
+	NOTHING
+)
+
+var Levels = map[string]int{
+	"debug":   DEBUG,
+	"verbose": VERBOSE,
+	"notice":  NOTICE,
+	"warning": WARNING,
+	"nothing": NOTHING,
+}
+
+var levelMarks = map[int]string{
+	DEBUG:   ".",
+	VERBOSE: "-",
+	NOTICE:  "*",
+	WARNING: "#",
+}
+
+var level = NOTICE
+var output io.Writer = os.Stdout
+var mutex = sync.Mutex{}
+
+// Init sets the minimum level that is logged and the file logs are appended
+// to. An empty file logs to stdout.
+func Init(logLevel string, file string) error {
+	l, ok := Levels[logLevel]
+	if !ok {
+		return fmt.Errorf("Invalid log level '%s'", logLevel)
+	}
+
+	var w io.Writer = os.Stdout
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	level = l
+	output = w
+	return nil
+}
+
+// SetLevel changes the minimum level that is logged, e.g. after CONFIG SET
+func SetLevel(logLevel string) error {
+	l, ok := Levels[logLevel]
+	if !ok {
+		return fmt.Errorf("Invalid log level '%s'", logLevel)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	level = l
+	return nil
+}
+
+func log(l int, format string, args ...any) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if l < level {
+		return
+	}
+
+	fmt.Fprintf(output, "%d:M %s %s %s\n",
+		os.Getpid(),
+		time.Now().Format("02 Jan 2006 15:04:05.000"),
+		levelMarks[l],
+		fmt.Sprintf(format, args...),
+	)
+}
+
+func Debug(format string, args ...any) {
+	log(DEBUG, format, args...)
+}
+
+func Verbose(format string, args ...any) {
+	log(VERBOSE, format, args...)
+}
+
+func Notice(format string, args ...any) {
+	log(NOTICE, format, args...)
+}
+
+func Warning(format string, args ...any) {
+	log(WARNING, format, args...)
+}
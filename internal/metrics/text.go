@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Writer writes metrics in the Prometheus text exposition format. The
+// samples of a metric must be written one after another, since its HELP and
+// TYPE lines are only written before the first one.
+type Writer struct {
+	strings.Builder
+	described map[string]bool
+}
+
+func NewWriter() *Writer {
+	return &Writer{described: map[string]bool{}}
+}
+
+// Counter writes a counter sample. Labels are alternating names and values.
+func (w *Writer) Counter(name string, help string, value float64, labels ...string) {
+	w.describe(name, help, "counter")
+	w.sample(name, labels, value)
+}
+
+func (w *Writer) Gauge(name string, help string, value float64, labels ...string) {
+	w.describe(name, help, "gauge")
+	w.sample(name, labels, value)
+}
+
+// Histogram writes the buckets of a latency histogram. counts are per
+// bucket and are made cumulative here, as Prometheus expects.
+func (w *Writer) Histogram(name string, help string, bounds []time.Duration, counts []int64, count int64, sum time.Duration, labels ...string) {
+	w.describe(name, help, "histogram")
+
+	cumulative := int64(0)
+	for i, bound := range bounds {
+		if i < len(counts) {
+			cumulative += counts[i]
+		}
+		w.sample(name+"_bucket", slices.Concat(labels, []string{"le", formatValue(bound.Seconds())}), float64(cumulative))
+	}
+	w.sample(name+"_bucket", slices.Concat(labels, []string{"le", "+Inf"}), float64(count))
+	w.sample(name+"_sum", labels, sum.Seconds())
+	w.sample(name+"_count", labels, float64(count))
+}
+
+func (w *Writer) describe(name string, help string, kind string) {
+	if w.described[name] {
+		return
+	}
+	w.described[name] = true
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func (w *Writer) sample(name string, labels []string, value float64) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		pairs := []string{}
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabel(labels[i+1])))
+		}
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	w.WriteString(" " + formatValue(value) + "\n")
+}
+
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -2,9 +2,10 @@ package resp
 
 import (
 	"bufio"
-	"fmt"
 	"io"
 	"strconv"
+
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
 )
 
 type RespReader struct {
@@ -15,6 +16,16 @@ func NewRespReader(reader io.Reader) *RespReader {
 	return &RespReader{reader: bufio.NewReader(reader)}
 }
 
+// Buffered is the number of bytes read from the client but not parsed yet
+func (r *RespReader) Buffered() int {
+	return r.reader.Buffered()
+}
+
+// Size is the size of the read buffer
+func (r *RespReader) Size() int {
+	return r.reader.Size()
+}
+
 func (r *RespReader) ReadResp() (RespValue, error) {
 	t, err := r.reader.ReadByte()
 	if err != nil {
@@ -27,7 +38,7 @@ func (r *RespReader) ReadResp() (RespValue, error) {
 	case BULK:
 		return r.readBulk()
 	default:
-		fmt.Printf("Unknown type: %v", string(t))
+		logger.Warning("Unknown RESP type: %v", string(t))
 		return RespValue{}, nil
 	}
 }
@@ -7,4 +7,6 @@ const (
 	BULK    = '$'
 	ARRAY   = '*'
 	SET     = '~'
+	MAP     = '%'
+	PUSH    = '>'
 )
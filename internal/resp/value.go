@@ -10,6 +10,8 @@ const (
 	TYPE_STRING  = "string"
 	TYPE_NULL    = "null"
 	TYPE_SET     = "set"
+	TYPE_MAP     = "map"
+	TYPE_PUSH    = "push"
 	TYPE_VOID    = "void"
 )
 
@@ -41,6 +43,10 @@ func (v RespValue) Marshall() []byte {
 		return v.marshalNull()
 	case TYPE_SET:
 		return v.marshallSet()
+	case TYPE_MAP:
+		return v.marshalMap()
+	case TYPE_PUSH:
+		return v.marshalPush()
 	default:
 		return []byte{}
 	}
@@ -109,3 +115,30 @@ func (v RespValue) marshallSet() (res []byte) {
 
 	return
 }
+
+// marshalMap expects Array to hold alternating keys and values
+func (v RespValue) marshalMap() (res []byte) {
+	res = append(res, MAP)
+	res = append(res, strconv.Itoa(len(v.Array)/2)...)
+	addRespReturn(&res)
+
+	for _, val := range v.Array {
+		bytes := val.Marshall()
+		res = append(res, bytes...)
+	}
+
+	return
+}
+
+func (v RespValue) marshalPush() (res []byte) {
+	res = append(res, PUSH)
+	res = append(res, strconv.Itoa(len(v.Array))...)
+	addRespReturn(&res)
+
+	for _, val := range v.Array {
+		bytes := val.Marshall()
+		res = append(res, bytes...)
+	}
+
+	return
+}
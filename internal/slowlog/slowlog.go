@@ -0,0 +1,113 @@
+package slowlog
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Entries keep at most this many arguments, and this many bytes of each, as
+// in Redis
+const (
+	MAX_ARGC   = 32
+	MAX_STRING = 128
+)
+
+// Entry is a command that took longer than slowlog-log-slower-than
+type Entry struct {
+	ID         int64
+	Time       time.Time
+	Duration   time.Duration
+	Args       []string
+	ClientAddr string
+	ClientName string
+}
+
+var (
+	entries    []Entry
+	slowerThan = 10 * time.Millisecond
+	maxLen     = 128
+	lastID     int64
+	mutex      sync.Mutex
+)
+
+// SetOptions applies slowlog-log-slower-than, in microseconds with a
+// negative value disabling the log, and slowlog-max-len
+func SetOptions(slowerThanMicros int, max int) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if slowerThanMicros < 0 {
+		slowerThan = -1
+	} else {
+		slowerThan = time.Duration(slowerThanMicros) * time.Microsecond
+	}
+	maxLen = max
+	if len(entries) > maxLen {
+		entries = entries[:maxLen]
+	}
+}
+
+// Add records a command if it was slow enough, newest first
+func Add(args []string, duration time.Duration, clientAddr string, clientName string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if slowerThan < 0 || duration < slowerThan || maxLen == 0 {
+		return
+	}
+
+	entry := Entry{
+		ID:         lastID,
+		Time:       time.Now(),
+		Duration:   duration,
+		Args:       truncateArgs(args),
+		ClientAddr: clientAddr,
+		ClientName: clientName,
+	}
+	lastID++
+
+	entries = append([]Entry{entry}, entries...)
+	if len(entries) > maxLen {
+		entries = entries[:maxLen]
+	}
+}
+
+func truncateArgs(args []string) []string {
+	truncated := []string{}
+	for i, arg := range args {
+		if i == MAX_ARGC-1 && len(args) > MAX_ARGC {
+			truncated = append(truncated, fmt.Sprintf("... (%d more arguments)", len(args)-i))
+			break
+		}
+		if len(arg) > MAX_STRING {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:MAX_STRING], len(arg)-MAX_STRING)
+		}
+		truncated = append(truncated, arg)
+	}
+	return truncated
+}
+
+// Entries returns up to count of the newest entries, or all of them if count
+// is negative
+func Entries(count int) []Entry {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if count < 0 || count > len(entries) {
+		count = len(entries)
+	}
+	return append([]Entry(nil), entries[:count]...)
+}
+
+func Len() int {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return len(entries)
+}
+
+func Reset() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	entries = nil
+}
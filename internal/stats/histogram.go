@@ -0,0 +1,52 @@
+package stats
+
+import "time"
+
+// Upper bounds of the latency histogram buckets, from 10µs to 10s
+var LatencyBuckets = []time.Duration{
+	10 * time.Microsecond,
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Histogram counts durations in LatencyBuckets. Counts are per bucket, not
+// cumulative, and anything slower than the last bucket is only in Count.
+type Histogram struct {
+	Buckets []int64
+	Count   int64
+	Sum     time.Duration
+}
+
+func (h *Histogram) observe(d time.Duration) {
+	if h.Buckets == nil {
+		h.Buckets = make([]int64, len(LatencyBuckets))
+	}
+	for i, bound := range LatencyBuckets {
+		if d <= bound {
+			h.Buckets[i]++
+			break
+		}
+	}
+	h.Count++
+	h.Sum += d
+}
+
+func (h Histogram) copy() Histogram {
+	h.Buckets = append([]int64(nil), h.Buckets...)
+	return h
+}
@@ -0,0 +1,169 @@
+package stats
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of samples averaged for instantaneous_ops_per_sec, as in Redis
+const OPS_SAMPLES = 16
+
+var StartedAt = time.Now()
+
+// Server wide counters reported by INFO. CONFIG RESETSTAT sets them back to
+// zero.
+var (
+	ConnectionsReceived atomic.Int64
+	RejectedConnections atomic.Int64
+	CommandsProcessed   atomic.Int64
+	ErrorReplies        atomic.Int64
+	KeyspaceHits        atomic.Int64
+	KeyspaceMisses      atomic.Int64
+	ExpiredKeys         atomic.Int64
+	EvictedKeys         atomic.Int64
+)
+
+// Command holds the INFO commandstats counters of one command
+type Command struct {
+	Calls         int64
+	Duration      time.Duration
+	RejectedCalls int64
+	FailedCalls   int64
+	Latency       Histogram
+}
+
+var commands = map[string]*Command{}
+var commandsMutex = sync.Mutex{}
+
+var samples = struct {
+	mutex      sync.Mutex
+	ops        [OPS_SAMPLES]float64
+	index      int
+	lastTime   time.Time
+	lastOps    int64
+	memoryPeak uint64
+}{}
+
+// RecordCommand counts a command that ran, whether or not it returned an
+// error
+func RecordCommand(name string, duration time.Duration, failed bool) {
+	CommandsProcessed.Add(1)
+	if failed {
+		ErrorReplies.Add(1)
+	}
+
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+
+	c := command(name)
+	c.Calls++
+	c.Duration += duration
+	c.Latency.observe(duration)
+	if failed {
+		c.FailedCalls++
+	}
+}
+
+// RecordRejected counts a command that was refused before it could run, e.g.
+// by an ACL check. Unknown commands have no name and only count as errors.
+func RecordRejected(name string) {
+	ErrorReplies.Add(1)
+	if name == "" {
+		return
+	}
+
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+
+	command(name).RejectedCalls++
+}
+
+func command(name string) *Command {
+	c, ok := commands[name]
+	if !ok {
+		c = &Command{}
+		commands[name] = c
+	}
+	return c
+}
+
+// Commands returns a copy of the counters of every command that has been
+// called since the last reset
+func Commands() map[string]Command {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+
+	copied := make(map[string]Command, len(commands))
+	for name, c := range commands {
+		command := *c
+		command.Latency = c.Latency.copy()
+		copied[name] = command
+	}
+	return copied
+}
+
+// Sample records the rate of commands since the previous sample and the peak
+// memory usage. It is called from serverCron.
+func Sample() {
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+
+	samples.mutex.Lock()
+	defer samples.mutex.Unlock()
+
+	now := time.Now()
+	ops := CommandsProcessed.Load()
+	if !samples.lastTime.IsZero() {
+		elapsed := now.Sub(samples.lastTime).Seconds()
+		if elapsed > 0 {
+			samples.ops[samples.index%OPS_SAMPLES] = float64(ops-samples.lastOps) / elapsed
+			samples.index++
+		}
+	}
+	samples.lastTime = now
+	samples.lastOps = ops
+	samples.memoryPeak = max(samples.memoryPeak, m.HeapAlloc)
+}
+
+func InstantaneousOps() int {
+	samples.mutex.Lock()
+	defer samples.mutex.Unlock()
+
+	sum := 0.0
+	for _, ops := range samples.ops {
+		sum += ops
+	}
+	return int(sum / OPS_SAMPLES)
+}
+
+// MemoryPeak is the highest memory usage seen so far, including the current
+// usage passed in
+func MemoryPeak(used uint64) uint64 {
+	samples.mutex.Lock()
+	defer samples.mutex.Unlock()
+
+	samples.memoryPeak = max(samples.memoryPeak, used)
+	return samples.memoryPeak
+}
+
+// Reset implements CONFIG RESETSTAT. Uptime and the memory peak are kept.
+func Reset() {
+	for _, counter := range []*atomic.Int64{&ConnectionsReceived, &RejectedConnections, &CommandsProcessed, &ErrorReplies, &KeyspaceHits, &KeyspaceMisses, &ExpiredKeys, &EvictedKeys} {
+		counter.Store(0)
+	}
+
+	commandsMutex.Lock()
+	commands = map[string]*Command{}
+	commandsMutex.Unlock()
+
+	storageMutex.Lock()
+	storageOperations = map[string]*StorageOperation{}
+	storageMutex.Unlock()
+
+	samples.mutex.Lock()
+	samples.ops = [OPS_SAMPLES]float64{}
+	samples.lastOps = 0
+	samples.mutex.Unlock()
+}
@@ -0,0 +1,46 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// StorageOperation holds the latency and errors of one kind of store call,
+// e.g. get or commit
+type StorageOperation struct {
+	Latency Histogram
+	Errors  int64
+}
+
+var storageOperations = map[string]*StorageOperation{}
+var storageMutex = sync.Mutex{}
+
+// RecordStorage is registered as the storage observer, so that every store
+// call is timed
+func RecordStorage(op string, duration time.Duration, err error) {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+
+	o, ok := storageOperations[op]
+	if !ok {
+		o = &StorageOperation{}
+		storageOperations[op] = o
+	}
+	o.Latency.observe(duration)
+	if err != nil {
+		o.Errors++
+	}
+}
+
+func StorageOperations() map[string]StorageOperation {
+	storageMutex.Lock()
+	defer storageMutex.Unlock()
+
+	copied := make(map[string]StorageOperation, len(storageOperations))
+	for op, o := range storageOperations {
+		operation := *o
+		operation.Latency = o.Latency.copy()
+		copied[op] = operation
+	}
+	return copied
+}
@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// The log starts with this header and is followed by records, each the
+// CRC-32C of its payload and the payload length as little endian uint32s and
+// then the payload. A payload is the ops of one transaction, so that a
+// transaction is either replayed whole or not at all.
+const FILE_LOG_HEADER = "GRCLOG01"
+
+const RECORD_HEADER_SIZE = 8
+
+// Codes of the ops in a record
+const (
+	LOG_OP_SET = iota + 1
+	LOG_OP_DELETE
+	LOG_OP_LIST_PUSH
+	LOG_OP_LIST_POP
+	LOG_OP_SET_ADD
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+var errCorruptRecord = errors.New("corrupt record")
+
+// logOp is a change to a single key. Lists and sets are changed an element
+// at a time, so that pushing to a long list doesn't log the whole list.
+type logOp struct {
+	code byte
+	key  string
+	// The whole value written by LOG_OP_SET
+	kv KV
+	// Values pushed by LOG_OP_LIST_PUSH or members added by LOG_OP_SET_ADD
+	values []string
+	left   bool
+}
+
+// apply returns the value of the key after the op, given its value before or
+// nil if it doesn't exist, and nil if the op removes it. Values are never
+// changed in place, since readers may still hold them.
+func (op logOp) apply(v *KV) *KV {
+	switch op.code {
+	case LOG_OP_SET:
+		kv := cloneKV(op.kv)
+		return &kv
+	case LOG_OP_DELETE:
+		return nil
+	case LOG_OP_LIST_PUSH:
+		kv := KV{Key: op.key, Typ: "list"}
+		if v != nil {
+			kv = *v
+		}
+		list := make([]string, 0, len(kv.Arr)+len(op.values))
+		if op.left {
+			for i := len(op.values) - 1; i >= 0; i-- {
+				list = append(list, op.values[i])
+			}
+			list = append(list, kv.Arr...)
+		} else {
+			list = append(list, kv.Arr...)
+			list = append(list, op.values...)
+		}
+		kv.Arr = list
+		return &kv
+	case LOG_OP_LIST_POP:
+		if v == nil || len(v.Arr) <= 1 {
+			return nil
+		}
+		kv := *v
+		if op.left {
+			kv.Arr = kv.Arr[1:]
+		} else {
+			kv.Arr = kv.Arr[:len(kv.Arr)-1]
+		}
+		return &kv
+	case LOG_OP_SET_ADD:
+		kv := KV{Key: op.key, Typ: "set"}
+		if v != nil {
+			kv = *v
+		}
+		set := make(JSONB, len(kv.Set)+len(op.values))
+		for m := range kv.Set {
+			set[m] = struct{}{}
+		}
+		for _, m := range op.values {
+			set[m] = struct{}{}
+		}
+		kv.Set = set
+		return &kv
+	}
+
+	return v
+}
+
+// cloneKV copies a value so that it doesn't share its list or set
+func cloneKV(kv KV) KV {
+	if kv.Arr != nil {
+		kv.Arr = append([]string{}, kv.Arr...)
+	}
+	if kv.Set != nil {
+		set := make(JSONB, len(kv.Set))
+		for m := range kv.Set {
+			set[m] = struct{}{}
+		}
+		kv.Set = set
+	}
+
+	return kv
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendStrings(b []byte, values []string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(values)))
+	for _, v := range values {
+		b = appendString(b, v)
+	}
+	return b
+}
+
+func (op logOp) encode(b []byte) []byte {
+	b = append(b, op.code)
+	b = appendString(b, op.key)
+
+	switch op.code {
+	case LOG_OP_SET:
+		b = appendString(b, op.kv.Typ)
+		b = appendString(b, op.kv.Str)
+		b = binary.AppendVarint(b, int64(op.kv.Exp))
+		b = appendStrings(b, op.kv.Arr)
+		members := make([]string, 0, len(op.kv.Set))
+		for m := range op.kv.Set {
+			members = append(members, m)
+		}
+		b = appendStrings(b, members)
+	case LOG_OP_LIST_PUSH, LOG_OP_LIST_POP:
+		left := byte(0)
+		if op.left {
+			left = 1
+		}
+		b = append(b, left)
+		b = appendStrings(b, op.values)
+	case LOG_OP_SET_ADD:
+		b = appendStrings(b, op.values)
+	}
+
+	return b
+}
+
+// encodeRecord frames the ops of a transaction as a record
+func encodeRecord(ops []logOp) []byte {
+	payload := binary.AppendUvarint(nil, uint64(len(ops)))
+	for _, op := range ops {
+		payload = op.encode(payload)
+	}
+
+	b := make([]byte, 0, RECORD_HEADER_SIZE+len(payload))
+	b = binary.LittleEndian.AppendUint32(b, crc32.Checksum(payload, crcTable))
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(payload)))
+	return append(b, payload...)
+}
+
+// payloadReader decodes a payload, remembering the first error so that
+// decoding can carry on and be checked once at the end
+type payloadReader struct {
+	b   []byte
+	err error
+}
+
+func (r *payloadReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *payloadReader) varint() int64 {
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *payloadReader) byte() byte {
+	if len(r.b) == 0 {
+		r.err = errCorruptRecord
+		return 0
+	}
+	v := r.b[0]
+	r.b = r.b[1:]
+	return v
+}
+
+func (r *payloadReader) string() string {
+	n := r.uvarint()
+	if r.err != nil || n > uint64(len(r.b)) {
+		r.err = errCorruptRecord
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func (r *payloadReader) strings() []string {
+	n := r.uvarint()
+	// Every string takes at least a byte, which bounds a corrupt count
+	if r.err != nil || n > uint64(len(r.b)) {
+		r.err = errCorruptRecord
+		return nil
+	}
+	values := make([]string, 0, n)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		values = append(values, r.string())
+	}
+	return values
+}
+
+func decodePayload(payload []byte) ([]logOp, error) {
+	r := payloadReader{b: payload}
+	count := r.uvarint()
+	if r.err != nil || count > uint64(len(payload)) {
+		return nil, errCorruptRecord
+	}
+
+	ops := make([]logOp, 0, count)
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		op := logOp{code: r.byte(), key: r.string()}
+		switch op.code {
+		case LOG_OP_SET:
+			op.kv = KV{Key: op.key, Typ: r.string(), Str: r.string(), Exp: int(r.varint())}
+			if arr := r.strings(); len(arr) > 0 {
+				op.kv.Arr = arr
+			}
+			if members := r.strings(); len(members) > 0 {
+				op.kv.Set = JSONB{}
+				for _, m := range members {
+					op.kv.Set[m] = struct{}{}
+				}
+			}
+		case LOG_OP_DELETE:
+		case LOG_OP_LIST_PUSH, LOG_OP_LIST_POP:
+			op.left = r.byte() == 1
+			op.values = r.strings()
+		case LOG_OP_SET_ADD:
+			op.values = r.strings()
+		default:
+			r.err = errCorruptRecord
+		}
+		ops = append(ops, op)
+	}
+
+	if r.err != nil || len(r.b) != 0 {
+		return nil, errCorruptRecord
+	}
+	return ops, nil
+}
+
+// replayLog rebuilds the keys from the log and returns them with the length
+// of the log up to its last complete record. A record cut short at the end
+// of the log, as left by a crash in the middle of a write, is ignored. A
+// complete record that fails its checksum means the log is corrupt and is
+// an error, rather than a reason to drop everything after it.
+func replayLog(path string) (map[string]KV, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r := bufio.NewReaderSize(f, 1<<20)
+	header := make([]byte, len(FILE_LOG_HEADER))
+	if _, err := io.ReadFull(r, header); err != nil || string(header) != FILE_LOG_HEADER {
+		return nil, 0, fmt.Errorf("%s is not a storage log", path)
+	}
+
+	data := map[string]KV{}
+	offset := int64(len(FILE_LOG_HEADER))
+	recordHeader := make([]byte, RECORD_HEADER_SIZE)
+	for {
+		if _, err := io.ReadFull(r, recordHeader); err == io.EOF {
+			return data, offset, nil
+		} else if err == io.ErrUnexpectedEOF {
+			return data, offset, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		checksum := binary.LittleEndian.Uint32(recordHeader)
+		length := int64(binary.LittleEndian.Uint32(recordHeader[4:]))
+		end := offset + RECORD_HEADER_SIZE + length
+		if end > info.Size() {
+			return data, offset, nil
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, 0, err
+		}
+
+		ops, err := decodePayload(payload)
+		if crc32.Checksum(payload, crcTable) != checksum || err != nil {
+			return nil, 0, fmt.Errorf("%s has a corrupt record at offset %d, followed by %d bytes", path, offset, info.Size()-end)
+		}
+
+		for _, op := range ops {
+			var v *KV
+			if kv, ok := data[op.key]; ok {
+				v = &kv
+			}
+			if v = op.apply(v); v == nil {
+				delete(data, op.key)
+			} else {
+				data[op.key] = *v
+			}
+		}
+		offset = end
+	}
+}
@@ -0,0 +1,664 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+)
+
+// Values of appendfsync
+const (
+	FSYNC_ALWAYS   = "always"
+	FSYNC_EVERYSEC = "everysec"
+	FSYNC_NO       = "no"
+)
+
+// The log is compacted once it has grown past this size and has doubled
+// since it was last compacted
+const COMPACTION_MIN_SIZE = 64 << 20
+
+// Compaction writes this many keys per record
+const COMPACTION_BATCH = 1000
+
+var errTransactionFinished = errors.New("transaction already finished")
+
+// FileStore keeps every key in memory and appends each committed transaction
+// to a log file, which is replayed on start. Nothing outside the process is
+// needed, at the cost of holding the whole keyspace in memory.
+type FileStore struct {
+	path  string
+	fsync string
+	// Guards everything below it. Values in data are never changed in place,
+	// only replaced, so they can be read after the lock is released.
+	mutex   sync.RWMutex
+	data    map[string]KV
+	expires map[string]struct{}
+	file    *os.File
+	// Length of the log, and its length after it was last compacted
+	size          int64
+	compactedSize int64
+	// Whether the log has been written since it was last synced
+	dirty bool
+
+	// Keys held by GetForUpdate until their transaction ends
+	locks keyLocks
+
+	expiryHandlers []ExpiryHandler
+	stop           chan struct{}
+}
+
+func NewFileStore(path string, fsync string) FileStore {
+	return FileStore{path: path, fsync: fsync}
+}
+
+// FileTransaction collects its writes in memory, where its own reads see
+// them, and appends them to the log as one record on commit
+type FileTransaction struct {
+	store   *FileStore
+	ops     []logOp
+	overlay map[string]*KV
+	held    []string
+	started time.Time
+	done    bool
+}
+
+func (t *FileTransaction) Commit() (err error) {
+	defer observe(OP_TRANSACTION, t.started, &err)
+	defer observe(OP_COMMIT, time.Now(), &err)
+
+	if t.done {
+		return errTransactionFinished
+	}
+	defer t.finish()
+
+	if len(t.ops) == 0 {
+		return nil
+	}
+	t.store.mutex.Lock()
+	defer t.store.mutex.Unlock()
+	return t.store.write(t.ops)
+}
+
+func (t *FileTransaction) Abort() (err error) {
+	defer observe(OP_TRANSACTION, t.started, &err)
+	defer observe(OP_ABORT, time.Now(), &err)
+
+	if t.done {
+		return errTransactionFinished
+	}
+	t.finish()
+	return nil
+}
+
+func (t *FileTransaction) finish() {
+	t.done = true
+	for _, key := range t.held {
+		t.store.locks.unlock(key)
+	}
+	t.held = nil
+}
+
+// view returns the key as the transaction sees it, or nil if it doesn't exist
+func (t *FileTransaction) view(key string) *KV {
+	if v, ok := t.overlay[key]; ok {
+		return v
+	}
+	if v, ok := t.store.get(key); ok {
+		return &v
+	}
+	return nil
+}
+
+// live is view with expired keys reported missing
+func (t *FileTransaction) live(key string) *KV {
+	v := t.view(key)
+	if v != nil && isExpired(*v) {
+		return nil
+	}
+	return v
+}
+
+// record applies the op to the transaction's view of its key and returns the
+// new value. A key that has expired is deleted first, so that replaying the
+// log gives the same result whenever it happens.
+func (t *FileTransaction) record(op logOp) *KV {
+	v := t.view(op.key)
+	if v != nil && isExpired(*v) && op.code != LOG_OP_SET && op.code != LOG_OP_DELETE {
+		t.ops = append(t.ops, logOp{code: LOG_OP_DELETE, key: op.key})
+		v = nil
+	}
+
+	v = op.apply(v)
+	t.ops = append(t.ops, op)
+	t.overlay[op.key] = v
+	return v
+}
+
+func isExpired(kv KV) bool {
+	return kv.Exp > 0 && kv.Exp < int(time.Now().UnixMilli())
+}
+
+func (s *FileStore) init() error {
+	s.data = map[string]KV{}
+	s.expires = map[string]struct{}{}
+
+	// The path is fixed now, since CONFIG SET dir changes the working
+	// directory
+	path, err := filepath.Abs(s.path)
+	if err != nil {
+		return err
+	}
+	s.path = path
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(s.path); errors.Is(err, os.ErrNotExist) {
+		if err := s.rewrite(); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	} else if err := s.load(); err != nil {
+		return err
+	}
+
+	if s.fsync == FSYNC_EVERYSEC {
+		s.stop = make(chan struct{})
+		go s.syncEverySecond()
+	}
+	return nil
+}
+
+// load replays the log and opens it for appending. A torn record at the end
+// is cut off, so that new records follow the last complete one.
+func (s *FileStore) load() error {
+	data, size, err := replayLog(s.path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > size {
+		logger.Warning("Discarding %d bytes of an incomplete write at the end of %s", info.Size()-size, s.path)
+		if err := os.Truncate(s.path, size); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.data = data
+	for key, v := range data {
+		if v.Exp > 0 {
+			s.expires[key] = struct{}{}
+		}
+	}
+	s.file = f
+	s.size = size
+	s.compactedSize = size
+	logger.Notice("Loaded %d keys from %s", len(data), s.path)
+	return nil
+}
+
+func (s *FileStore) syncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		// The sync happens outside the lock so that commits aren't held up
+		// by it
+		s.mutex.Lock()
+		f, dirty := s.file, s.dirty
+		s.dirty = false
+		s.mutex.Unlock()
+
+		if dirty {
+			if err := f.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				logger.Warning("Syncing %s: %v", s.path, err)
+			}
+		}
+	}
+}
+
+// get returns the committed value of the key, expired or not
+func (s *FileStore) get(key string) (KV, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	v, ok := s.data[key]
+	return v, ok
+}
+
+// put installs the value of a key after it has been logged, or removes the
+// key if it is nil. It is called with the mutex held.
+func (s *FileStore) put(key string, v *KV) {
+	if v == nil {
+		delete(s.data, key)
+		delete(s.expires, key)
+		return
+	}
+
+	s.data[key] = *v
+	if v.Exp > 0 {
+		s.expires[key] = struct{}{}
+	} else {
+		delete(s.expires, key)
+	}
+}
+
+// write appends the ops to the log as one record and then applies them to
+// the keys, the same way replaying the log does. It is called with the mutex
+// held.
+func (s *FileStore) write(ops []logOp) error {
+	record := encodeRecord(ops)
+	if _, err := s.file.Write(record); err != nil {
+		// Cut off whatever part of the record was written, so that the
+		// next record doesn't follow a torn one
+		s.file.Truncate(s.size)
+		return err
+	}
+
+	// With appendfsync always the ops are only applied once the record is
+	// synced. A record that fails to sync is cut off again, so the commit
+	// fails without changing memory or the log. If it can't be cut off it
+	// will be replayed, so it is applied and left for the next sync.
+	if s.fsync == FSYNC_ALWAYS {
+		if err := s.file.Sync(); err != nil {
+			if s.file.Truncate(s.size) == nil {
+				return err
+			}
+			logger.Warning("Syncing %s: %v", s.path, err)
+			s.dirty = true
+		}
+	} else {
+		s.dirty = true
+	}
+	s.size += int64(len(record))
+
+	for _, op := range ops {
+		var v *KV
+		if kv, ok := s.data[op.key]; ok {
+			v = &kv
+		}
+		s.put(op.key, op.apply(v))
+	}
+
+	if s.size >= COMPACTION_MIN_SIZE && s.size >= 2*s.compactedSize {
+		if err := s.rewrite(); err != nil {
+			logger.Warning("Compacting %s: %v", s.path, err)
+		}
+	}
+	return nil
+}
+
+// deleteExpired removes the keys that are still expired and returns them
+func (s *FileStore) deleteExpired(keys []string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ops := []logOp{}
+	expired := []string{}
+	for _, key := range keys {
+		if v, ok := s.data[key]; ok && isExpired(v) {
+			ops = append(ops, logOp{code: LOG_OP_DELETE, key: key})
+			expired = append(expired, key)
+		}
+	}
+	if len(ops) == 0 {
+		return nil, nil
+	}
+
+	return expired, s.write(ops)
+}
+
+// rewrite replaces the log with one that sets every key to its current
+// value, dropping the history of how it got there. Expired keys are kept for
+// the expire cycle to remove. The new log is synced before it replaces the
+// old one, so a crash leaves one or the other. It is called with the mutex
+// held.
+func (s *FileStore) rewrite() error {
+	tmp := s.path + ".rewrite"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	size, err := writeSnapshot(f, s.data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+
+	if s.file != nil {
+		s.file.Close()
+	}
+	if s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	s.size = size
+	s.compactedSize = size
+	s.dirty = false
+	return nil
+}
+
+func writeSnapshot(f *os.File, data map[string]KV) (int64, error) {
+	b := []byte(FILE_LOG_HEADER)
+	size := int64(0)
+	batch := []logOp{}
+
+	flush := func() error {
+		if len(batch) > 0 {
+			b = append(b, encodeRecord(batch)...)
+			batch = batch[:0]
+		}
+		n, err := f.Write(b)
+		size += int64(n)
+		b = b[:0]
+		return err
+	}
+
+	for key, v := range data {
+		batch = append(batch, logOp{code: LOG_OP_SET, key: key, kv: v})
+		if len(batch) == COMPACTION_BATCH {
+			if err := flush(); err != nil {
+				return size, err
+			}
+		}
+	}
+
+	return size, flush()
+}
+
+// syncDir makes a rename in the directory durable. Not every platform can
+// sync a directory, so failures are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Save compacts the log, so that the next start replays as little as
+// possible
+func (s *FileStore) Save() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.rewrite()
+}
+
+func (s *FileStore) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.file.Sync(); err != nil {
+		s.file.Close()
+		return err
+	}
+	return s.file.Close()
+}
+
+// expireKey removes a key a read found expired, unless it has been written
+// since
+func (s *FileStore) expireKey(key string) error {
+	expired, err := s.deleteExpired([]string{key})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		for _, handler := range s.expiryHandlers {
+			handler(key)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) Exists(kv KV) (_ bool, err error) {
+	defer observe(OP_EXISTS, time.Now(), &err)
+
+	v, ok := s.get(kv.Key)
+	return ok && !isExpired(v), nil
+}
+
+func (s *FileStore) InitTransaction() (_ Transaction, err error) {
+	defer observe(OP_BEGIN, time.Now(), &err)
+
+	return &FileTransaction{store: s, overlay: map[string]*KV{}, started: time.Now()}, nil
+}
+
+func (s *FileStore) GetByKey(kv KV) (_ KV, _ bool, err error) {
+	defer observe(OP_GET, time.Now(), &err)
+
+	v, ok := s.get(kv.Key)
+	if !ok {
+		return KV{}, false, nil
+	}
+	if isExpired(v) {
+		return KV{}, false, s.expireKey(kv.Key)
+	}
+
+	return cloneKV(v), true, nil
+}
+
+func (s *FileStore) GetTypeByKey(kv KV) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_TYPE, time.Now(), &err)
+
+	v, ok := s.get(kv.Key)
+	if !ok {
+		return KV{}, false, nil
+	}
+	if isExpired(v) {
+		return KV{}, false, s.expireKey(kv.Key)
+	}
+
+	return KV{Key: v.Key, Typ: v.Typ, Exp: v.Exp}, true, nil
+}
+
+func (s *FileStore) GetForUpdate(kv KV, t Transaction) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_FOR_UPDATE, time.Now(), &err)
+
+	v := s.hold(kv.Key, t.(*FileTransaction))
+	if v == nil {
+		return KV{}, false, nil
+	}
+	return cloneKV(*v), true, nil
+}
+
+func (s *FileStore) GetTypeForUpdate(kv KV, t Transaction) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_TYPE_FOR_UPDATE, time.Now(), &err)
+
+	v := s.hold(kv.Key, t.(*FileTransaction))
+	if v == nil {
+		return KV{}, false, nil
+	}
+	return KV{Key: v.Key, Typ: v.Typ, Exp: v.Exp}, true, nil
+}
+
+// hold locks the key until the transaction ends and returns its live value.
+// Expired keys are left for the expire cycle, or for the caller to
+// overwrite.
+func (s *FileStore) hold(key string, tx *FileTransaction) *KV {
+	held := false
+	for _, k := range tx.held {
+		held = held || k == key
+	}
+	if !held {
+		s.locks.lock(key)
+		tx.held = append(tx.held, key)
+	}
+
+	return tx.live(key)
+}
+
+func (s *FileStore) SetKV(kv KV, t Transaction) (err error) {
+	defer observe(OP_SET, time.Now(), &err)
+
+	t.(*FileTransaction).record(logOp{code: LOG_OP_SET, key: kv.Key, kv: kv})
+	return nil
+}
+
+func (s *FileStore) ListPush(kv KV, values []string, left bool, t Transaction) (_ int, err error) {
+	defer observe(OP_LIST_PUSH, time.Now(), &err)
+
+	v := t.(*FileTransaction).record(logOp{code: LOG_OP_LIST_PUSH, key: kv.Key, values: values, left: left})
+	return len(v.Arr), nil
+}
+
+func (s *FileStore) ListPop(kv KV, left bool, t Transaction) (_ string, _ bool, _ bool, err error) {
+	defer observe(OP_LIST_POP, time.Now(), &err)
+
+	tx := t.(*FileTransaction)
+	v := tx.live(kv.Key)
+	if v == nil || len(v.Arr) == 0 {
+		return "", false, v == nil, nil
+	}
+
+	value := v.Arr[len(v.Arr)-1]
+	if left {
+		value = v.Arr[0]
+	}
+	emptied := tx.record(logOp{code: LOG_OP_LIST_POP, key: kv.Key, left: left}) == nil
+	return value, true, emptied, nil
+}
+
+func (s *FileStore) SetAdd(kv KV, members []string, t Transaction) (_ int, err error) {
+	defer observe(OP_SET_ADD, time.Now(), &err)
+
+	tx := t.(*FileTransaction)
+	before := 0
+	if v := tx.live(kv.Key); v != nil {
+		before = len(v.Set)
+	}
+	v := tx.record(logOp{code: LOG_OP_SET_ADD, key: kv.Key, values: members})
+	return len(v.Set) - before, nil
+}
+
+func (s *FileStore) SetIsMember(kv KV, member string) (_ bool, err error) {
+	defer observe(OP_SET_IS_MEMBER, time.Now(), &err)
+
+	v, ok := s.get(kv.Key)
+	if !ok || isExpired(v) {
+		return false, nil
+	}
+	_, ok = v.Set[member]
+	return ok, nil
+}
+
+func (s *FileStore) DeleteByKey(kv KV, t Transaction) (_ int, err error) {
+	defer observe(OP_DELETE, time.Now(), &err)
+
+	tx := t.(*FileTransaction)
+	if tx.live(kv.Key) == nil {
+		return 0, nil
+	}
+	tx.record(logOp{code: LOG_OP_DELETE, key: kv.Key})
+	return 1, nil
+}
+
+// IsTransient is always false, since transactions on the same key wait for
+// each other's locks instead of conflicting
+func (s *FileStore) IsTransient(err error) bool {
+	return false
+}
+
+func (s *FileStore) OnExpire(handler ExpiryHandler) {
+	s.expiryHandlers = append(s.expiryHandlers, handler)
+}
+
+func (s *FileStore) KeyspaceInfo() (_ KeyspaceInfo, err error) {
+	defer observe(OP_COUNT, time.Now(), &err)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := int(time.Now().UnixMilli())
+	info := KeyspaceInfo{Keys: len(s.data)}
+	ttl := 0
+	for key := range s.expires {
+		if exp := s.data[key].Exp; exp < now {
+			info.Keys--
+		} else {
+			info.Expires++
+			ttl += exp - now
+		}
+	}
+	if info.Expires > 0 {
+		info.AvgTTL = ttl / info.Expires
+	}
+	return info, nil
+}
+
+func (s *FileStore) CountByType() (_ map[string]int, err error) {
+	defer observe(OP_COUNT_BY_TYPE, time.Now(), &err)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	counts := map[string]int{}
+	for _, v := range s.data {
+		if !isExpired(v) {
+			counts[v.Typ]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *FileStore) DeleteExpired(limit int) (_ int, err error) {
+	defer observe(OP_DELETE_EXPIRED, time.Now(), &err)
+
+	s.mutex.RLock()
+	keys := []string{}
+	for key := range s.expires {
+		if len(keys) == limit {
+			break
+		}
+		if isExpired(s.data[key]) {
+			keys = append(keys, key)
+		}
+	}
+	s.mutex.RUnlock()
+
+	expired, err := s.deleteExpired(keys)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, key := range expired {
+		for _, handler := range s.expiryHandlers {
+			handler(key)
+		}
+	}
+	return len(expired), nil
+}
@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	testStore(t, func(t *testing.T) storeOpener {
+		path := filepath.Join(t.TempDir(), "store.log")
+		return func() Store {
+			return openFileStore(t, path)
+		}
+	})
+}
+
+// openFileStore opens the log at path, failing the test if it can't
+func openFileStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+
+	s := NewFileStore(path, FSYNC_NO)
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func fileSize(t *testing.T, path string) int64 {
+	t.Helper()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info.Size()
+}
+
+func appendToFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeLog creates a log with a record setting k, and returns its size
+func writeLog(t *testing.T, path string) int64 {
+	t.Helper()
+
+	s := openFileStore(t, path)
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "k", Typ: "string", Str: "v"}, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return fileSize(t, path)
+}
+
+// A record cut short at the end of the log, as a crash in the middle of a
+// write leaves it, is cut off and the records before it are kept
+func TestFileStoreTornTail(t *testing.T) {
+	record := encodeRecord([]logOp{{code: LOG_OP_SET, key: "torn", kv: KV{Key: "torn", Typ: "string", Str: "v"}}})
+	tails := map[string][]byte{
+		"part of a header":  record[:RECORD_HEADER_SIZE-3],
+		"header only":       record[:RECORD_HEADER_SIZE],
+		"part of a payload": record[:len(record)-1],
+		// Garbage whose length runs past the end of the log
+		"garbage": []byte(strings.Repeat("\xff", 64)),
+	}
+
+	for name, tail := range tails {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "store.log")
+			size := writeLog(t, path)
+			appendToFile(t, path, tail)
+
+			s := openFileStore(t, path)
+			if got := fileSize(t, path); got != size {
+				t.Errorf("the log is %d bytes after opening, want the %d before the torn record", got, size)
+			}
+			if v, ok := mustGet(t, s, "k"); !ok || v.Str != "v" {
+				t.Errorf("GetByKey returned %+v, %v for a key before the torn record", v, ok)
+			}
+			if _, ok := mustGet(t, s, "torn"); ok {
+				t.Error("the torn record was applied")
+			}
+
+			// New records follow the last complete one, so they are
+			// replayed on the next start
+			update(t, s, func(tx Transaction) {
+				if err := s.SetKV(KV{Key: "after", Typ: "string", Str: "v"}, tx); err != nil {
+					t.Fatal(err)
+				}
+			})
+			s.Close()
+			s = openFileStore(t, path)
+			defer s.Close()
+			if _, ok := mustGet(t, s, "after"); !ok {
+				t.Error("a record written after the torn one was lost on reopening")
+			}
+		})
+	}
+}
+
+// A complete record that fails its checksum stops the store from opening,
+// rather than dropping it and every record after it
+func TestFileStoreCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	writeLog(t, path)
+	appendToFile(t, path, encodeRecord([]logOp{{code: LOG_OP_DELETE, key: "k"}}))
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a byte in the payload of the first record
+	b[len(FILE_LOG_HEADER)+RECORD_HEADER_SIZE] ^= 0xff
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewFileStore(path, FSYNC_NO)
+	if err := s.init(); err == nil {
+		s.Close()
+		t.Fatal("a log with a corrupt record was opened")
+	}
+	if got := fileSize(t, path); got != int64(len(b)) {
+		t.Errorf("the log was cut from %d to %d bytes", len(b), got)
+	}
+}
+
+func TestFileStoreNotALog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	if err := os.WriteFile(path, []byte("some other file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewFileStore(path, FSYNC_NO)
+	if err := s.init(); err == nil {
+		s.Close()
+		t.Fatal("a file without the log header was opened")
+	}
+}
+
+// Save compacts the log into one entry per key, which replays to the same
+// keys
+func TestFileStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	s := openFileStore(t, path)
+
+	for i := 0; i < 100; i++ {
+		update(t, s, func(tx Transaction) {
+			if err := s.SetKV(KV{Key: "k", Typ: "string", Str: strings.Repeat("v", i)}, tx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.ListPush(KV{Key: "list"}, []string{"a"}, false, tx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.SetAdd(KV{Key: "set"}, []string{"a", "b"}, tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.SetKV(KV{Key: "deleted", Typ: "string", Str: "v"}, tx); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := s.DeleteByKey(KV{Key: "deleted"}, tx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	before := fileSize(t, path)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	after := fileSize(t, path)
+	if after >= before/10 {
+		t.Errorf("the log went from %d to %d bytes when compacted", before, after)
+	}
+	if _, err := os.Stat(path + ".rewrite"); !os.IsNotExist(err) {
+		t.Errorf("the compacted log was left beside the log: %v", err)
+	}
+
+	// Writes after compaction are appended to the compacted log
+	update(t, s, func(tx Transaction) {
+		if _, err := s.ListPush(KV{Key: "list"}, []string{"b"}, false, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	s.Close()
+
+	s = openFileStore(t, path)
+	defer s.Close()
+	if v, _ := mustGet(t, s, "k"); v.Str != strings.Repeat("v", 99) {
+		t.Errorf("k is %q after compaction, want the last value written", v.Str)
+	}
+	if v, _ := mustGet(t, s, "list"); len(v.Arr) != 101 || v.Arr[100] != "b" {
+		t.Errorf("the list has %d values after compaction, want 101 ending with b", len(v.Arr))
+	}
+	if v, _ := mustGet(t, s, "set"); !slices.Equal(members(v), []string{"a", "b"}) {
+		t.Errorf("the set is %q after compaction, want [a b]", members(v))
+	}
+	if _, ok := mustGet(t, s, "deleted"); ok {
+		t.Error("a deleted key came back after compaction")
+	}
+}
+
+// A compaction interrupted before its rename leaves the old log in place,
+// which is what is opened
+func TestFileStoreInterruptedCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.log")
+	writeLog(t, path)
+	if err := os.WriteFile(path+".rewrite", []byte(FILE_LOG_HEADER+"partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openFileStore(t, path)
+	defer s.Close()
+	if v, ok := mustGet(t, s, "k"); !ok || v.Str != "v" {
+		t.Errorf("GetByKey returned %+v, %v next to an interrupted compaction", v, ok)
+	}
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,18 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// InstanceID identifies this server process among all the instances that
+// share a backend, so that instances can ignore their own notifications.
+var InstanceID = newInstanceID()
+
+func newInstanceID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
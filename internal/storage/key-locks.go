@@ -0,0 +1,43 @@
+package storage
+
+import "sync"
+
+// keyLocks serializes the transactions of this process that hold the same
+// key. A lock is dropped once nobody holds or waits for it.
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mutex sync.Mutex
+	refs  int
+}
+
+func (l *keyLocks) lock(key string) {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*keyLock{}
+	}
+	k, ok := l.locks[key]
+	if !ok {
+		k = &keyLock{}
+		l.locks[key] = k
+	}
+	k.refs++
+	l.mutex.Unlock()
+
+	k.mutex.Lock()
+}
+
+func (l *keyLocks) unlock(key string) {
+	l.mutex.Lock()
+	k := l.locks[key]
+	k.refs--
+	if k.refs == 0 {
+		delete(l.locks, key)
+	}
+	l.mutex.Unlock()
+
+	k.mutex.Unlock()
+}
@@ -0,0 +1,630 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Labels MongoDB gives the errors of transactions
+const (
+	// The transaction was aborted through no fault of its own, e.g. by a
+	// write conflict, and can be run again from the start
+	MONGO_TRANSIENT_TRANSACTION_ERROR = "TransientTransactionError"
+	// The commit may or may not have been applied, and can be retried
+	MONGO_UNKNOWN_COMMIT_RESULT = "UnknownTransactionCommitResult"
+)
+
+// A commit whose result is unknown is tried this many times in all
+const MONGO_COMMIT_ATTEMPTS = 5
+
+func hasErrorLabel(err error, label string) bool {
+	var labeled mongo.LabeledError
+	return errors.As(err, &labeled) && labeled.HasErrorLabel(label)
+}
+
+type MongoTransaction struct {
+	store   *MongoStore
+	session mongo.Session
+	ctx     mongo.SessionContext
+	held    []string
+	// Keys that didn't exist when they were locked, whose placeholder
+	// documents are removed on commit unless the key was written
+	placeholders []string
+	started      time.Time
+	done         bool
+}
+
+func (t *MongoTransaction) Commit() (err error) {
+	defer observe(OP_TRANSACTION, t.started, &err)
+	defer observe(OP_COMMIT, time.Now(), &err)
+
+	if t.done {
+		return errTransactionFinished
+	}
+	defer t.finish()
+
+	if len(t.placeholders) > 0 {
+		_, err = t.store.collection.DeleteMany(t.ctx, bson.M{"_id": bson.M{"$in": t.placeholders}, "typ": bson.M{"$exists": false}})
+		if err != nil {
+			t.session.AbortTransaction(t.ctx)
+			return err
+		}
+	}
+
+	// Committing again is safe, since a commit that was applied already
+	// just succeeds
+	for attempt := 1; ; attempt++ {
+		err = t.session.CommitTransaction(t.ctx)
+		if attempt == MONGO_COMMIT_ATTEMPTS || !hasErrorLabel(err, MONGO_UNKNOWN_COMMIT_RESULT) {
+			return err
+		}
+	}
+}
+
+func (t *MongoTransaction) Abort() (err error) {
+	defer observe(OP_TRANSACTION, t.started, &err)
+	defer observe(OP_ABORT, time.Now(), &err)
+
+	if t.done {
+		return errTransactionFinished
+	}
+	defer t.finish()
+
+	return t.session.AbortTransaction(t.ctx)
+}
+
+func (t *MongoTransaction) finish() {
+	t.done = true
+	t.session.EndSession(context.Background())
+	for _, key := range t.held {
+		t.store.locks.unlock(key)
+	}
+	t.held = nil
+}
+
+// MongoStore keeps a document per key. Multi-document transactions need a
+// replica set or a sharded cluster, which init checks for.
+type MongoStore struct {
+	uri            string
+	databaseName   string
+	collectionName string
+	client         *mongo.Client
+	collection     *mongo.Collection
+	// Keys held by GetForUpdate until their transaction ends
+	locks          keyLocks
+	expiryHandlers []ExpiryHandler
+}
+
+func NewMongoStore(uri string, database string, collection string) MongoStore {
+	return MongoStore{uri: uri, databaseName: database, collectionName: collection}
+}
+
+// mongoKV is the document of a key, with the key as its _id. Lists are an
+// array in order and sets an array of members, so that commands change them
+// in place with $push, $pop and $addToSet. expireAt is Exp as a date for the
+// TTL index and is only set on keys that expire. rev is bumped by
+// GetForUpdate.
+type mongoKV struct {
+	Key      string     `bson:"_id"`
+	Typ      string     `bson:"typ"`
+	Str      string     `bson:"str,omitempty"`
+	Arr      []string   `bson:"arr,omitempty"`
+	Set      []string   `bson:"set,omitempty"`
+	Exp      int        `bson:"exp"`
+	ExpireAt *time.Time `bson:"expireAt,omitempty"`
+}
+
+func newMongoKV(kv KV) mongoKV {
+	doc := mongoKV{Key: kv.Key, Typ: kv.Typ, Str: kv.Str, Exp: kv.Exp}
+	switch kv.Typ {
+	case "list":
+		doc.Arr = kv.Arr
+	case "set":
+		for m := range kv.Set {
+			doc.Set = append(doc.Set, m)
+		}
+	}
+	if kv.Exp > 0 {
+		expireAt := time.UnixMilli(int64(kv.Exp))
+		doc.ExpireAt = &expireAt
+	}
+
+	return doc
+}
+
+func (doc mongoKV) kv() KV {
+	kv := KV{Key: doc.Key, Typ: doc.Typ, Str: doc.Str, Exp: doc.Exp}
+	switch doc.Typ {
+	case "list":
+		kv.Arr = doc.Arr
+	case "set":
+		kv.Set = JSONB{}
+		for _, m := range doc.Set {
+			kv.Set[m] = struct{}{}
+		}
+	}
+
+	return kv
+}
+
+// Projection of the type and expiry only, for GetTypeByKey
+var typeProjection = bson.M{"typ": 1, "exp": 1}
+
+// liveFilter matches the keys that haven't expired
+func liveFilter(now int64) bson.M {
+	return bson.M{"$or": bson.A{bson.M{"exp": 0}, bson.M{"exp": bson.M{"$gt": now}}}}
+}
+
+// expiredFilter matches the key if it has expired, using the TTL index
+func expiredFilter(key string) bson.M {
+	return bson.M{"_id": key, "expireAt": bson.M{"$lt": time.Now()}}
+}
+
+func (s *MongoStore) init() error {
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(s.uri))
+	if err != nil {
+		return err
+	}
+	s.client = client
+
+	hello := struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}{}
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err != nil {
+		return err
+	}
+	if hello.SetName == "" && hello.Msg != "isdbgrid" {
+		return fmt.Errorf("MongoDB at %s is a standalone server, transactions need a replica set", s.uri)
+	}
+
+	s.collection = client.Database(s.databaseName).Collection(s.collectionName)
+
+	// MongoDB removes expired keys in the background about once a minute.
+	// The expire cycle usually gets to them first, since only it tells the
+	// expiry handlers.
+	_, err = s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (s *MongoStore) Exists(kv KV) (_ bool, err error) {
+	defer observe(OP_EXISTS, time.Now(), &err)
+
+	filter := liveFilter(time.Now().UnixMilli())
+	filter["_id"] = kv.Key
+	count, err := s.collection.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+
+	return count == 1, err
+}
+
+func (s *MongoStore) InitTransaction() (_ Transaction, err error) {
+	defer observe(OP_BEGIN, time.Now(), &err)
+
+	started := time.Now()
+	session, err := s.client.StartSession()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.StartTransaction(); err != nil {
+		session.EndSession(context.Background())
+		return nil, err
+	}
+
+	return &MongoTransaction{
+		store:   s,
+		session: session,
+		ctx:     mongo.NewSessionContext(context.Background(), session),
+		started: started,
+	}, nil
+}
+
+func (s *MongoStore) GetByKey(kv KV) (_ KV, _ bool, err error) {
+	defer observe(OP_GET, time.Now(), &err)
+
+	return s.find(kv.Key, nil)
+}
+
+func (s *MongoStore) GetTypeByKey(kv KV) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_TYPE, time.Now(), &err)
+
+	return s.find(kv.Key, typeProjection)
+}
+
+// find reads the key, removing it if it has expired
+func (s *MongoStore) find(key string, projection bson.M) (KV, bool, error) {
+	opts := options.FindOne()
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	doc := mongoKV{}
+	err := s.collection.FindOne(context.Background(), bson.M{"_id": key}, opts).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return KV{}, false, nil
+	}
+	if err != nil {
+		return KV{}, false, err
+	}
+
+	doc.Key = key
+	keyValue := doc.kv()
+	if isExpired(keyValue) {
+		return KV{}, false, s.expire(key)
+	}
+
+	return keyValue, true, nil
+}
+
+// expire removes a key a read found expired, unless it has been written
+// since
+func (s *MongoStore) expire(key string) error {
+	res, err := s.collection.DeleteOne(context.Background(), expiredFilter(key))
+	if err != nil {
+		return err
+	}
+
+	if res.DeletedCount == 1 {
+		for _, handler := range s.expiryHandlers {
+			handler(key)
+		}
+	}
+	return nil
+}
+
+func (s *MongoStore) GetForUpdate(kv KV, t Transaction) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_FOR_UPDATE, time.Now(), &err)
+
+	return s.lockKey(kv, t, nil)
+}
+
+func (s *MongoStore) GetTypeForUpdate(kv KV, t Transaction) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_TYPE_FOR_UPDATE, time.Now(), &err)
+
+	return s.lockKey(kv, t, typeProjection)
+}
+
+// lockKey holds the key until the transaction ends, so that transactions of
+// this instance on the same key wait for each other. Bumping rev makes the
+// document one of the transaction's writes, so that a transaction of
+// another instance changing it fails with a transient write conflict
+// instead of overwriting it, and is run again. A key that doesn't exist
+// gets a placeholder document without a type, so that two instances
+// creating it conflict in the same way. Expired keys are left for the
+// expire cycle, or for the caller to overwrite.
+func (s *MongoStore) lockKey(kv KV, t Transaction, projection bson.M) (KV, bool, error) {
+	tx := t.(*MongoTransaction)
+	if !slices.Contains(tx.held, kv.Key) {
+		s.locks.lock(kv.Key)
+		tx.held = append(tx.held, kv.Key)
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetUpsert(true)
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	doc := mongoKV{}
+	err := s.collection.FindOneAndUpdate(tx.ctx,
+		bson.M{"_id": kv.Key},
+		bson.M{"$inc": bson.M{"rev": 1}, "$setOnInsert": bson.M{"exp": 0}},
+		opts,
+	).Decode(&doc)
+	if err != nil {
+		t.Abort()
+		return KV{}, false, err
+	}
+	if doc.Typ == "" {
+		if !slices.Contains(tx.placeholders, kv.Key) {
+			tx.placeholders = append(tx.placeholders, kv.Key)
+		}
+		return KV{}, false, nil
+	}
+
+	doc.Key = kv.Key
+	keyValue := doc.kv()
+	if isExpired(keyValue) {
+		return KV{}, false, nil
+	}
+
+	return keyValue, true, nil
+}
+
+func (s *MongoStore) KeyspaceInfo() (_ KeyspaceInfo, err error) {
+	defer observe(OP_COUNT, time.Now(), &err)
+
+	now := time.Now().UnixMilli()
+	expiring := bson.M{"$gt": bson.A{"$exp", 0}}
+	cursor, err := s.collection.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: liveFilter(now)}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "keys", Value: bson.M{"$sum": 1}},
+			{Key: "expires", Value: bson.M{"$sum": bson.M{"$cond": bson.A{expiring, 1, 0}}}},
+			// $avg skips the nulls of keys without an expiry
+			{Key: "avgTTL", Value: bson.M{"$avg": bson.M{"$cond": bson.A{expiring, bson.M{"$subtract": bson.A{"$exp", now}}, nil}}}},
+		}}},
+	})
+	if err != nil {
+		return KeyspaceInfo{}, err
+	}
+
+	rows := []struct {
+		Keys    int      `bson:"keys"`
+		Expires int      `bson:"expires"`
+		AvgTTL  *float64 `bson:"avgTTL"`
+	}{}
+	if err := cursor.All(context.Background(), &rows); err != nil {
+		return KeyspaceInfo{}, err
+	}
+
+	info := KeyspaceInfo{}
+	if len(rows) == 1 {
+		info.Keys = rows[0].Keys
+		info.Expires = rows[0].Expires
+		if rows[0].AvgTTL != nil {
+			info.AvgTTL = int(*rows[0].AvgTTL)
+		}
+	}
+	return info, nil
+}
+
+func (s *MongoStore) CountByType() (_ map[string]int, err error) {
+	defer observe(OP_COUNT_BY_TYPE, time.Now(), &err)
+
+	cursor, err := s.collection.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: liveFilter(time.Now().UnixMilli())}},
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$typ"}, {Key: "count", Value: bson.M{"$sum": 1}}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []struct {
+		Typ   string `bson:"_id"`
+		Count int    `bson:"count"`
+	}{}
+	if err := cursor.All(context.Background(), &rows); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, r := range rows {
+		counts[r.Typ] = r.Count
+	}
+	return counts, nil
+}
+
+func (s *MongoStore) DeleteExpired(limit int) (_ int, err error) {
+	defer observe(OP_DELETE_EXPIRED, time.Now(), &err)
+
+	ctx := context.Background()
+	cursor, err := s.collection.Find(ctx,
+		bson.M{"expireAt": bson.M{"$lt": time.Now()}},
+		options.Find().SetLimit(int64(limit)).SetProjection(bson.M{"_id": 1}),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	docs := []struct {
+		Key string `bson:"_id"`
+	}{}
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+
+	// Each key is removed on its own, so that only keys that were still
+	// expired are reported
+	removed := 0
+	for _, doc := range docs {
+		res, err := s.collection.DeleteOne(ctx, expiredFilter(doc.Key))
+		if err != nil {
+			return removed, err
+		}
+		if res.DeletedCount == 0 {
+			continue
+		}
+
+		removed++
+		for _, handler := range s.expiryHandlers {
+			handler(doc.Key)
+		}
+	}
+	return removed, nil
+}
+
+func (s *MongoStore) Close() error {
+	return s.client.Disconnect(context.Background())
+}
+
+// IsTransient reports the errors MongoDB labels as transient, such as write
+// conflicts
+func (s *MongoStore) IsTransient(err error) bool {
+	return hasErrorLabel(err, MONGO_TRANSIENT_TRANSACTION_ERROR)
+}
+
+func (s *MongoStore) OnExpire(handler ExpiryHandler) {
+	s.expiryHandlers = append(s.expiryHandlers, handler)
+}
+
+// SetKV replaces the whole document of the key
+func (s *MongoStore) SetKV(kv KV, t Transaction) (err error) {
+	defer observe(OP_SET, time.Now(), &err)
+
+	tx := t.(*MongoTransaction)
+	if _, err := s.collection.ReplaceOne(tx.ctx, bson.M{"_id": kv.Key}, newMongoKV(kv), options.Replace().SetUpsert(true)); err != nil {
+		t.Abort()
+		return err
+	}
+
+	return nil
+}
+
+// createKey makes sure the key exists with the type, replacing it if it has
+// expired
+func (s *MongoStore) createKey(ctx context.Context, key string, typ string) error {
+	if _, err := s.collection.DeleteOne(ctx, expiredFilter(key)); err != nil {
+		return err
+	}
+
+	_, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": key},
+		bson.M{"$set": bson.M{"typ": typ}, "$setOnInsert": bson.M{"exp": 0}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// Size of an array field that may be missing, for projections
+func arraySize(field string) bson.M {
+	return bson.M{"$size": bson.M{"$ifNull": bson.A{field, bson.A{}}}}
+}
+
+func (s *MongoStore) ListPush(kv KV, values []string, left bool, t Transaction) (_ int, err error) {
+	defer observe(OP_LIST_PUSH, time.Now(), &err)
+
+	tx := t.(*MongoTransaction)
+	if err := s.createKey(tx.ctx, kv.Key, "list"); err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	push := bson.M{"$each": values}
+	if left {
+		// $position inserts the values in order, while LPUSH adds them to
+		// the head one after the other
+		reversed := slices.Clone(values)
+		slices.Reverse(reversed)
+		push = bson.M{"$each": reversed, "$position": 0}
+	}
+
+	res := struct {
+		Length int `bson:"length"`
+	}{}
+	err = s.collection.FindOneAndUpdate(tx.ctx,
+		bson.M{"_id": kv.Key},
+		bson.M{"$push": bson.M{"arr": push}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(bson.M{"length": arraySize("$arr")}),
+	).Decode(&res)
+	if err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	return res.Length, nil
+}
+
+func (s *MongoStore) ListPop(kv KV, left bool, t Transaction) (_ string, _ bool, _ bool, err error) {
+	defer observe(OP_LIST_POP, time.Now(), &err)
+
+	pop, index := 1, -1
+	if left {
+		pop, index = -1, 0
+	}
+
+	tx := t.(*MongoTransaction)
+	res := struct {
+		Value  string `bson:"value"`
+		Length int    `bson:"length"`
+	}{}
+	err = s.collection.FindOneAndUpdate(tx.ctx,
+		bson.M{"_id": kv.Key},
+		bson.M{"$pop": bson.M{"arr": pop}},
+		options.FindOneAndUpdate().SetReturnDocument(options.Before).SetProjection(bson.M{
+			"value":  bson.M{"$arrayElemAt": bson.A{"$arr", index}},
+			"length": arraySize("$arr"),
+		}),
+	).Decode(&res)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", false, true, nil
+	}
+	if err != nil {
+		t.Abort()
+		return "", false, false, err
+	}
+
+	if res.Length > 1 {
+		return res.Value, true, false, nil
+	}
+
+	// A list without elements is removed too
+	if _, err := s.collection.DeleteOne(tx.ctx, bson.M{"_id": kv.Key}); err != nil {
+		t.Abort()
+		return "", false, false, err
+	}
+	return res.Value, res.Length == 1, true, nil
+}
+
+func (s *MongoStore) SetAdd(kv KV, members []string, t Transaction) (_ int, err error) {
+	defer observe(OP_SET_ADD, time.Now(), &err)
+
+	tx := t.(*MongoTransaction)
+	if err := s.createKey(tx.ctx, kv.Key, "set"); err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	unique := slices.Clone(members)
+	slices.Sort(unique)
+	unique = slices.Compact(unique)
+
+	// $literal keeps members starting with $ from being read as field paths
+	present := struct {
+		Count int `bson:"count"`
+	}{}
+	err = s.collection.FindOne(tx.ctx,
+		bson.M{"_id": kv.Key},
+		options.FindOne().SetProjection(bson.M{"count": bson.M{"$size": bson.M{"$setIntersection": bson.A{
+			bson.M{"$ifNull": bson.A{"$set", bson.A{}}},
+			bson.M{"$literal": unique},
+		}}}}),
+	).Decode(&present)
+	if err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	if _, err := s.collection.UpdateOne(tx.ctx, bson.M{"_id": kv.Key}, bson.M{"$addToSet": bson.M{"set": bson.M{"$each": unique}}}); err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	return len(unique) - present.Count, nil
+}
+
+func (s *MongoStore) SetIsMember(kv KV, member string) (_ bool, err error) {
+	defer observe(OP_SET_IS_MEMBER, time.Now(), &err)
+
+	filter := liveFilter(time.Now().UnixMilli())
+	filter["_id"] = kv.Key
+	filter["set"] = member
+	count, err := s.collection.CountDocuments(context.Background(), filter, options.Count().SetLimit(1))
+
+	return count == 1, err
+}
+
+func (s *MongoStore) DeleteByKey(kv KV, t Transaction) (_ int, err error) {
+	defer observe(OP_DELETE, time.Now(), &err)
+
+	// Placeholders of keys locked by the transaction don't count
+	tx := t.(*MongoTransaction)
+	res, err := s.collection.DeleteOne(tx.ctx, bson.M{"_id": kv.Key, "typ": bson.M{"$exists": true}})
+	if err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	return int(res.DeletedCount), nil
+}
@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// The MongoDB tests run against the replica set at this URI, e.g.
+// "mongodb://localhost:27017/?directConnection=true" for the one in
+// docker-compose.yml, and are skipped when it isn't set
+const MONGODB_TEST_URI = "MONGODB_TEST_URI"
+
+const MONGODB_TEST_DATABASE = "redis_test"
+
+// mongoTestCollection returns the URI and a collection for the test, which
+// is dropped at its end
+func mongoTestCollection(t *testing.T) (string, string) {
+	t.Helper()
+
+	uri := os.Getenv(MONGODB_TEST_URI)
+	if uri == "" {
+		t.Skipf("%s isn't set", MONGODB_TEST_URI)
+	}
+
+	collection := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	t.Cleanup(func() {
+		s := NewMongoStore(uri, MONGODB_TEST_DATABASE, collection)
+		if err := s.init(); err == nil {
+			s.collection.Drop(context.Background())
+			s.client.Disconnect(context.Background())
+		}
+	})
+	return uri, collection
+}
+
+func openMongoStore(t *testing.T, uri string, collection string) *MongoStore {
+	t.Helper()
+
+	s := NewMongoStore(uri, MONGODB_TEST_DATABASE, collection)
+	if err := s.init(); err != nil {
+		t.Fatal(err)
+	}
+	return &s
+}
+
+func TestMongoStore(t *testing.T) {
+	testStore(t, func(t *testing.T) storeOpener {
+		uri, collection := mongoTestCollection(t)
+		return func() Store {
+			return openMongoStore(t, uri, collection)
+		}
+	})
+}
+
+// Two servers updating the same key don't wait for each other, so the
+// second one to hold it gets a transient error to run its command again on.
+// The same goes for a key that doesn't exist yet.
+func TestMongoStoreConflict(t *testing.T) {
+	for _, existing := range []bool{true, false} {
+		t.Run(fmt.Sprintf("existing %v", existing), func(t *testing.T) {
+			uri, collection := mongoTestCollection(t)
+			first := openMongoStore(t, uri, collection)
+			defer first.Close()
+			second := openMongoStore(t, uri, collection)
+			defer second.Close()
+
+			if existing {
+				update(t, first, func(tx Transaction) {
+					if err := first.SetKV(KV{Key: "k", Typ: "string", Str: "v"}, tx); err != nil {
+						t.Fatal(err)
+					}
+				})
+			}
+
+			tx, err := first.InitTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, err := first.GetForUpdate(KV{Key: "k"}, tx); err != nil || ok != existing {
+				t.Fatalf("GetForUpdate returned %v, %v", ok, err)
+			}
+
+			conflicting, err := second.InitTransaction()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = second.GetForUpdate(KV{Key: "k"}, conflicting)
+			if !second.IsTransient(err) {
+				conflicting.Abort()
+				t.Errorf("GetForUpdate of a key held by another server returned %v, want a transient error", err)
+			}
+
+			if err := first.SetKV(KV{Key: "k", Typ: "string", Str: "first"}, tx); err != nil {
+				t.Fatal(err)
+			}
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := mustGet(t, second, "k"); v.Str != "first" {
+				t.Errorf("k is %q, want the value of the transaction that held it", v.Str)
+			}
+		})
+	}
+}
+
+// A key that was locked while missing, and not written, is still missing
+// once the transaction commits
+func TestMongoStorePlaceholder(t *testing.T) {
+	uri, collection := mongoTestCollection(t)
+	s := openMongoStore(t, uri, collection)
+	defer s.Close()
+
+	update(t, s, func(tx Transaction) {
+		if _, ok, err := s.GetForUpdate(KV{Key: "missing"}, tx); err != nil || ok {
+			t.Errorf("GetForUpdate of a missing key returned %v, %v", ok, err)
+		}
+		if _, ok, err := s.GetTypeForUpdate(KV{Key: "missing"}, tx); err != nil || ok {
+			t.Errorf("GetTypeForUpdate of a missing key locked before returned %v, %v", ok, err)
+		}
+		if n, err := s.DeleteByKey(KV{Key: "missing"}, tx); err != nil || n != 0 {
+			t.Errorf("DeleteByKey of a missing key returned %d, %v, want 0", n, err)
+		}
+	})
+
+	if ok, err := s.Exists(KV{Key: "missing"}); err != nil || ok {
+		t.Errorf("Exists returned %v, %v for a key that was only locked", ok, err)
+	}
+	count, err := s.collection.CountDocuments(context.Background(), bson.M{})
+	if err != nil || count != 0 {
+		t.Errorf("the collection has %d documents, %v, want none", count, err)
+	}
+}
+
+func TestMongoStoreIsTransient(t *testing.T) {
+	s := MongoStore{}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{mongo.CommandError{Code: 112, Name: "WriteConflict", Labels: []string{MONGO_TRANSIENT_TRANSACTION_ERROR}}, true},
+		{fmt.Errorf("committing: %w", mongo.CommandError{Labels: []string{MONGO_TRANSIENT_TRANSACTION_ERROR}}), true},
+		{mongo.CommandError{Labels: []string{MONGO_UNKNOWN_COMMIT_RESULT}}, false},
+		{mongo.WriteException{WriteErrors: mongo.WriteErrors{{Code: 11000}}}, false},
+		{errors.New("WriteConflict"), false},
+	}
+	for _, test := range tests {
+		if got := s.IsTransient(test.err); got != test.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
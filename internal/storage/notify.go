@@ -0,0 +1,27 @@
+package storage
+
+// Notifier is implemented by stores that can relay messages between server
+// instances sharing the same backend. Payloads are at most
+// MAX_NOTIFY_PAYLOAD bytes long.
+type Notifier interface {
+	Notify(channel string, payload string) error
+	Listen(channel string) (<-chan string, error)
+}
+
+// pg_notify refuses payloads of 8000 bytes or more
+const MAX_NOTIFY_PAYLOAD = 7999
+
+// KeyChange describes a key written by another server instance sharing the
+// backend. Truncated is set when the key was too long to be sent, in which
+// case any key may have changed.
+type KeyChange struct {
+	Key       string
+	Op        string
+	Truncated bool
+}
+
+// ChangeFeed is implemented by stores that can report the keys changed by
+// other server instances sharing the backend.
+type ChangeFeed interface {
+	Changes() (<-chan KeyChange, error)
+}
@@ -0,0 +1,46 @@
+package storage
+
+import "time"
+
+// Operations reported to the observer
+const (
+	OP_EXISTS              = "exists"
+	OP_GET                 = "get"
+	OP_GET_FOR_UPDATE      = "get_for_update"
+	OP_GET_TYPE            = "get_type"
+	OP_GET_TYPE_FOR_UPDATE = "get_type_for_update"
+	OP_LIST_PUSH           = "list_push"
+	OP_LIST_POP            = "list_pop"
+	OP_SET_ADD             = "set_add"
+	OP_SET_IS_MEMBER       = "set_is_member"
+	OP_SET                 = "set"
+	OP_DELETE              = "delete"
+	OP_BEGIN               = "begin"
+	OP_COMMIT              = "commit"
+	OP_ABORT               = "abort"
+	OP_TRANSACTION         = "transaction"
+	OP_COUNT               = "count"
+	OP_COUNT_BY_TYPE       = "count_by_type"
+	OP_DELETE_EXPIRED      = "delete_expired"
+)
+
+// Observer is told how long every store call took and whether it failed.
+// OP_TRANSACTION covers a whole transaction, from begin to commit or abort.
+type Observer func(op string, duration time.Duration, err error)
+
+var observer Observer
+
+// SetObserver registers the observer. It must be called before the store is
+// used.
+func SetObserver(o Observer) {
+	observer = o
+}
+
+// observe is deferred at the start of a store method, with a pointer to its
+// error result so that the error is read when the method returns
+func observe(op string, start time.Time, err *error) {
+	if observer == nil {
+		return
+	}
+	observer(op, time.Since(start), *err)
+}
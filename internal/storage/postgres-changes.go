@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"encoding/json"
+
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+)
+
+const CHANGE_FEED_CHANNEL = "redis_kv_changes"
+
+// The trigger tags every change with the instance that made it, taken from a
+// transaction local setting, so that instances can skip their own writes.
+// pg_notify payloads are limited to 8000 bytes so very long keys are sent
+// without the key.
+const changeFeedTrigger = `
+CREATE OR REPLACE FUNCTION redis_notify_kv_change() RETURNS trigger AS $$
+DECLARE
+	k text;
+BEGIN
+	IF TG_OP = 'DELETE' THEN
+		k := OLD.key;
+	ELSE
+		k := NEW.key;
+	END IF;
+	IF octet_length(k) > 7000 THEN
+		k := NULL;
+	END IF;
+	PERFORM pg_notify('` + CHANGE_FEED_CHANNEL + `', json_build_object(
+		'instance', current_setting('redis.instance_id', true),
+		'key', k,
+		'op', lower(TG_OP)
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE TRIGGER redis_kv_changes AFTER INSERT OR UPDATE OR DELETE ON kvs
+	FOR EACH ROW EXECUTE FUNCTION redis_notify_kv_change();
+`
+
+type changePayload struct {
+	Instance *string `json:"instance"`
+	Key      *string `json:"key"`
+	Op       string  `json:"op"`
+}
+
+// Changes installs the change feed trigger and streams the keys written by
+// other instances.
+func (s *PostgresStore) Changes() (<-chan KeyChange, error) {
+	if err := s.database.Exec(changeFeedTrigger).Error; err != nil {
+		return nil, err
+	}
+
+	payloads, err := s.Listen(CHANGE_FEED_CHANNEL)
+	if err != nil {
+		return nil, err
+	}
+	s.tagTransactions = true
+
+	changes := make(chan KeyChange, 1024)
+	go func() {
+		for payload := range payloads {
+			p := changePayload{}
+			if err := json.Unmarshal([]byte(payload), &p); err != nil {
+				logger.Warning("Invalid change feed payload: %v", err)
+				continue
+			}
+
+			if p.Instance != nil && *p.Instance == InstanceID {
+				continue
+			}
+
+			if p.Key == nil {
+				changes <- KeyChange{Op: p.Op, Truncated: true}
+				continue
+			}
+			changes <- KeyChange{Key: *p.Key, Op: p.Op}
+		}
+	}()
+
+	return changes, nil
+}
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"gorm.io/gorm"
+
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+)
+
+// Every instance takes this advisory lock while migrating, so that instances
+// starting together don't apply the same migration twice
+const MIGRATION_LOCK = 6379
+
+// migration upgrades the schema by one version. Applied versions are recorded
+// in schema_migrations, and migrations are never changed once released, only
+// followed by new ones.
+type migration struct {
+	version     int
+	description string
+	up          string
+}
+
+var migrations = []migration{
+	{
+		// The table as AutoMigrate created it, so that databases it created
+		// are at version 1 too. The set column holds JSON in a bytea.
+		version:     1,
+		description: "key value table",
+		up: `
+CREATE TABLE IF NOT EXISTS kvs (
+	typ text NOT NULL,
+	key text NOT NULL,
+	str text,
+	arr varchar[],
+	"set" bytea,
+	exp bigint NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_name ON kvs (key);
+`,
+	},
+	{
+		// Lists, sets and hashes move to a row per element, so that commands
+		// change single elements instead of rewriting the whole value. Rows
+		// are removed along with their key.
+		version:     2,
+		description: "per type tables",
+		up: `
+CREATE TABLE list_elements (
+	key text NOT NULL REFERENCES kvs (key) ON DELETE CASCADE ON UPDATE CASCADE,
+	pos bigint NOT NULL,
+	value text NOT NULL,
+	PRIMARY KEY (key, pos)
+);
+CREATE TABLE set_members (
+	key text NOT NULL REFERENCES kvs (key) ON DELETE CASCADE ON UPDATE CASCADE,
+	member text NOT NULL,
+	PRIMARY KEY (key, member)
+);
+CREATE TABLE hash_fields (
+	key text NOT NULL REFERENCES kvs (key) ON DELETE CASCADE ON UPDATE CASCADE,
+	field text NOT NULL,
+	value text NOT NULL,
+	PRIMARY KEY (key, field)
+);
+INSERT INTO list_elements (key, pos, value)
+	SELECT kvs.key, e.ord - 1, e.value
+	FROM kvs, unnest(kvs.arr) WITH ORDINALITY AS e(value, ord)
+	WHERE kvs.typ = 'list';
+INSERT INTO set_members (key, member)
+	SELECT key, jsonb_object_keys(members)
+	FROM (SELECT key, convert_from("set", 'UTF8')::jsonb AS members FROM kvs WHERE typ = 'set' AND "set" IS NOT NULL) s
+	WHERE jsonb_typeof(members) = 'object';
+ALTER TABLE kvs DROP COLUMN arr, DROP COLUMN "set";
+`,
+	},
+}
+
+// migrate brings the schema up to the latest version in one transaction
+func (s *PostgresStore) migrate() error {
+	return s.migrateTo(migrations[len(migrations)-1].version)
+}
+
+// migrateTo applies the migrations up to and including target
+func (s *PostgresStore) migrateTo(target int) error {
+	return s.database.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", MIGRATION_LOCK).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("CREATE TABLE IF NOT EXISTS schema_migrations (version integer PRIMARY KEY, description text NOT NULL, applied_at timestamptz NOT NULL DEFAULT now())").Error; err != nil {
+			return err
+		}
+
+		version := 0
+		if err := tx.Raw("SELECT coalesce(max(version), 0) FROM schema_migrations").Scan(&version).Error; err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if m.version <= version || m.version > target {
+				continue
+			}
+			if err := tx.Exec(m.up).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("INSERT INTO schema_migrations (version, description) VALUES (?, ?)", m.version, m.description).Error; err != nil {
+				return err
+			}
+			logger.Notice("Migrated the database to version %d: %s", m.version, m.description)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func schemaVersion(t *testing.T, s *PostgresStore) int {
+	t.Helper()
+
+	version := 0
+	if err := s.database.Raw("SELECT max(version) FROM schema_migrations").Scan(&version).Error; err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// A database created before lists and sets had their own tables keeps its
+// values when migrated, with lists in the same order
+func TestPostgresMigrateValues(t *testing.T) {
+	s := newPostgresTestStore(t)
+	if err := s.migrateTo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rows as AutoMigrate wrote them, where keys that aren't sets have a
+	// JSON null in the set column
+	seed := []struct {
+		typ string
+		key string
+		str string
+		arr pq.StringArray
+		set string
+	}{
+		{"string", "string", "value", nil, "null"},
+		{"list", "list", "", pq.StringArray{"c", "b", "a", "b"}, "null"},
+		{"set", "set", "", nil, `{"x":{},"y":{},"z z":{}}`},
+	}
+	for _, r := range seed {
+		if err := s.database.Exec(`INSERT INTO kvs (typ, key, str, arr, "set", exp) VALUES (?, ?, ?, ?, ?, 0)`, r.typ, r.key, r.str, r.arr, []byte(r.set)).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := schemaVersion(t, s), migrations[len(migrations)-1].version; got != want {
+		t.Errorf("migrated to version %d, want %d", got, want)
+	}
+
+	str, ok, err := s.GetByKey(KV{Key: "string"})
+	if err != nil || !ok || str.Str != "value" {
+		t.Errorf("GetByKey(string) returned %+v, %v, %v", str, ok, err)
+	}
+
+	list, ok, err := s.GetByKey(KV{Key: "list"})
+	if err != nil || !ok {
+		t.Fatalf("GetByKey(list) returned %v, %v", ok, err)
+	}
+	if want := []string{"c", "b", "a", "b"}; !slices.Equal(list.Arr, want) {
+		t.Errorf("the list is %q, want %q", list.Arr, want)
+	}
+
+	for member, want := range map[string]bool{"x": true, "y": true, "z z": true, "w": false} {
+		if got, err := s.SetIsMember(KV{Key: "set"}, member); err != nil || got != want {
+			t.Errorf("SetIsMember(%q) returned %v, %v, want %v", member, got, err, want)
+		}
+	}
+
+	// Positions carry on from the migrated ones at both ends
+	tx, err := s.InitTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListPush(KV{Key: "list"}, []string{"head"}, true, tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListPush(KV{Key: "list"}, []string{"tail"}, false, tx); err != nil {
+		t.Fatal(err)
+	}
+	if added, err := s.SetAdd(KV{Key: "set"}, []string{"x", "w"}, tx); err != nil || added != 1 {
+		t.Errorf("SetAdd returned %d, %v, want 1", added, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	list, _, err = s.GetByKey(KV{Key: "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"head", "c", "b", "a", "b", "tail"}; !slices.Equal(list.Arr, want) {
+		t.Errorf("the list is %q after pushing, want %q", list.Arr, want)
+	}
+
+	columns := 0
+	if err := s.database.Raw(`SELECT count(*) FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = 'kvs' AND column_name IN ('arr', 'set')`).Scan(&columns).Error; err != nil {
+		t.Fatal(err)
+	}
+	if columns != 0 {
+		t.Errorf("kvs still has %d of the arr and set columns", columns)
+	}
+}
+
+// Migrating a new database goes straight to the latest version, and
+// migrating again changes nothing
+func TestPostgresMigrateAgain(t *testing.T) {
+	s := newPostgresTestStore(t)
+	for i := 0; i < 2; i++ {
+		if err := s.migrate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := schemaVersion(t, s), migrations[len(migrations)-1].version; got != want {
+		t.Errorf("migrated to version %d, want %d", got, want)
+	}
+	applied := 0
+	if err := s.database.Raw("SELECT count(*) FROM schema_migrations").Scan(&applied).Error; err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("%d migrations were recorded, want %d", applied, len(migrations))
+	}
+}
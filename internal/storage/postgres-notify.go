@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+)
+
+func (s *PostgresStore) Notify(channel string, payload string) error {
+	return s.database.Exec("SELECT pg_notify(?, ?)", channel, payload).Error
+}
+
+// Listen opens a dedicated connection that LISTENs on channel and forwards
+// every notification payload. The connection is re-established if it drops.
+func (s *PostgresStore) Listen(channel string) (<-chan string, error) {
+	conn, err := s.listen(channel)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads := make(chan string, 1024)
+	go func() {
+		for {
+			n, err := conn.WaitForNotification(context.Background())
+			if err == nil {
+				payloads <- n.Payload
+				continue
+			}
+
+			logger.Warning("Lost LISTEN connection for '%s': %v", channel, err)
+			conn.Close(context.Background())
+			for {
+				time.Sleep(time.Second)
+				if conn, err = s.listen(channel); err == nil {
+					break
+				}
+				logger.Warning("Reconnecting LISTEN connection for '%s': %v", channel, err)
+			}
+		}
+	}()
+
+	return payloads, nil
+}
+
+func (s *PostgresStore) listen(channel string) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(context.Background(), s.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Exec(context.Background(), "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, err
+	}
+
+	return conn, nil
+}
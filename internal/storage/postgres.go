@@ -1,25 +1,34 @@
 package storage
 
 import (
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
 type PostgresTransaction struct {
-	tx *gorm.DB
+	tx      *gorm.DB
+	started time.Time
 }
 
-func (t PostgresTransaction) Commit() error {
+func (t PostgresTransaction) Commit() (err error) {
+	defer observe(OP_TRANSACTION, t.started, &err)
+	defer observe(OP_COMMIT, time.Now(), &err)
+
 	if err := t.tx.Commit().Error; err != nil {
 		return err
 	}
 	return nil
 }
 
-func (t PostgresTransaction) Abort() error {
+func (t PostgresTransaction) Abort() (err error) {
+	defer observe(OP_TRANSACTION, t.started, &err)
+	defer observe(OP_ABORT, time.Now(), &err)
+
 	if err := t.tx.Rollback().Error; err != nil {
 		return err
 	}
@@ -27,30 +36,47 @@ func (t PostgresTransaction) Abort() error {
 }
 
 type PostgresStore struct {
-	database *gorm.DB
+	database       *gorm.DB
+	dsn            string
+	expiryHandlers []ExpiryHandler
+	// Set once the change feed is running so that transactions record the
+	// instance making them
+	tagTransactions bool
 }
 
-func NewPostgresStore() PostgresStore {
-	return PostgresStore{}
+func NewPostgresStore(dsn string) PostgresStore {
+	return PostgresStore{dsn: dsn}
 }
 
 func (s *PostgresStore) init() error {
-	dsn := "host=localhost user=redis password=redis dbname=redis port=5432"
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(s.dsn), &gorm.Config{})
 	if err != nil {
 		return err
 	}
 
 	s.database = db
-	if err := db.AutoMigrate(&KV{}); err != nil {
-		return err
-	}
+	return s.migrate()
+}
 
-	return nil
+// listElement is a row of list_elements. Elements are only ever added or
+// removed at either end, so the positions of a list are consecutive and its
+// length is the distance between the first and last.
+type listElement struct {
+	Key   string
+	Pos   int64
+	Value string
 }
 
-func (s *PostgresStore) Exists(kv KV) (bool, error) {
-	res := s.database.Where("key = ?", kv.Key).First(&KV{})
+// setMember is a row of set_members
+type setMember struct {
+	Key    string
+	Member string
+}
+
+func (s *PostgresStore) Exists(kv KV) (_ bool, err error) {
+	defer observe(OP_EXISTS, time.Now(), &err)
+
+	res := s.database.Where("key = ? AND (exp = 0 OR exp > ?)", kv.Key, time.Now().UnixMilli()).Limit(1).Find(&KV{})
 
 	if res.Error != nil {
 		return false, res.Error
@@ -59,17 +85,29 @@ func (s *PostgresStore) Exists(kv KV) (bool, error) {
 	return res.RowsAffected == 1, nil
 }
 
-func (s *PostgresStore) InitTransaction() (Transaction, error) {
+func (s *PostgresStore) InitTransaction() (_ Transaction, err error) {
+	defer observe(OP_BEGIN, time.Now(), &err)
+
+	started := time.Now()
 	res := s.database.Begin()
 
 	if res.Error != nil {
 		return PostgresTransaction{}, res.Error
 	}
 
-	return PostgresTransaction{tx: res}, nil
+	if s.tagTransactions {
+		if err := res.Exec("SELECT set_config('redis.instance_id', ?, true)", InstanceID).Error; err != nil {
+			res.Rollback()
+			return PostgresTransaction{}, err
+		}
+	}
+
+	return PostgresTransaction{tx: res, started: started}, nil
 }
 
-func (s *PostgresStore) GetByKey(kv KV) (KV, bool, error) {
+func (s *PostgresStore) GetByKey(kv KV) (_ KV, _ bool, err error) {
+	defer observe(OP_GET, time.Now(), &err)
+
 	keyValue := KV{}
 	res := s.database.Where("key = ?", kv.Key).Limit(1).First(&keyValue)
 
@@ -83,36 +121,394 @@ func (s *PostgresStore) GetByKey(kv KV) (KV, bool, error) {
 
 	now := int(time.Now().Unix()) * 1000
 	if keyValue.Exp > 0 && keyValue.Exp < now {
-		tx, err := s.InitTransaction()
-		if err != nil {
-			return KV{}, false, err
+		return s.expire(kv)
+	}
+
+	if err := loadValue(s.database, &keyValue); err != nil {
+		return KV{}, false, err
+	}
+
+	return keyValue, true, nil
+}
+
+// GetTypeByKey is GetByKey without loading the elements of lists and sets
+func (s *PostgresStore) GetTypeByKey(kv KV) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_TYPE, time.Now(), &err)
+
+	keyValue := KV{}
+	res := s.database.Where("key = ?", kv.Key).Limit(1).Find(&keyValue)
+	if res.Error != nil {
+		return KV{}, false, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return KV{}, false, nil
+	}
+
+	if keyValue.Exp > 0 && int64(keyValue.Exp) < time.Now().UnixMilli() {
+		return s.expire(kv)
+	}
+
+	return keyValue, true, nil
+}
+
+// expire removes a key found to have expired when it was read, and tells the
+// expiry handlers
+func (s *PostgresStore) expire(kv KV) (KV, bool, error) {
+	tx, err := s.InitTransaction()
+	if err != nil {
+		return KV{}, false, err
+	}
+	if _, err := s.DeleteByKey(KV{Key: kv.Key}, tx); err != nil {
+		return KV{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return KV{}, false, err
+	}
+	for _, handler := range s.expiryHandlers {
+		handler(kv.Key)
+	}
+	return KV{}, false, nil
+}
+
+// GetForUpdate locks the key with GetTypeForUpdate, then reads its value
+func (s *PostgresStore) GetForUpdate(kv KV, t Transaction) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_FOR_UPDATE, time.Now(), &err)
+
+	keyValue, ok, err := s.lockKey(kv, t)
+	if err != nil || !ok {
+		return KV{}, false, err
+	}
+
+	if err := loadValue(t.(PostgresTransaction).tx, &keyValue); err != nil {
+		t.Abort()
+		return KV{}, false, err
+	}
+
+	return keyValue, true, nil
+}
+
+func (s *PostgresStore) GetTypeForUpdate(kv KV, t Transaction) (_ KV, _ bool, err error) {
+	defer observe(OP_GET_TYPE_FOR_UPDATE, time.Now(), &err)
+
+	return s.lockKey(kv, t)
+}
+
+// lockKey takes a transaction scoped advisory lock on the key's hash before
+// selecting the row FOR UPDATE, since a row lock alone can't stop two
+// transactions from both creating a key that doesn't exist yet. Expired rows
+// are left for the expire cycle, or for the caller to overwrite.
+func (s *PostgresStore) lockKey(kv KV, t Transaction) (KV, bool, error) {
+	tx := t.(PostgresTransaction).tx
+	if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", kv.Key).Error; err != nil {
+		t.Abort()
+		return KV{}, false, err
+	}
+
+	keyValue := KV{}
+	res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", kv.Key).Limit(1).Find(&keyValue)
+	if res.Error != nil {
+		t.Abort()
+		return KV{}, false, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return KV{}, false, nil
+	}
+
+	if keyValue.Exp > 0 && int64(keyValue.Exp) < time.Now().UnixMilli() {
+		return KV{}, false, nil
+	}
+
+	return keyValue, true, nil
+}
+
+// loadValue reads the elements of a list or set from their table
+func loadValue(db *gorm.DB, kv *KV) error {
+	switch kv.Typ {
+	case "list":
+		values := []string{}
+		if err := db.Model(&listElement{}).Where("key = ?", kv.Key).Order("pos").Pluck("value", &values).Error; err != nil {
+			return err
 		}
-		if _, err := s.DeleteByKey(KV{Key: kv.Key}, tx); err != nil {
-			tx.Abort()
-			return KV{}, false, err
+		kv.Arr = values
+	case "set":
+		members := []string{}
+		if err := db.Model(&setMember{}).Where("key = ?", kv.Key).Pluck("member", &members).Error; err != nil {
+			return err
 		}
-		if err := tx.Commit(); err != nil {
-			return KV{}, false, err
+		kv.Set = JSONB{}
+		for _, m := range members {
+			kv.Set[m] = struct{}{}
 		}
-		return KV{}, false, nil
 	}
 
-	return keyValue, true, nil
+	return nil
+}
+
+func (s *PostgresStore) KeyspaceInfo() (_ KeyspaceInfo, err error) {
+	defer observe(OP_COUNT, time.Now(), &err)
+
+	now := time.Now().UnixMilli()
+	info := KeyspaceInfo{}
+	res := s.database.Model(&KV{}).
+		Select("count(*) AS keys, count(*) FILTER (WHERE exp > 0) AS expires, coalesce(avg(exp - ?) FILTER (WHERE exp > 0), 0)::bigint AS avg_ttl", now).
+		Where("exp = 0 OR exp > ?", now).
+		Scan(&info)
+
+	return info, res.Error
+}
+
+func (s *PostgresStore) CountByType() (_ map[string]int, err error) {
+	defer observe(OP_COUNT_BY_TYPE, time.Now(), &err)
+
+	rows := []struct {
+		Typ   string
+		Count int
+	}{}
+	res := s.database.Model(&KV{}).
+		Select("typ, count(*) AS count").
+		Where("exp = 0 OR exp > ?", time.Now().UnixMilli()).
+		Group("typ").
+		Scan(&rows)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+
+	counts := map[string]int{}
+	for _, r := range rows {
+		counts[r.Typ] = r.Count
+	}
+	return counts, nil
+}
+
+func (s *PostgresStore) DeleteExpired(limit int) (_ int, err error) {
+	defer observe(OP_DELETE_EXPIRED, time.Now(), &err)
+
+	keys := []string{}
+	res := s.database.Raw(
+		"DELETE FROM kvs WHERE key IN (SELECT key FROM kvs WHERE exp > 0 AND exp < ? LIMIT ?) RETURNING key",
+		time.Now().UnixMilli(), limit,
+	).Scan(&keys)
+	if res.Error != nil {
+		return 0, res.Error
+	}
+
+	for _, key := range keys {
+		for _, handler := range s.expiryHandlers {
+			handler(key)
+		}
+	}
+	return len(keys), nil
 }
 
-func (s *PostgresStore) SetKV(kv KV, t Transaction) error {
-	if err := t.(PostgresTransaction).tx.Clauses(clause.OnConflict{
+func (s *PostgresStore) Close() error {
+	db, err := s.database.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// SQLSTATEs of the transactions Postgres aborts to resolve a conflict with
+// another transaction
+const (
+	POSTGRES_SERIALIZATION_FAILURE = "40001"
+	POSTGRES_DEADLOCK_DETECTED     = "40P01"
+)
+
+// IsTransient reports serialization failures and deadlocks, after which the
+// transaction can be run again
+func (s *PostgresStore) IsTransient(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == POSTGRES_SERIALIZATION_FAILURE || pgErr.Code == POSTGRES_DEADLOCK_DETECTED
+}
+
+func (s *PostgresStore) OnExpire(handler ExpiryHandler) {
+	s.expiryHandlers = append(s.expiryHandlers, handler)
+}
+
+// SetKV replaces the whole value of the key, including any elements of the
+// value it had before
+func (s *PostgresStore) SetKV(kv KV, t Transaction) (err error) {
+	defer observe(OP_SET, time.Now(), &err)
+
+	tx := t.(PostgresTransaction).tx
+	if err := tx.Clauses(clause.OnConflict{
 		Columns:   []clause.Column{{Name: "key"}},
-		DoUpdates: clause.AssignmentColumns([]string{"typ", "arr", "set", "str", "exp"}),
+		DoUpdates: clause.AssignmentColumns([]string{"typ", "str", "exp"}),
 	}).Create(&kv).Error; err != nil {
 		t.Abort()
 		return err
 	}
 
+	if err := tx.Exec(
+		"WITH l AS (DELETE FROM list_elements WHERE key = ?), s AS (DELETE FROM set_members WHERE key = ?) DELETE FROM hash_fields WHERE key = ?",
+		kv.Key, kv.Key, kv.Key,
+	).Error; err != nil {
+		t.Abort()
+		return err
+	}
+
+	switch {
+	case kv.Typ == "list" && len(kv.Arr) > 0:
+		elements := []listElement{}
+		for i, v := range kv.Arr {
+			elements = append(elements, listElement{Key: kv.Key, Pos: int64(i), Value: v})
+		}
+		err = tx.Create(&elements).Error
+	case kv.Typ == "set" && len(kv.Set) > 0:
+		members := []setMember{}
+		for m := range kv.Set {
+			members = append(members, setMember{Key: kv.Key, Member: m})
+		}
+		err = tx.Create(&members).Error
+	}
+	if err != nil {
+		t.Abort()
+		return err
+	}
+
 	return nil
 }
 
-func (s *PostgresStore) DeleteByKey(kv KV, t Transaction) (int, error) {
+// createKey makes sure the key exists with the type, replacing it if it has
+// expired. Updating an existing row also tells the change feed about the
+// write, since the trigger is on kvs.
+func createKey(tx *gorm.DB, key string, typ string) error {
+	if err := tx.Where("key = ? AND exp > 0 AND exp < ?", key, time.Now().UnixMilli()).Delete(&KV{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"typ"}),
+	}).Create(&KV{Key: key, Typ: typ}).Error
+}
+
+func (s *PostgresStore) ListPush(kv KV, values []string, left bool, t Transaction) (_ int, err error) {
+	defer observe(OP_LIST_PUSH, time.Now(), &err)
+
+	tx := t.(PostgresTransaction).tx
+	if err := createKey(tx, kv.Key, "list"); err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	bounds := struct {
+		First *int64
+		Last  *int64
+	}{}
+	if err := tx.Model(&listElement{}).Select("min(pos) AS first, max(pos) AS last").Where("key = ?", kv.Key).Scan(&bounds).Error; err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	// An empty list starts at 0 whichever end is pushed to
+	first, last := int64(0), int64(-1)
+	if bounds.First != nil && bounds.Last != nil {
+		first, last = *bounds.First, *bounds.Last
+	}
+
+	elements := []listElement{}
+	for _, v := range values {
+		if left {
+			first--
+			elements = append(elements, listElement{Key: kv.Key, Pos: first, Value: v})
+		} else {
+			last++
+			elements = append(elements, listElement{Key: kv.Key, Pos: last, Value: v})
+		}
+	}
+	if err := tx.Create(&elements).Error; err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	return int(last - first + 1), nil
+}
+
+func (s *PostgresStore) ListPop(kv KV, left bool, t Transaction) (_ string, _ bool, _ bool, err error) {
+	defer observe(OP_LIST_POP, time.Now(), &err)
+
+	end := "max"
+	if left {
+		end = "min"
+	}
+
+	tx := t.(PostgresTransaction).tx
+	values := []string{}
+	res := tx.Raw(
+		"DELETE FROM list_elements WHERE key = ? AND pos = (SELECT "+end+"(pos) FROM list_elements WHERE key = ?) RETURNING value",
+		kv.Key, kv.Key,
+	).Scan(&values)
+	if res.Error != nil {
+		t.Abort()
+		return "", false, false, res.Error
+	}
+
+	remaining := false
+	if err := tx.Raw("SELECT EXISTS (SELECT 1 FROM list_elements WHERE key = ?)", kv.Key).Scan(&remaining).Error; err != nil {
+		t.Abort()
+		return "", false, false, err
+	}
+
+	// Touching the row of a list that still has elements tells the change
+	// feed about the write. A list left without elements is removed, even
+	// if there was nothing to pop.
+	if remaining {
+		err = tx.Exec("UPDATE kvs SET typ = typ WHERE key = ?", kv.Key).Error
+	} else {
+		err = tx.Where("key = ?", kv.Key).Delete(&KV{}).Error
+	}
+	if err != nil {
+		t.Abort()
+		return "", false, false, err
+	}
+
+	if len(values) == 0 {
+		return "", false, true, nil
+	}
+	return values[0], true, !remaining, nil
+}
+
+func (s *PostgresStore) SetAdd(kv KV, members []string, t Transaction) (_ int, err error) {
+	defer observe(OP_SET_ADD, time.Now(), &err)
+
+	tx := t.(PostgresTransaction).tx
+	if err := createKey(tx, kv.Key, "set"); err != nil {
+		t.Abort()
+		return 0, err
+	}
+
+	rows := []setMember{}
+	for _, m := range members {
+		rows = append(rows, setMember{Key: kv.Key, Member: m})
+	}
+	res := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&rows)
+	if res.Error != nil {
+		t.Abort()
+		return 0, res.Error
+	}
+
+	return int(res.RowsAffected), nil
+}
+
+func (s *PostgresStore) SetIsMember(kv KV, member string) (_ bool, err error) {
+	defer observe(OP_SET_IS_MEMBER, time.Now(), &err)
+
+	exists := false
+	res := s.database.Raw("SELECT EXISTS (SELECT 1 FROM set_members WHERE key = ? AND member = ?)", kv.Key, member).Scan(&exists)
+
+	return exists, res.Error
+}
+
+func (s *PostgresStore) DeleteByKey(kv KV, t Transaction) (_ int, err error) {
+	defer observe(OP_DELETE, time.Now(), &err)
+
 	res := t.(PostgresTransaction).tx.Where("key = ?", kv.Key).Delete(&KV{})
 
 	if res.Error != nil {
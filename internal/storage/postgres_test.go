@@ -0,0 +1,124 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// The Postgres tests run against the database in this variable, e.g.
+// "host=localhost user=redis password=redis dbname=redis port=5432" for the
+// one in docker-compose.yml, and are skipped when it isn't set
+const POSTGRES_TEST_DSN = "POSTGRES_TEST_DSN"
+
+// postgresTestDSN creates a schema for the test, which is dropped at its end,
+// and returns a DSN whose connections use it
+func postgresTestDSN(t *testing.T) string {
+	t.Helper()
+
+	dsn := os.Getenv(POSTGRES_TEST_DSN)
+	if dsn == "" {
+		t.Skipf("%s isn't set", POSTGRES_TEST_DSN)
+	}
+
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA " + schema + " CASCADE")
+		if db, err := admin.DB(); err == nil {
+			db.Close()
+		}
+	})
+
+	// Every connection of a store's pool has to use the schema, so it is
+	// set in the DSN rather than with SET
+	if strings.Contains(dsn, "://") {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		return dsn + separator + "search_path=" + schema
+	}
+	return dsn + " search_path=" + schema
+}
+
+// newPostgresTestStore returns a store connected to a schema of its own
+// without migrating it
+func newPostgresTestStore(t *testing.T) *PostgresStore {
+	t.Helper()
+
+	dsn := postgresTestDSN(t)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &PostgresStore{database: db, dsn: dsn}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestPostgresStore(t *testing.T) {
+	testStore(t, func(t *testing.T) storeOpener {
+		dsn := postgresTestDSN(t)
+		return func() Store {
+			s := NewPostgresStore(dsn)
+			if err := s.init(); err != nil {
+				t.Fatal(err)
+			}
+			return &s
+		}
+	})
+}
+
+// A list row left without elements pops nothing, and is removed
+func TestPostgresPopEmptyList(t *testing.T) {
+	s := newPostgresTestStore(t)
+	if err := s.migrate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.database.Exec("INSERT INTO kvs (typ, key, str, exp) VALUES ('list', 'empty', '', 0)").Error; err != nil {
+		t.Fatal(err)
+	}
+
+	update(t, s, func(tx Transaction) {
+		value, popped, emptied, err := s.ListPop(KV{Key: "empty"}, true, tx)
+		if err != nil || popped || !emptied {
+			t.Errorf("ListPop returned %q, %v, %v, %v, want nothing popped and the list removed", value, popped, emptied, err)
+		}
+	})
+	if _, ok := mustGet(t, s, "empty"); ok {
+		t.Error("the list without elements still exists")
+	}
+}
+
+func TestPostgresStoreIsTransient(t *testing.T) {
+	s := PostgresStore{}
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&pgconn.PgError{Code: POSTGRES_SERIALIZATION_FAILURE}, true},
+		{fmt.Errorf("locking: %w", &pgconn.PgError{Code: POSTGRES_DEADLOCK_DETECTED}), true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{errors.New("deadlock detected"), false},
+	}
+	for _, test := range tests {
+		if got := s.IsTransient(test.err); got != test.want {
+			t.Errorf("IsTransient(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
@@ -1,12 +1,6 @@
 package storage
 
-import (
-	"database/sql/driver"
-	"encoding/json"
-	"fmt"
-
-	"github.com/lib/pq"
-)
+import "github.com/lib/pq"
 
 type Transaction interface {
 	Abort() error
@@ -17,40 +11,114 @@ type Store interface {
 	init() error
 	Exists(KV) (bool, error)
 	GetByKey(KV) (KV, bool, error)
+	// GetForUpdate reads a key inside a transaction and holds a lock on it
+	// until the transaction ends, so that read-modify-write commands on the
+	// same key run one after the other instead of losing updates. The key
+	// doesn't need to exist to be locked. Expired keys are reported missing.
+	GetForUpdate(KV, Transaction) (KV, bool, error)
+	// GetTypeByKey and GetTypeForUpdate are GetByKey and GetForUpdate without
+	// reading the value, only the type and expiry, for commands that change
+	// or query lists and sets an element at a time
+	GetTypeByKey(KV) (KV, bool, error)
+	GetTypeForUpdate(KV, Transaction) (KV, bool, error)
 	SetKV(KV, Transaction) error
+	// ListPush adds the values one after the other to the head of the list,
+	// or to its tail if left is false, creating the list if the key doesn't
+	// exist. It returns the new length of the list.
+	ListPush(kv KV, values []string, left bool, t Transaction) (int, error)
+	// ListPop removes the first element of the list, or the last if left is
+	// false. It reports whether there was an element to pop, and whether the
+	// list is now empty, in which case the key is removed too.
+	ListPop(kv KV, left bool, t Transaction) (value string, popped bool, emptied bool, err error)
+	// SetAdd adds the members to the set, creating it if the key doesn't
+	// exist, and returns how many weren't members already
+	SetAdd(kv KV, members []string, t Transaction) (int, error)
+	SetIsMember(kv KV, member string) (bool, error)
 	DeleteByKey(KV, Transaction) (int, error)
 	InitTransaction() (Transaction, error)
+	OnExpire(ExpiryHandler)
+	KeyspaceInfo() (KeyspaceInfo, error)
+	CountByType() (map[string]int, error)
+	// DeleteExpired removes up to limit expired keys, calling the expiry
+	// handlers for each, and returns how many it removed
+	DeleteExpired(limit int) (int, error)
+	// IsTransient reports whether the error aborted a transaction through no
+	// fault of its own, such as a conflict with a transaction of another
+	// server, so that running the transaction again from the start can
+	// succeed
+	IsTransient(error) bool
+	Close() error
 }
 
-type JSONB map[string]interface{}
-
-func (a JSONB) Value() (driver.Value, error) {
-	return json.Marshal(a)
+// KeyspaceInfo describes the keys that haven't expired, for INFO keyspace.
+// AvgTTL is in milliseconds.
+type KeyspaceInfo struct {
+	Keys    int
+	Expires int
+	AvgTTL  int
 }
 
-func (a *JSONB) Scan(value interface{}) error {
-	b, ok := value.([]byte)
-	if !ok {
-		return fmt.Errorf("type assertion to []byte failed")
-	}
-	return json.Unmarshal(b, &a)
+// Saver is implemented by stores that can write a snapshot of their data,
+// which SHUTDOWN SAVE uses. Stores that persist every write don't need to.
+type Saver interface {
+	Save() error
 }
 
+// ExpiryHandler is called with the key of every expired entry a store removes
+type ExpiryHandler func(key string)
+
+type JSONB map[string]interface{}
+
+// KV is a key with its value. Lists and sets are kept in their own tables by
+// PostgresStore, so Arr and Set aren't columns of kvs.
 type KV struct {
 	Typ string `gorm:"not null"`
 	Key string `gorm:"index:idx_name,unique;not null"`
 	Str string
-	Arr pq.StringArray `gorm:"type:varchar[]"`
-	Set JSONB
-	Exp int `gorm:"not null"`
+	Arr pq.StringArray `gorm:"-"`
+	Set JSONB          `gorm:"-"`
+	Exp int            `gorm:"not null"`
+}
+
+// Values of storage-backend
+const (
+	BACKEND_POSTGRES = "postgres"
+	BACKEND_FILE     = "file"
+	BACKEND_MONGODB  = "mongodb"
+)
+
+// Options chooses the backend of the store and configures it
+type Options struct {
+	Backend string
+	// Connection string of the postgres backend
+	DSN string
+	// Log of the file backend and how often it is synced, one of the
+	// FSYNC values
+	File        string
+	AppendFsync string
+	// Connection string, database and collection of the mongodb backend
+	MongoURI        string
+	MongoDatabase   string
+	MongoCollection string
 }
 
-func InitStore() (Store, error) {
-	s := NewPostgresStore()
+func InitStore(options Options) (Store, error) {
+	var s Store
+	switch options.Backend {
+	case BACKEND_FILE:
+		store := NewFileStore(options.File, options.AppendFsync)
+		s = &store
+	case BACKEND_MONGODB:
+		store := NewMongoStore(options.MongoURI, options.MongoDatabase, options.MongoCollection)
+		s = &store
+	default:
+		store := NewPostgresStore(options.DSN)
+		s = &store
+	}
 
 	if err := s.init(); err != nil {
-		return &s, err
+		return s, err
 	}
 
-	return &s, nil
+	return s, nil
 }
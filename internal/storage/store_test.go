@@ -0,0 +1,568 @@
+package storage
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// storeOpener opens a store over data of its own for a test. Calling it
+// again after the store is closed opens the same data, as a restart would.
+type storeOpener func() Store
+
+// testStore runs the tests every Store has to pass. newOpener is called once
+// per test, with data nothing else uses.
+func testStore(t *testing.T, newOpener func(t *testing.T) storeOpener) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, open storeOpener)
+	}{
+		{"commit", testStoreCommit},
+		{"abort", testStoreAbort},
+		{"transaction reads its writes", testStoreReadOwnWrites},
+		{"lists", testStoreLists},
+		{"sets", testStoreSets},
+		{"delete", testStoreDelete},
+		{"expiry", testStoreExpiry},
+		{"counts", testStoreCounts},
+		{"get for update", testStoreGetForUpdate},
+		{"concurrent writes", testStoreConcurrentWrites},
+		{"reopen", testStoreReopen},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newOpener(t))
+		})
+	}
+}
+
+// openStore opens the store and closes it at the end of the test
+func openStore(t *testing.T, open storeOpener) Store {
+	t.Helper()
+
+	s := open()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// update runs fn in a transaction and commits it
+func update(t *testing.T, s Store, fn func(tx Transaction)) {
+	t.Helper()
+
+	tx, err := s.InitTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn(tx)
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustGet(t *testing.T, s Store, key string) (KV, bool) {
+	t.Helper()
+
+	v, ok, err := s.GetByKey(KV{Key: key})
+	if err != nil {
+		t.Fatalf("GetByKey(%q): %v", key, err)
+	}
+	return v, ok
+}
+
+func members(kv KV) []string {
+	m := []string{}
+	for member := range kv.Set {
+		m = append(m, member)
+	}
+	slices.Sort(m)
+	return m
+}
+
+func testStoreCommit(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+
+	tx, err := s.InitTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKV(KV{Key: "k", Typ: "string", Str: "v"}, tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := mustGet(t, s, "k"); ok {
+		t.Error("a write was visible before its transaction committed")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, ok := mustGet(t, s, "k")
+	if !ok || v.Typ != "string" || v.Str != "v" {
+		t.Errorf("GetByKey returned %+v, %v after commit", v, ok)
+	}
+	if exists, err := s.Exists(KV{Key: "k"}); err != nil || !exists {
+		t.Errorf("Exists returned %v, %v after commit", exists, err)
+	}
+}
+
+func testStoreAbort(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "kept", Typ: "string", Str: "before"}, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	tx, err := s.InitTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKV(KV{Key: "kept", Typ: "string", Str: "after"}, tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ListPush(KV{Key: "list"}, []string{"a"}, true, tx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SetAdd(KV{Key: "set"}, []string{"a"}, tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Abort(); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := mustGet(t, s, "kept"); v.Str != "before" {
+		t.Errorf("the value is %q after an aborted write, want %q", v.Str, "before")
+	}
+	for _, key := range []string{"list", "set"} {
+		if _, ok := mustGet(t, s, key); ok {
+			t.Errorf("%s was created by an aborted transaction", key)
+		}
+	}
+}
+
+func testStoreReadOwnWrites(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "k", Typ: "string", Str: "v"}, tx); err != nil {
+			t.Fatal(err)
+		}
+		v, ok, err := s.GetForUpdate(KV{Key: "k"}, tx)
+		if err != nil || !ok || v.Str != "v" {
+			t.Errorf("GetForUpdate returned %+v, %v, %v after SetKV in the same transaction", v, ok, err)
+		}
+
+		if _, err := s.ListPush(KV{Key: "list"}, []string{"a", "b"}, false, tx); err != nil {
+			t.Fatal(err)
+		}
+		v, ok, err = s.GetTypeForUpdate(KV{Key: "list"}, tx)
+		if err != nil || !ok || v.Typ != "list" {
+			t.Errorf("GetTypeForUpdate returned %+v, %v, %v after ListPush in the same transaction", v, ok, err)
+		}
+	})
+}
+
+func testStoreLists(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+
+	update(t, s, func(tx Transaction) {
+		if n, err := s.ListPush(KV{Key: "list"}, []string{"b", "a"}, true, tx); err != nil || n != 2 {
+			t.Errorf("ListPush to the head returned %d, %v, want 2", n, err)
+		}
+		if n, err := s.ListPush(KV{Key: "list"}, []string{"c", "d"}, false, tx); err != nil || n != 4 {
+			t.Errorf("ListPush to the tail returned %d, %v, want 4", n, err)
+		}
+	})
+
+	v, ok := mustGet(t, s, "list")
+	if want := []string{"a", "b", "c", "d"}; !ok || v.Typ != "list" || !slices.Equal(v.Arr, want) {
+		t.Errorf("the list is %+v, %v, want %q", v, ok, want)
+	}
+	if v, ok, err := s.GetTypeByKey(KV{Key: "list"}); err != nil || !ok || v.Typ != "list" {
+		t.Errorf("GetTypeByKey returned %+v, %v, %v", v, ok, err)
+	}
+
+	pops := []struct {
+		left    bool
+		value   string
+		emptied bool
+	}{
+		{true, "a", false},
+		{false, "d", false},
+		{false, "c", false},
+		{true, "b", true},
+	}
+	for _, pop := range pops {
+		update(t, s, func(tx Transaction) {
+			value, popped, emptied, err := s.ListPop(KV{Key: "list"}, pop.left, tx)
+			if err != nil || value != pop.value || !popped || emptied != pop.emptied {
+				t.Errorf("ListPop(left: %v) returned %q, %v, %v, %v, want %q, true, %v", pop.left, value, popped, emptied, err, pop.value, pop.emptied)
+			}
+		})
+	}
+
+	if _, ok := mustGet(t, s, "list"); ok {
+		t.Error("the list still exists after popping every element")
+	}
+
+	update(t, s, func(tx Transaction) {
+		if value, popped, _, err := s.ListPop(KV{Key: "list"}, true, tx); err != nil || popped {
+			t.Errorf("ListPop of a missing list returned %q, %v, %v, want nothing popped", value, popped, err)
+		}
+	})
+}
+
+func testStoreSets(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+
+	update(t, s, func(tx Transaction) {
+		if n, err := s.SetAdd(KV{Key: "set"}, []string{"a", "b"}, tx); err != nil || n != 2 {
+			t.Errorf("SetAdd returned %d, %v, want 2", n, err)
+		}
+	})
+	update(t, s, func(tx Transaction) {
+		if n, err := s.SetAdd(KV{Key: "set"}, []string{"b", "c"}, tx); err != nil || n != 1 {
+			t.Errorf("SetAdd of one new member returned %d, %v, want 1", n, err)
+		}
+	})
+
+	v, ok := mustGet(t, s, "set")
+	if want := []string{"a", "b", "c"}; !ok || v.Typ != "set" || !slices.Equal(members(v), want) {
+		t.Errorf("the set is %+v, %v, want %q", v, ok, want)
+	}
+	for member, want := range map[string]bool{"a": true, "c": true, "d": false} {
+		if got, err := s.SetIsMember(KV{Key: "set"}, member); err != nil || got != want {
+			t.Errorf("SetIsMember(%q) returned %v, %v, want %v", member, got, err, want)
+		}
+	}
+
+	// SetKV replaces the members of a set
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "set", Typ: "set", Set: JSONB{"z": struct{}{}}}, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if v, _ := mustGet(t, s, "set"); !slices.Equal(members(v), []string{"z"}) {
+		t.Errorf("the set is %q after SetKV, want [z]", members(v))
+	}
+}
+
+func testStoreDelete(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "k", Typ: "string", Str: "v"}, tx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.ListPush(KV{Key: "list"}, []string{"a"}, true, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	update(t, s, func(tx Transaction) {
+		for key, want := range map[string]int{"k": 1, "list": 1, "missing": 0} {
+			if n, err := s.DeleteByKey(KV{Key: key}, tx); err != nil || n != want {
+				t.Errorf("DeleteByKey(%q) returned %d, %v, want %d", key, n, err, want)
+			}
+		}
+	})
+
+	for _, key := range []string{"k", "list"} {
+		if exists, err := s.Exists(KV{Key: key}); err != nil || exists {
+			t.Errorf("Exists(%q) returned %v, %v after it was deleted", key, exists, err)
+		}
+	}
+
+	// A list created again after being deleted doesn't get its old elements
+	update(t, s, func(tx Transaction) {
+		if _, err := s.ListPush(KV{Key: "list"}, []string{"b"}, true, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if v, _ := mustGet(t, s, "list"); !slices.Equal(v.Arr, []string{"b"}) {
+		t.Errorf("the recreated list is %q, want [b]", v.Arr)
+	}
+}
+
+func testStoreExpiry(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+	expired := []string{}
+	mutex := sync.Mutex{}
+	s.OnExpire(func(key string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		expired = append(expired, key)
+	})
+
+	now := int(time.Now().UnixMilli())
+	update(t, s, func(tx Transaction) {
+		for _, kv := range []KV{
+			{Key: "past", Typ: "string", Str: "v", Exp: now - 1000},
+			{Key: "read", Typ: "string", Str: "v", Exp: now - 1000},
+			{Key: "future", Typ: "string", Str: "v", Exp: now + 3600*1000},
+		} {
+			if err := s.SetKV(kv, tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if _, ok := mustGet(t, s, "read"); ok {
+		t.Error("GetByKey returned an expired key")
+	}
+	if exists, err := s.Exists(KV{Key: "read"}); err != nil || exists {
+		t.Errorf("Exists returned %v, %v for an expired key", exists, err)
+	}
+	if v, ok := mustGet(t, s, "future"); !ok || v.Exp != now+3600*1000 {
+		t.Errorf("GetByKey returned %+v, %v for a key that hasn't expired", v, ok)
+	}
+
+	if n, err := s.DeleteExpired(10); err != nil || n != 1 {
+		t.Errorf("DeleteExpired returned %d, %v, want 1", n, err)
+	}
+	mutex.Lock()
+	slices.Sort(expired)
+	if !slices.Equal(expired, []string{"past", "read"}) {
+		t.Errorf("the expiry handlers were called with %q, want [past read]", expired)
+	}
+	mutex.Unlock()
+
+	// Writing to an expired key starts it afresh
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "stale", Typ: "list", Arr: []string{"old"}, Exp: now - 1000}, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	update(t, s, func(tx Transaction) {
+		if _, ok, err := s.GetTypeForUpdate(KV{Key: "stale"}, tx); err != nil || ok {
+			t.Errorf("GetTypeForUpdate returned %v, %v for an expired key", ok, err)
+		}
+		if n, err := s.ListPush(KV{Key: "stale"}, []string{"new"}, true, tx); err != nil || n != 1 {
+			t.Errorf("ListPush to an expired list returned %d, %v, want 1", n, err)
+		}
+	})
+	if v, _ := mustGet(t, s, "stale"); !slices.Equal(v.Arr, []string{"new"}) || v.Exp != 0 {
+		t.Errorf("the list pushed to after expiring is %+v, want [new] without an expiry", v)
+	}
+}
+
+func testStoreCounts(t *testing.T, open storeOpener) {
+	s := openStore(t, open)
+	now := int(time.Now().UnixMilli())
+	update(t, s, func(tx Transaction) {
+		for _, kv := range []KV{
+			{Key: "a", Typ: "string", Str: "v"},
+			{Key: "b", Typ: "string", Str: "v", Exp: now + 3600*1000},
+			{Key: "c", Typ: "string", Str: "v", Exp: now - 1000},
+		} {
+			if err := s.SetKV(kv, tx); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.ListPush(KV{Key: "list"}, []string{"a"}, true, tx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.SetAdd(KV{Key: "set"}, []string{"a"}, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	info, err := s.KeyspaceInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Keys != 4 || info.Expires != 1 || info.AvgTTL <= 0 || info.AvgTTL > 3600*1000 {
+		t.Errorf("KeyspaceInfo returned %+v, want 4 keys and 1 expiring within the hour", info)
+	}
+
+	counts, err := s.CountByType()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]int{"string": 2, "list": 1, "set": 1}; !maps.Equal(counts, want) {
+		t.Errorf("CountByType returned %v, want %v", counts, want)
+	}
+}
+
+// Transactions reading a key with GetForUpdate and writing it back run one
+// after the other, so none of their writes are lost
+func testStoreGetForUpdate(t *testing.T, open storeOpener) {
+	const workers = 8
+	const writes = 20
+
+	s := openStore(t, open)
+	wg := sync.WaitGroup{}
+	errs := make(chan error, workers*writes)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				if err := appendValue(s, "list", fmt.Sprintf("%d-%d", i, j)); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if v, _ := mustGet(t, s, "list"); len(v.Arr) != workers*writes {
+		t.Errorf("the list has %d values, want %d", len(v.Arr), workers*writes)
+	}
+}
+
+func appendValue(s Store, key string, value string) error {
+	tx, err := s.InitTransaction()
+	if err != nil {
+		return err
+	}
+
+	v, ok, err := s.GetForUpdate(KV{Key: key}, tx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		v = KV{Key: key, Typ: "list"}
+	}
+	v.Arr = append(v.Arr, value)
+
+	if err := s.SetKV(v, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Transactions pushing to the same list and adding to the same set at once,
+// locking the key with GetTypeForUpdate as LPUSH and SADD do, lose none of
+// each other's writes. Transactions failing with a transient error are run
+// again, as commands are.
+func testStoreConcurrentWrites(t *testing.T, open storeOpener) {
+	const workers = 8
+	const writes = 20
+
+	s := openStore(t, open)
+	wg := sync.WaitGroup{}
+	errs := make(chan error, workers*writes)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				member := fmt.Sprintf("%d-%d", i, j)
+				err := retryTransient(s, func() error {
+					return addToList(s, "list", member)
+				})
+				if err == nil {
+					err = retryTransient(s, func() error {
+						return addToSet(s, "set", member)
+					})
+				}
+				if err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if v, _ := mustGet(t, s, "list"); len(v.Arr) != workers*writes {
+		t.Errorf("the list has %d values, want %d", len(v.Arr), workers*writes)
+	}
+	if v, _ := mustGet(t, s, "set"); len(v.Set) != workers*writes {
+		t.Errorf("the set has %d members, want %d", len(v.Set), workers*writes)
+	}
+}
+
+// retryTransient runs fn until it succeeds or fails with an error the store
+// doesn't report as transient
+func retryTransient(s Store, fn func() error) error {
+	for {
+		if err := fn(); err == nil || !s.IsTransient(err) {
+			return err
+		}
+	}
+}
+
+func addToList(s Store, key string, value string) error {
+	tx, err := s.InitTransaction()
+	if err != nil {
+		return err
+	}
+	if _, _, err := s.GetTypeForUpdate(KV{Key: key}, tx); err != nil {
+		return err
+	}
+	if _, err := s.ListPush(KV{Key: key}, []string{value}, true, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func addToSet(s Store, key string, member string) error {
+	tx, err := s.InitTransaction()
+	if err != nil {
+		return err
+	}
+	if _, _, err := s.GetTypeForUpdate(KV{Key: key}, tx); err != nil {
+		return err
+	}
+	if _, err := s.SetAdd(KV{Key: key}, []string{member}, tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Committed writes outlive the store, and aborted ones don't
+func testStoreReopen(t *testing.T, open storeOpener) {
+	s := open()
+	update(t, s, func(tx Transaction) {
+		if err := s.SetKV(KV{Key: "k", Typ: "string", Str: "v"}, tx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.ListPush(KV{Key: "list"}, []string{"a", "b"}, false, tx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.SetAdd(KV{Key: "set"}, []string{"a", "b"}, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	update(t, s, func(tx Transaction) {
+		if _, _, _, err := s.ListPop(KV{Key: "list"}, true, tx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	tx, err := s.InitTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKV(KV{Key: "aborted", Typ: "string", Str: "v"}, tx); err != nil {
+		t.Fatal(err)
+	}
+	tx.Abort()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openStore(t, open)
+	if v, ok := mustGet(t, s, "k"); !ok || v.Str != "v" {
+		t.Errorf("GetByKey returned %+v, %v after reopening", v, ok)
+	}
+	if v, _ := mustGet(t, s, "list"); !slices.Equal(v.Arr, []string{"b"}) {
+		t.Errorf("the list is %q after reopening, want [b]", v.Arr)
+	}
+	if v, _ := mustGet(t, s, "set"); !slices.Equal(members(v), []string{"a", "b"}) {
+		t.Errorf("the set is %q after reopening, want [a b]", members(v))
+	}
+	if _, ok := mustGet(t, s, "aborted"); ok {
+		t.Error("an aborted write was there after reopening")
+	}
+}
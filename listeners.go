@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
+	"github.com/mmacdo54/go-redis-clone/internal/certs"
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/handlers"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// Clients that haven't finished the TLS handshake by then are disconnected
+const TLS_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+// listen opens plaintext and TLS listeners on every bind address, or on all
+// interfaces if none are configured. Either kind is disabled by setting its
+// port to 0.
+func listen(config *configuration.Config) ([]net.Listener, error) {
+	values := config.Get()
+	if values.Port == 0 && values.TLSPort == 0 && values.UnixSocket == "" {
+		return nil, fmt.Errorf("No listeners configured, set port, tls-port or unixsocket")
+	}
+
+	listeners := []net.Listener{}
+	if values.Port != 0 {
+		l, err := listenTCP(values.Bind, values.Port, nil)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l...)
+	}
+
+	if values.TLSPort != 0 {
+		l, err := listenTCP(values.Bind, values.TLSPort, certs.ServerConfig())
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l...)
+	}
+
+	if values.UnixSocket != "" {
+		l, err := listenUnix(values.UnixSocket, values.UnixSocketPerm)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// listenTCP listens on a port of each address, wrapping the listeners in TLS
+// if a config is given. Addresses prefixed with - are optional and are
+// skipped if they can't be bound.
+func listenTCP(addresses []string, port int, tlsConfig *tls.Config) ([]net.Listener, error) {
+	if len(addresses) == 0 {
+		addresses = []string{"*"}
+	}
+
+	kind := "tcp"
+	if tlsConfig != nil {
+		kind = "tls"
+	}
+
+	listeners := []net.Listener{}
+	for _, a := range addresses {
+		optional := strings.HasPrefix(a, "-")
+		host := strings.TrimPrefix(a, "-")
+		if host == "*" {
+			host = ""
+		}
+
+		l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+		if err != nil {
+			if optional {
+				logger.Warning("Skipping optional bind address %s: %v", a, err)
+				continue
+			}
+			return nil, err
+		}
+		logger.Notice("Listening for %s connections on %s", kind, l.Addr())
+
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		listeners = append(listeners, l)
+	}
+
+	return listeners, nil
+}
+
+// listenUnix listens on a unix socket, replacing a socket file left behind
+// by a server that didn't stop cleanly. The file is removed again when the
+// listener is closed.
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if c, err := net.Dial("unix", path); err == nil {
+			c.Close()
+			return nil, fmt.Errorf("Unix socket %s is in use by another server", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	logger.Notice("Listening for unix socket connections on %s", path)
+
+	return l, nil
+}
+
+func acceptConnections(l net.Listener, store storage.Store, config *configuration.Config) {
+	for {
+		conn, err := l.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			logger.Warning("Accepting client connection: %v", err)
+			continue
+		}
+		// Connections are refused while a shutdown waits for commands to
+		// finish, but accepted again if it is aborted
+		if handlers.ShuttingDown() {
+			conn.Close()
+			continue
+		}
+
+		raw := conn
+		if tc, ok := conn.(*tls.Conn); ok {
+			raw = tc.NetConn()
+		}
+		keepalive := config.Get().TCPKeepalive
+		if tc, ok := raw.(*net.TCPConn); ok && keepalive > 0 {
+			tc.SetKeepAlive(true)
+			tc.SetKeepAlivePeriod(time.Duration(keepalive) * time.Second)
+		}
+
+		go handleConnection(conn, store, config)
+	}
+}
+
+// loadCertificates (re)loads the TLS certificates, at startup and whenever
+// one of the tls-* parameters is changed with CONFIG SET
+func loadCertificates(values configuration.Values) error {
+	return certs.Load(values.TLSCertFile, values.TLSKeyFile, values.TLSCACertFile, values.TLSAuthClients)
+}
+
+// tlsHandshake completes the handshake and, if tls-auth-clients-user is cn,
+// logs the client in as the user named by its certificate's CN
+func tlsHandshake(tc *tls.Conn, c *connection.Connection, config *configuration.Config) error {
+	tc.SetDeadline(time.Now().Add(TLS_HANDSHAKE_TIMEOUT))
+	if err := tc.Handshake(); err != nil {
+		return err
+	}
+	tc.SetDeadline(time.Time{})
+
+	if config.Get().TLSAuthClientsUser != "cn" {
+		return nil
+	}
+
+	cn := certs.ClientCommonName(tc.ConnectionState())
+	if u := acl.GetUser(cn); cn != "" && u != nil && u.Enabled {
+		c.SetUser(cn)
+		c.SetValidated(true)
+	}
+
+	return nil
+}
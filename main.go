@@ -1,74 +1,195 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"time"
 
+	"github.com/mmacdo54/go-redis-clone/internal/acl"
 	"github.com/mmacdo54/go-redis-clone/internal/configuration"
 	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/executor"
 	"github.com/mmacdo54/go-redis-clone/internal/handlers"
+	"github.com/mmacdo54/go-redis-clone/internal/latency"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
 	"github.com/mmacdo54/go-redis-clone/internal/resp"
+	"github.com/mmacdo54/go-redis-clone/internal/slowlog"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
 	"github.com/mmacdo54/go-redis-clone/internal/storage"
 )
 
 func main() {
-	store, err := storage.InitStore()
+	config, err := configuration.InitConfig(os.Args[1:])
 	if err != nil {
 		fmt.Println(err)
-		panic(err)
+		os.Exit(1)
 	}
 
-	config, err := configuration.InitConfig()
-	if err != nil {
+	values := config.Get()
+	if err := logger.Init(values.LogLevel, values.LogFile); err != nil {
 		fmt.Println(err)
-		panic(err)
+		os.Exit(1)
+	}
+
+	if err := os.Chdir(values.Dir); err != nil {
+		exitWithError(err)
+	}
+
+	if err := acl.Init(values.Requirepass, values.ACLFile); err != nil {
+		exitWithError(err)
+	}
+	acl.SetLogMaxLen(values.ACLLogMaxLen)
+
+	config.OnChange("requirepass", func(v configuration.Values) error {
+		acl.SetDefaultPassword(v.Requirepass)
+		return nil
+	})
+	config.OnChange("acllog-max-len", func(v configuration.Values) error {
+		acl.SetLogMaxLen(v.ACLLogMaxLen)
+		return nil
+	})
+	if values.TLSPort != 0 {
+		if err := loadCertificates(values); err != nil {
+			exitWithError(err)
+		}
+		for _, name := range []string{"tls-cert-file", "tls-key-file", "tls-ca-cert-file", "tls-auth-clients"} {
+			config.OnChange(name, loadCertificates)
+		}
 	}
 
-	l, err := net.Listen("tcp", ":6379")
+	connection.SetOutputBufferLimits(values.ClientOutputBufferLimits)
+	config.OnChange("client-output-buffer-limit", func(v configuration.Values) error {
+		connection.SetOutputBufferLimits(v.ClientOutputBufferLimits)
+		return nil
+	})
+	slowlog.SetOptions(values.SlowlogLogSlowerThan, values.SlowlogMaxLen)
+	for _, name := range []string{"slowlog-log-slower-than", "slowlog-max-len"} {
+		config.OnChange(name, func(v configuration.Values) error {
+			slowlog.SetOptions(v.SlowlogLogSlowerThan, v.SlowlogMaxLen)
+			return nil
+		})
+	}
+	latency.SetThreshold(values.LatencyMonitorThreshold)
+	config.OnChange("latency-monitor-threshold", func(v configuration.Values) error {
+		latency.SetThreshold(v.LatencyMonitorThreshold)
+		return nil
+	})
+	config.OnChange("loglevel", func(v configuration.Values) error {
+		return logger.SetLevel(v.LogLevel)
+	})
+	config.OnChange("dir", func(v configuration.Values) error {
+		return os.Chdir(v.Dir)
+	})
+
+	storage.SetObserver(func(op string, duration time.Duration, err error) {
+		stats.RecordStorage(op, duration, err)
+		latency.AddSample(latency.EVENT_STORAGE+op, duration)
+	})
+	store, err := storage.InitStore(storage.Options{
+		Backend:     values.StorageBackend,
+		DSN:         values.StorageDSN,
+		File:        values.StorageFile,
+		AppendFsync: values.Appendfsync,
+
+		MongoURI:        values.MongoDBURI,
+		MongoDatabase:   values.MongoDBDatabase,
+		MongoCollection: values.MongoDBCollection,
+	})
 	if err != nil {
-		fmt.Println(err)
-		panic(err)
+		exitWithError(err)
 	}
-	fmt.Println("Listening for tcp connections on port 6379")
 
-	for {
-		conn, err := l.Accept()
+	store.OnExpire(func(key string) {
+		handlers.KeyExpired(key, config)
+	})
+
+	if err := handlers.StartPubSubRelay(store, config); err != nil {
+		exitWithError(err)
+	}
+
+	if err := handlers.StartChangeFeed(store, config); err != nil {
+		exitWithError(err)
+	}
+
+	executor.Start(values.CommandExecutor, values.CommandExecutorShards)
+
+	listeners, err := listen(config)
+	if err != nil {
+		exitWithError(err)
+	}
+
+	for _, l := range listeners {
+		go acceptConnections(l, store, config)
+	}
+
+	if values.MetricsPort != 0 {
+		l, err := serveMetrics(values.MetricsPort, store)
 		if err != nil {
-			fmt.Println(err)
-			continue
+			exitWithError(err)
 		}
-
-		go handleConnection(conn, store, config)
+		// Only kept so that it is closed on shutdown
+		listeners = append(listeners, l)
 	}
+	go serverCron(store, config)
+	logger.Notice("Ready to accept connections")
+
+	s := server{listeners: listeners, store: store, config: config}
+	s.waitForShutdown()
 }
 
-func handleConnection(conn net.Conn, store storage.Store, config configuration.Config) {
+func exitWithError(err error) {
+	logger.Warning("%v", err)
+	os.Exit(1)
+}
+
+func handleConnection(conn net.Conn, store storage.Store, config *configuration.Config) {
 	defer conn.Close()
-	c := connection.NewConnection(&conn)
-	if !config.Requirepass {
-		c.Validated = true
+	c := connection.NewConnection(conn)
+	// Replies still queued when the loop ends are flushed before closing
+	defer c.Flush()
+
+	if err := connection.Register(c, config.Get().MaxClients); err != nil {
+		stats.RejectedConnections.Add(1)
+		c.Write(resp.RespValue{Type: resp.TYPE_ERROR, Str: "ERR " + err.Error()})
+		return
+	}
+	defer connection.Unregister(c)
+	stats.ConnectionsReceived.Add(1)
+	defer handlers.RemoveConnection(c)
+	c.SetValidated(acl.AuthenticatedByDefault())
+
+	if tc, ok := conn.(*tls.Conn); ok {
+		if err := tlsHandshake(tc, c, config); err != nil {
+			logger.Verbose("Error accepting a client connection: %v", err)
+			return
+		}
 	}
 
 	for {
-		reader := resp.NewRespReader(conn)
-		writer := resp.NewRespWriter(conn)
-		val, err := reader.ReadResp()
+		val, err := c.ReadCommand()
 
 		if err != nil {
 			if err == io.EOF {
-				fmt.Println("Client disconnected")
+				logger.Verbose("Client closed connection")
 				break
 			}
-			if err := writer.WriteErrorResp(err); err != nil {
-				fmt.Println(err)
+			if err := c.Write(resp.RespValue{Type: resp.TYPE_ERROR, Str: err.Error()}); err != nil {
+				logger.Verbose("Error writing to client: %v", err)
 			}
+			break
 		}
 
-		response := handlers.HandleRespValue(val, &c, store, config)
+		response := handlers.HandleRespValue(val, c, store, config)
+
+		if c.ShouldReply() && response.Type != resp.TYPE_VOID {
+			c.Write(response)
+		}
 
-		if response.Type != resp.TYPE_VOID {
-			writer.WriteResp(response)
+		if c.CloseAfterReply() {
+			break
 		}
 	}
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"runtime"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/handlers"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/metrics"
+	"github.com/mmacdo54/go-redis-clone/internal/stats"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// serveMetrics serves Prometheus metrics on /metrics of the port. The
+// listener is returned so that it is closed on shutdown.
+func serveMetrics(port int, store storage.Store) (net.Listener, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	logger.Notice("Serving metrics on http://%s/metrics", l.Addr())
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write([]byte(collectMetrics(store)))
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, net.ErrClosed) {
+			logger.Warning("Serving metrics: %v", err)
+		}
+	}()
+
+	return l, nil
+}
+
+func collectMetrics(store storage.Store) string {
+	w := metrics.NewWriter()
+
+	w.Gauge("redis_uptime_in_seconds", "Seconds since the server started.", time.Since(stats.StartedAt).Seconds())
+
+	m := runtime.MemStats{}
+	runtime.ReadMemStats(&m)
+	w.Gauge("redis_memory_used_bytes", "Heap memory in use.", float64(m.HeapAlloc))
+
+	w.Gauge("redis_connected_clients", "Connected clients.", float64(len(connection.All())))
+	w.Gauge("redis_blocked_clients", "Clients held back by CLIENT PAUSE.", float64(handlers.BlockedClients()))
+	w.Counter("redis_connections_received_total", "Connections accepted.", float64(stats.ConnectionsReceived.Load()))
+	w.Counter("redis_rejected_connections_total", "Connections refused because of maxclients.", float64(stats.RejectedConnections.Load()))
+
+	channels, subscriptions := handlers.PubSubCounts(false)
+	shardChannels, shardSubscriptions := handlers.PubSubCounts(true)
+	w.Gauge("redis_pubsub_channels", "Channels with at least one subscriber.", float64(channels), "type", "global")
+	w.Gauge("redis_pubsub_channels", "Channels with at least one subscriber.", float64(shardChannels), "type", "shard")
+	w.Gauge("redis_pubsub_subscribers", "Subscriptions to channels.", float64(subscriptions), "type", "global")
+	w.Gauge("redis_pubsub_subscribers", "Subscriptions to channels.", float64(shardSubscriptions), "type", "shard")
+
+	w.Counter("redis_commands_processed_total", "Commands run.", float64(stats.CommandsProcessed.Load()))
+	w.Counter("redis_error_replies_total", "Error replies sent.", float64(stats.ErrorReplies.Load()))
+	w.Counter("redis_keyspace_hits_total", "Reads of keys that existed.", float64(stats.KeyspaceHits.Load()))
+	w.Counter("redis_keyspace_misses_total", "Reads of keys that did not exist.", float64(stats.KeyspaceMisses.Load()))
+	w.Counter("redis_expired_keys_total", "Keys removed because they expired.", float64(stats.ExpiredKeys.Load()))
+	w.Counter("redis_evicted_keys_total", "Keys evicted to free memory.", float64(stats.EvictedKeys.Load()))
+
+	writeCommandMetrics(w)
+	writeKeyMetrics(w, store)
+	writeStorageMetrics(w)
+
+	return w.String()
+}
+
+func writeCommandMetrics(w *metrics.Writer) {
+	commands := stats.Commands()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		w.Counter("redis_commands_total", "Calls of each command.", float64(commands[name].Calls), "cmd", name)
+	}
+	for _, name := range names {
+		w.Counter("redis_commands_failed_calls_total", "Calls of each command that returned an error.", float64(commands[name].FailedCalls), "cmd", name)
+	}
+	for _, name := range names {
+		w.Counter("redis_commands_rejected_calls_total", "Calls of each command refused before running, e.g. by ACLs.", float64(commands[name].RejectedCalls), "cmd", name)
+	}
+	for _, name := range names {
+		c := commands[name]
+		w.Histogram("redis_command_duration_seconds", "Time spent running each command.", stats.LatencyBuckets, c.Latency.Buckets, c.Latency.Count, c.Latency.Sum, "cmd", name)
+	}
+}
+
+// writeKeyMetrics reports key counts from the store, skipping them if the
+// store can't be reached so that the other metrics are still served
+func writeKeyMetrics(w *metrics.Writer, store storage.Store) {
+	keyspace, err := store.KeyspaceInfo()
+	if err != nil {
+		logger.Warning("Collecting keyspace metrics: %v", err)
+		return
+	}
+	w.Gauge("redis_db_keys", "Keys in each database.", float64(keyspace.Keys), "db", "db0")
+	w.Gauge("redis_db_keys_expiring", "Keys with an expiry in each database.", float64(keyspace.Expires), "db", "db0")
+
+	counts, err := store.CountByType()
+	if err != nil {
+		logger.Warning("Collecting key type metrics: %v", err)
+		return
+	}
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+	for _, t := range types {
+		w.Gauge("redis_keys", "Keys of each type.", float64(counts[t]), "type", t)
+	}
+}
+
+func writeStorageMetrics(w *metrics.Writer) {
+	operations := stats.StorageOperations()
+	ops := make([]string, 0, len(operations))
+	for op := range operations {
+		ops = append(ops, op)
+	}
+	slices.Sort(ops)
+
+	for _, op := range ops {
+		o := operations[op]
+		w.Histogram("redis_storage_operation_duration_seconds", "Time spent in each kind of storage call. transaction covers a whole transaction.", stats.LatencyBuckets, o.Latency.Buckets, o.Latency.Count, o.Latency.Sum, "op", op)
+	}
+	for _, op := range ops {
+		w.Counter("redis_storage_operation_errors_total", "Storage calls that failed.", float64(operations[op].Errors), "op", op)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mmacdo54/go-redis-clone/internal/configuration"
+	"github.com/mmacdo54/go-redis-clone/internal/connection"
+	"github.com/mmacdo54/go-redis-clone/internal/handlers"
+	"github.com/mmacdo54/go-redis-clone/internal/latency"
+	"github.com/mmacdo54/go-redis-clone/internal/logger"
+	"github.com/mmacdo54/go-redis-clone/internal/storage"
+)
+
+// server holds what the main goroutine needs to shut the server down
+type server struct {
+	listeners []net.Listener
+	store     storage.Store
+	config    *configuration.Config
+	signals   chan os.Signal
+}
+
+// waitForShutdown handles SHUTDOWN commands and SIGINT/SIGTERM until a
+// shutdown succeeds, then exits the process
+func (s *server) waitForShutdown() {
+	s.signals = make(chan os.Signal, 1)
+	signal.Notify(s.signals, syscall.SIGINT, syscall.SIGTERM)
+
+	for {
+		var request handlers.ShutdownRequest
+		select {
+		case sig := <-s.signals:
+			logger.Warning("Received %s scheduling shutdown...", signalName(sig))
+			request = handlers.ShutdownRequest{}
+		case request = <-handlers.ShutdownRequests:
+			logger.Warning("User requested shutdown...")
+		}
+
+		s.shutdown(request)
+	}
+}
+
+// shutdown stops the server. If the shutdown is aborted the server keeps
+// running and every SHUTDOWN waiting on it is told so.
+func (s *server) shutdown(request handlers.ShutdownRequest) {
+	requests := []handlers.ShutdownRequest{request}
+	fail := func() {
+		handlers.CancelShutdown()
+		for _, r := range requests {
+			if r.Result != nil {
+				r.Result <- handlers.ErrShutdownFailed
+			}
+		}
+	}
+
+	handlers.BeginShutdown()
+
+	if !request.Now {
+		now, err := s.waitForCommands()
+		if err != nil {
+			logger.Warning("%v", err)
+			fail()
+			return
+		}
+		if now != nil {
+			request = *now
+			requests = append(requests, request)
+		}
+	}
+	handlers.CommitShutdown()
+
+	status := 0
+	if err := s.save(request); err != nil {
+		if !request.Force {
+			logger.Warning("Error trying to save the DB, can't exit: %v", err)
+			fail()
+			return
+		}
+		logger.Warning("Error trying to save the DB, exiting anyway: %v", err)
+		status = 1
+	}
+
+	handlers.NotifySubscribers()
+
+	// Closing the listeners also removes the unix socket file
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	closeClients()
+
+	if err := s.store.Close(); err != nil {
+		logger.Warning("Error closing the storage: %v", err)
+		status = 1
+	}
+
+	logger.Warning("Redis is now ready to exit, bye bye...")
+	os.Exit(status)
+}
+
+// waitForCommands gives running commands up to shutdown-timeout seconds to
+// finish. SHUTDOWN ABORT cancels the shutdown, while SHUTDOWN NOW or a second
+// signal stop waiting. A SHUTDOWN NOW is returned so that its options are
+// used for the rest of the shutdown.
+func (s *server) waitForCommands() (*handlers.ShutdownRequest, error) {
+	timeout := time.Duration(s.config.Get().ShutdownTimeout) * time.Second
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	aborted := handlers.ShutdownAborted()
+	finished := handlers.CommandsFinished()
+	for {
+		select {
+		case <-finished:
+			return nil, nil
+		case <-timer.C:
+			logger.Warning("%d commands still running after %s, shutting down anyway", handlers.CommandsRunning(), timeout)
+			return nil, nil
+		case <-aborted:
+			return nil, errors.New("Shutdown aborted by SHUTDOWN ABORT")
+		case sig := <-s.signals:
+			logger.Warning("Received %s during shutdown, exiting now", signalName(sig))
+			os.Exit(1)
+		case other := <-handlers.ShutdownRequests:
+			if other.Now {
+				logger.Warning("SHUTDOWN NOW requested, not waiting for running commands")
+				return &other, nil
+			}
+			other.Result <- errors.New("Shutdown already in progress")
+		}
+	}
+}
+
+// save writes a snapshot if the store keeps its data in memory. Stores that
+// persist every write have nothing to save.
+func (s *server) save(request handlers.ShutdownRequest) error {
+	if request.NoSave {
+		return nil
+	}
+
+	saver, ok := s.store.(storage.Saver)
+	if !ok {
+		if request.Save {
+			logger.Notice("The storage persists every write, nothing to save")
+		}
+		return nil
+	}
+
+	logger.Notice("Saving the final snapshot before exiting.")
+	start := time.Now()
+	err := saver.Save()
+	latency.AddSample(latency.EVENT_SNAPSHOT, time.Since(start))
+	return err
+}
+
+// closeClients writes out the replies still queued for each client before
+// disconnecting it
+func closeClients() {
+	wg := sync.WaitGroup{}
+	for _, c := range connection.All() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Flush()
+			c.Conn.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+func signalName(sig os.Signal) string {
+	if sig == syscall.SIGINT {
+		return "SIGINT"
+	}
+	return "SIGTERM"
+}